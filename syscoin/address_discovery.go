@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+/******************* 恢复HD账户时按BIP44间隔限制发现已使用的地址 *******************/
+
+const (
+	//默认的地址间隔限制，连续这么多个未使用的地址后停止
+	defaultAddressGapLimit = 20
+
+	//BIP44的外部链和找零链
+	addressChainExternal = 0
+	addressChainChange   = 1
+)
+
+//核心钱包模式没有地址历史，无法判断地址是否使用过
+var errAddressHistoryNotSupported = errors.New("address discovery needs an explorer for address history, please use rpcServerType = 1 or setup auditServerAPI")
+
+//AddressDiscoveryResult 地址发现的结果
+type AddressDiscoveryResult struct {
+	AccountID        string
+	Addresses        []*openwallet.Address //两条链上从0到最后一个已使用地址的全部地址
+	UsedAddresses    []*openwallet.Address //已使用的地址
+	LastUsedExternal int64                 //外部链最后一个已使用地址的索引，-1为没有
+	LastUsedChange   int64                 //找零链最后一个已使用地址的索引，-1为没有
+}
+
+//DiscoverAccountAddresses 从账户公钥派生外部链和找零链的地址，通过浏览器查询是否使用过，
+//连续gapLimit个未使用地址后停止。wallet不为空时，把已使用的范围保存到钱包数据库
+func (wm *WalletManager) DiscoverAccountAddresses(wallet *openwallet.Wallet, account *openwallet.AssetsAccount, gapLimit int) (*AddressDiscoveryResult, error) {
+
+	if account == nil {
+		return nil, errors.New("assets account is empty")
+	}
+
+	if wm.ExplorerClient == nil {
+		return nil, errAddressHistoryNotSupported
+	}
+
+	if gapLimit <= 0 {
+		gapLimit = defaultAddressGapLimit
+	}
+
+	//单签账户的拥有者公钥就是账户公钥
+	derivable := *account
+	if len(derivable.OwnerKeys) == 0 {
+		if len(derivable.PublicKey) == 0 {
+			return nil, fmt.Errorf("account: %s public key is empty", account.AccountID)
+		}
+		derivable.OwnerKeys = []string{derivable.PublicKey}
+	}
+
+	derive := func(chain int64, index int) (*openwallet.Address, error) {
+		result := openwallet.CreateAddressByAccountWithIndex(&derivable, wm, index, chain)
+		if !result.Success {
+			return nil, result.Err
+		}
+		return result.Address, nil
+	}
+
+	result := &AddressDiscoveryResult{
+		AccountID:     account.AccountID,
+		Addresses:     make([]*openwallet.Address, 0),
+		UsedAddresses: make([]*openwallet.Address, 0),
+	}
+
+	for _, chain := range []int64{addressChainExternal, addressChainChange} {
+
+		addresses, used, lastUsed, err := discoverAddressChain(chain, gapLimit, derive, wm.addressesUsed)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Addresses = append(result.Addresses, addresses...)
+		result.UsedAddresses = append(result.UsedAddresses, used...)
+
+		if chain == addressChainExternal {
+			result.LastUsedExternal = lastUsed
+		} else {
+			result.LastUsedChange = lastUsed
+		}
+
+		wm.Log.Infof("account: %s chain: %d discovered %d used addresses, last used index: %d",
+			account.AccountID, chain, len(used), lastUsed)
+	}
+
+	if wallet != nil && len(result.Addresses) > 0 {
+		err := wm.saveAddressToDB(result.Addresses, wallet)
+		if err != nil {
+			return nil, err
+		}
+
+		//外部链的地址索引移到最后一个已使用地址，之后新建的地址从下一个开始
+		if result.LastUsedExternal > int64(account.AddressIndex) {
+			account.AddressIndex = int(result.LastUsedExternal)
+			err = wm.saveAccountToDB(account, wallet)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+//discoverAddressChain 按批次派生一条链上的地址并查询使用情况，返回从0到最后一个已使用地址的地址列表
+func discoverAddressChain(
+	chain int64,
+	gapLimit int,
+	derive func(chain int64, index int) (*openwallet.Address, error),
+	isUsed func(addresses []string) (map[string]bool, error)) ([]*openwallet.Address, []*openwallet.Address, int64, error) {
+
+	var (
+		all      = make([]*openwallet.Address, 0)
+		used     = make([]*openwallet.Address, 0)
+		lastUsed = int64(-1)
+	)
+
+	for index := 0; int64(index)-lastUsed-1 < int64(gapLimit); {
+
+		batch := make([]*openwallet.Address, 0, gapLimit)
+		list := make([]string, 0, gapLimit)
+		for i := 0; i < gapLimit; i++ {
+			a, err := derive(chain, index+i)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			batch = append(batch, a)
+			list = append(list, a.Address)
+		}
+
+		usage, err := isUsed(list)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		for i, a := range batch {
+			all = append(all, a)
+			if usage[a.Address] {
+				used = append(used, a)
+				lastUsed = int64(index + i)
+			}
+		}
+
+		index += gapLimit
+	}
+
+	return all[:lastUsed+1], used, lastUsed, nil
+}
+
+//addressesUsed 查询地址是否有过交易，需要有地址历史的数据源
+//节点没有地址索引，scantxoutset只能发现还有未花费输出的地址，因此统一使用浏览器查询交易数量
+func (wm *WalletManager) addressesUsed(addresses []string) (map[string]bool, error) {
+
+	if wm.ExplorerClient == nil {
+		return nil, errAddressHistoryNotSupported
+	}
+
+	return wm.addressesUsedByExplorer(addresses)
+}
+
+//addressesUsedByExplorer 通过浏览器查询地址的交易数量
+func (wm *WalletManager) addressesUsedByExplorer(addresses []string) (map[string]bool, error) {
+
+	used := make(map[string]bool, len(addresses))
+
+	for _, a := range addresses {
+		path := fmt.Sprintf("addr/%s?noTxList=1", a)
+
+		result, err := wm.ExplorerClient.Call(path, nil, "GET")
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Get("txApperances").Int() > 0 || result.Get("unconfirmedTxApperances").Int() > 0 {
+			used[a] = true
+		}
+	}
+
+	return used, nil
+}
+
+//saveAccountToDB 保存资产账户到钱包数据库
+func (wm *WalletManager) saveAccountToDB(account *openwallet.AssetsAccount, wallet *openwallet.Wallet) error {
+	db, err := wallet.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(account)
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestDiscoverAddressChain(t *testing.T) {
+
+	derive := func(chain int64, index int) (*openwallet.Address, error) {
+		return &openwallet.Address{
+			Address:  fmt.Sprintf("%d/%d", chain, index),
+			Index:    uint64(index),
+			IsChange: chain == addressChainChange,
+		}, nil
+	}
+
+	usedSet := map[string]bool{"0/3": true, "0/24": true, "1/0": true}
+	queries := 0
+	isUsed := func(addresses []string) (map[string]bool, error) {
+		queries++
+		used := make(map[string]bool)
+		for _, a := range addresses {
+			if usedSet[a] {
+				used[a] = true
+			}
+		}
+		return used, nil
+	}
+
+	all, used, lastUsed, err := discoverAddressChain(addressChainExternal, 10, derive, isUsed)
+	if err != nil {
+		t.Fatalf("discoverAddressChain failed unexpected error: %v", err)
+	}
+	//0/24在间隔10以内（3之后的第21个）不应被发现
+	if lastUsed != 3 || len(used) != 1 || len(all) != 4 {
+		t.Errorf("unexpected result: last used: %d, used: %d, all: %d", lastUsed, len(used), len(all))
+	}
+	if queries != 2 {
+		t.Errorf("queries: %d, want 2", queries)
+	}
+
+	_, used, lastUsed, err = discoverAddressChain(addressChainExternal, 20, derive, isUsed)
+	if err != nil {
+		t.Fatalf("discoverAddressChain failed unexpected error: %v", err)
+	}
+	if lastUsed != 24 || len(used) != 2 {
+		t.Errorf("unexpected result: last used: %d, used: %d", lastUsed, len(used))
+	}
+
+	all, _, lastUsed, err = discoverAddressChain(addressChainChange, 20, derive, isUsed)
+	if err != nil {
+		t.Fatalf("discoverAddressChain failed unexpected error: %v", err)
+	}
+	if lastUsed != 0 || len(all) != 1 || !all[0].IsChange {
+		t.Errorf("unexpected change chain result: last used: %d, all: %d", lastUsed, len(all))
+	}
+}
+
+func TestDiscoverAccountAddresses_CoreWithoutHistory(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerCore
+
+	account := &openwallet.AssetsAccount{AccountID: "account", PublicKey: "pub"}
+	if _, err := wm.DiscoverAccountAddresses(nil, account, 5); err != errAddressHistoryNotSupported {
+		t.Errorf("discovery without address history should be rejected, err: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//地址重扫任务的状态
+const (
+	AddressRescanRunning   = "running"
+	AddressRescanFinished  = "finished"
+	AddressRescanCancelled = "cancelled"
+)
+
+//addressRescanJobRetention 结束的重扫任务保留时间，过期后删除
+const addressRescanJobRetention = 24 * time.Hour
+
+//AddressRescanJob 指定地址的历史区块重扫任务，不改变扫描器的扫描高度
+type AddressRescanJob struct {
+	ID            string
+	Addresses     []string
+	StartHeight   uint64
+	EndHeight     uint64
+	CurrentHeight uint64   //正在扫描的高度
+	FailedHeights []uint64 //获取区块失败的高度
+	Status        string
+	StartTime     int64
+	EndTime       int64
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+//snapshot 复制任务的当前状态
+func (job *AddressRescanJob) snapshot() *AddressRescanJob {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return &AddressRescanJob{
+		ID:            job.ID,
+		Addresses:     append([]string{}, job.Addresses...),
+		StartHeight:   job.StartHeight,
+		EndHeight:     job.EndHeight,
+		CurrentHeight: job.CurrentHeight,
+		FailedHeights: append([]uint64{}, job.FailedHeights...),
+		Status:        job.Status,
+		StartTime:     job.StartTime,
+		EndTime:       job.EndTime,
+	}
+}
+
+//RescanAddresses 后台重扫[startHeight, endHeight]区间的区块，只提取指定地址的交易，通过观察者正常通知
+//endHeight为0时扫描到当前已扫描的高度
+func (bs *BTCBlockScanner) RescanAddresses(startHeight, endHeight uint64, addresses ...string) (*AddressRescanJob, error) {
+
+	if len(addresses) == 0 {
+		return nil, errors.New("addresses to rescan is empty")
+	}
+
+	if startHeight == 0 {
+		return nil, errors.New("block height to rescan must greater than 0")
+	}
+
+	if endHeight == 0 {
+		endHeight = bs.GetScannedBlockHeight()
+	}
+
+	if endHeight < startHeight {
+		return nil, fmt.Errorf("end height: %d is less than start height: %d", endHeight, startHeight)
+	}
+
+	if bs.ScanTargetFuncV2 == nil {
+		return nil, errors.New("scan target func is not setup")
+	}
+
+	job := &AddressRescanJob{
+		ID:            fmt.Sprintf("%d_%d_%d", startHeight, endHeight, time.Now().UnixNano()),
+		Addresses:     addresses,
+		StartHeight:   startHeight,
+		EndHeight:     endHeight,
+		CurrentHeight: startHeight,
+		FailedHeights: make([]uint64, 0),
+		Status:        AddressRescanRunning,
+		StartTime:     time.Now().Unix(),
+		stop:          make(chan struct{}),
+	}
+
+	bs.rescanJobsMu.Lock()
+	bs.pruneAddressRescanJobs(time.Now())
+	bs.rescanJobs[job.ID] = job
+	bs.rescanJobsMu.Unlock()
+
+	go bs.runAddressRescanJob(job)
+
+	return job.snapshot(), nil
+}
+
+//GetAddressRescanJob 获取地址重扫任务的状态
+func (bs *BTCBlockScanner) GetAddressRescanJob(id string) (*AddressRescanJob, error) {
+	bs.rescanJobsMu.Lock()
+	job, ok := bs.rescanJobs[id]
+	bs.rescanJobsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("address rescan job: %s not found", id)
+	}
+
+	return job.snapshot(), nil
+}
+
+//GetAddressRescanJobs 获取全部地址重扫任务的状态
+func (bs *BTCBlockScanner) GetAddressRescanJobs() []*AddressRescanJob {
+	bs.rescanJobsMu.Lock()
+	defer bs.rescanJobsMu.Unlock()
+
+	list := make([]*AddressRescanJob, 0, len(bs.rescanJobs))
+	for _, job := range bs.rescanJobs {
+		list = append(list, job.snapshot())
+	}
+	return list
+}
+
+//CancelAddressRescanJob 取消地址重扫任务
+func (bs *BTCBlockScanner) CancelAddressRescanJob(id string) error {
+	bs.rescanJobsMu.Lock()
+	job, ok := bs.rescanJobs[id]
+	bs.rescanJobsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("address rescan job: %s not found", id)
+	}
+
+	job.cancel()
+	return nil
+}
+
+//cancelAddressRescanJobs 取消全部运行中的地址重扫任务
+func (bs *BTCBlockScanner) cancelAddressRescanJobs() {
+	bs.rescanJobsMu.Lock()
+	defer bs.rescanJobsMu.Unlock()
+
+	for _, job := range bs.rescanJobs {
+		job.cancel()
+	}
+}
+
+//pruneAddressRescanJobs 删除结束超过保留时间的重扫任务，调用前需要加锁
+func (bs *BTCBlockScanner) pruneAddressRescanJobs(now time.Time) {
+	expired := now.Add(-addressRescanJobRetention).Unix()
+	for id, job := range bs.rescanJobs {
+		job.mu.Lock()
+		finished := job.Status != AddressRescanRunning && job.EndTime <= expired
+		job.mu.Unlock()
+		if finished {
+			delete(bs.rescanJobs, id)
+		}
+	}
+}
+
+//cancel 通知任务停止
+func (job *AddressRescanJob) cancel() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.Status != AddressRescanRunning {
+		return
+	}
+
+	job.Status = AddressRescanCancelled
+	job.EndTime = time.Now().Unix()
+	close(job.stop)
+}
+
+//runAddressRescanJob 执行地址重扫任务
+func (bs *BTCBlockScanner) runAddressRescanJob(job *AddressRescanJob) {
+
+	bs.wm.Log.Infof("address rescan job: %s start, height: %d - %d, addresses: %d",
+		job.ID, job.StartHeight, job.EndHeight, len(job.Addresses))
+
+	scanAddressFunc := bs.addressRescanTargetFunc(job.Addresses)
+	scripts := bs.wm.addressesScriptPubKey(job.Addresses)
+
+	for height := job.StartHeight; height <= job.EndHeight; height++ {
+
+		select {
+		case <-job.stop:
+			bs.wm.Log.Infof("address rescan job: %s has been cancelled at height: %d", job.ID, height)
+			return
+		default:
+		}
+
+		job.mu.Lock()
+		job.CurrentHeight = height
+		job.mu.Unlock()
+
+		err := bs.rescanAddressesAtHeight(height, scripts, scanAddressFunc)
+		if err != nil {
+			bs.wm.Log.Errorf("address rescan job: %s height: %d failed unexpected error: %v", job.ID, height, err)
+			job.mu.Lock()
+			job.FailedHeights = append(job.FailedHeights, height)
+			job.mu.Unlock()
+		}
+	}
+
+	job.mu.Lock()
+	if job.Status == AddressRescanRunning {
+		job.Status = AddressRescanFinished
+		job.EndTime = time.Now().Unix()
+	}
+	failed := len(job.FailedHeights)
+	job.mu.Unlock()
+
+	bs.wm.Log.Infof("address rescan job: %s finished, failed heights: %d", job.ID, failed)
+}
+
+//rescanAddressesAtHeight 重扫指定高度的区块，不保存扫描高度，不通知新区块
+func (bs *BTCBlockScanner) rescanAddressesAtHeight(height uint64, scripts [][]byte, scanAddressFunc openwallet.BlockScanTargetFuncV2) error {
+
+	hash, err := bs.getScanBlockHash(height)
+	if err != nil {
+		return err
+	}
+
+	//使用过滤器时，先检查区块是否包含重扫的地址
+	if bs.useBlockFilter() {
+		match, err := bs.matchBlockFilter(hash, scripts)
+		if err != nil {
+			return err
+		}
+		if !match {
+			return nil
+		}
+	}
+
+	block, err := bs.getFullBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	if len(block.tx) == 0 {
+		return nil
+	}
+
+	if block.isVerbose {
+		bs.cacheBlockTxs(block.txDetails)
+		defer bs.uncacheBlockTxs(block.txDetails)
+	}
+
+	//提取失败的交易会记录为未扫记录，由重扫策略处理
+	err = bs.batchExtractTransaction(block.Height, block.Hash, block.tx, scanAddressFunc, false)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+	}
+
+	return nil
+}
+
+//addressRescanTargetFunc 只匹配指定地址的查找扫描对象方法，其它类型的扫描对象按原方法查找
+func (bs *BTCBlockScanner) addressRescanTargetFunc(addresses []string) openwallet.BlockScanTargetFuncV2 {
+
+	targets := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		targets[a] = true
+	}
+
+	scanTargetFunc := bs.ScanTargetFuncV2
+
+	return func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		if target.ScanTargetType == openwallet.ScanTargetTypeAccountAddress && !targets[target.ScanTarget] {
+			return openwallet.ScanTargetResult{SourceKey: "", Exist: false}
+		}
+		return scanTargetFunc(target)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestAddressRescanTargetFunc(t *testing.T) {
+	bs := &BTCBlockScanner{BlockScannerBase: openwallet.NewBlockScannerBase()}
+	bs.ScanTargetFuncV2 = func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		return openwallet.ScanTargetResult{SourceKey: "account", Exist: true}
+	}
+
+	scanFunc := bs.addressRescanTargetFunc([]string{"imported"})
+
+	if r := scanFunc(openwallet.ScanTargetParam{ScanTarget: "imported", ScanTargetType: openwallet.ScanTargetTypeAccountAddress}); !r.Exist || r.SourceKey != "account" {
+		t.Errorf("imported address should be matched: %+v", r)
+	}
+	if r := scanFunc(openwallet.ScanTargetParam{ScanTarget: "other", ScanTargetType: openwallet.ScanTargetTypeAccountAddress}); r.Exist {
+		t.Errorf("other address should not be matched: %+v", r)
+	}
+	if r := scanFunc(openwallet.ScanTargetParam{ScanTarget: "contract", ScanTargetType: openwallet.ScanTargetTypeContractAddress}); !r.Exist {
+		t.Errorf("contract should be looked up by the scan target func: %+v", r)
+	}
+}
+
+func TestRescanAddresses_InvalidRange(t *testing.T) {
+	bs := &BTCBlockScanner{BlockScannerBase: openwallet.NewBlockScannerBase()}
+
+	if _, err := bs.RescanAddresses(100, 10, "imported"); err == nil {
+		t.Errorf("rescan with end height less than start height should fail")
+	}
+	if _, err := bs.RescanAddresses(1, 10); err == nil {
+		t.Errorf("rescan without addresses should fail")
+	}
+}
+
+func TestPruneAddressRescanJobs(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-addressRescanJobRetention - time.Minute).Unix()
+	bs := &BTCBlockScanner{rescanJobs: map[string]*AddressRescanJob{
+		"finished":  {ID: "finished", Status: AddressRescanFinished, EndTime: expired},
+		"cancelled": {ID: "cancelled", Status: AddressRescanCancelled, EndTime: expired},
+		"recent":    {ID: "recent", Status: AddressRescanFinished, EndTime: now.Unix()},
+		"running":   {ID: "running", Status: AddressRescanRunning},
+	}}
+
+	bs.pruneAddressRescanJobs(now)
+
+	if len(bs.rescanJobs) != 2 || bs.rescanJobs["recent"] == nil || bs.rescanJobs["running"] == nil {
+		t.Errorf("unexpected jobs after prune: %v", bs.rescanJobs)
+	}
+}
@@ -0,0 +1,505 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	//区块版本号中的合并挖矿标记
+	auxPowVersionFlag = 1 << 8
+
+	//默克尔分支的最大长度
+	maxMerkleBranchLength = 30
+
+	//区块版本号中链ID的起始位
+	auxPowChainIDShift = 16
+)
+
+//mergedMiningHeader coinbase脚本中合并挖矿承诺的标记
+var mergedMiningHeader = []byte{0xfa, 0xbe, 'm', 'm'}
+
+//AuxPow 合并挖矿证明，父链区块的coinbase交易包含本链区块hash的承诺
+type AuxPow struct {
+	CoinbaseTx     *wire.MsgTx      //父链区块的coinbase交易
+	ParentHash     chainhash.Hash   //父链区块hash，共识不使用
+	CoinbaseBranch []chainhash.Hash //coinbase交易到父链区块默克尔根的分支
+	CoinbaseIndex  int32
+	ChainBranch    []chainhash.Hash //本链区块hash到合并挖矿默克尔根的分支
+	ChainIndex     int32
+	ParentBlock    wire.BlockHeader //父链区块头
+}
+
+//SyscoinBlockHeader 区块头，版本号带合并挖矿标记时附带AuxPow数据
+type SyscoinBlockHeader struct {
+	wire.BlockHeader
+	AuxPow *AuxPow
+}
+
+//SyscoinBlock P2P下载的完整区块
+type SyscoinBlock struct {
+	Header       SyscoinBlockHeader
+	Transactions []*wire.MsgTx
+}
+
+//IsAuxPow 版本号是否带合并挖矿标记
+func (h *SyscoinBlockHeader) IsAuxPow() bool {
+	return h.Version&auxPowVersionFlag != 0
+}
+
+//ChainID 版本号中的合并挖矿链ID
+func (h *SyscoinBlockHeader) ChainID() int32 {
+	return h.Version >> auxPowChainIDShift
+}
+
+//PowHash 工作量证明的hash，合并挖矿区块使用父链区块头的hash
+func (h *SyscoinBlockHeader) PowHash() chainhash.Hash {
+	if h.AuxPow != nil {
+		return h.AuxPow.ParentBlock.BlockHash()
+	}
+	return h.BlockHash()
+}
+
+//Deserialize 解析区块头及AuxPow数据
+func (h *SyscoinBlockHeader) Deserialize(r io.Reader) error {
+
+	err := h.BlockHeader.Deserialize(r)
+	if err != nil {
+		return err
+	}
+
+	h.AuxPow = nil
+	if !h.IsAuxPow() {
+		return nil
+	}
+
+	auxpow := &AuxPow{}
+	err = auxpow.Deserialize(r)
+	if err != nil {
+		return fmt.Errorf("block: %s auxpow is invalid, %v", h.BlockHash().String(), err)
+	}
+	h.AuxPow = auxpow
+
+	return nil
+}
+
+//CheckProofOfWork 验证区块头的工作量证明，合并挖矿区块同时验证AuxPow对本区块的承诺
+func (h *SyscoinBlockHeader) CheckProofOfWork(params *P2PNetParams) error {
+
+	hash := h.BlockHash()
+
+	target := blockchain.CompactToBig(h.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("block: %s target difficulty is invalid", hash.String())
+	}
+	if target.Cmp(params.PowLimit) > 0 {
+		return fmt.Errorf("block: %s target difficulty is lower than minimum", hash.String())
+	}
+
+	if h.IsAuxPow() != (h.AuxPow != nil) {
+		return fmt.Errorf("block: %s auxpow does not match version", hash.String())
+	}
+
+	if h.AuxPow != nil {
+		if h.ChainID() != params.AuxPowChainID {
+			return fmt.Errorf("block: %s chain id: %d is not %d", hash.String(), h.ChainID(), params.AuxPowChainID)
+		}
+		err := h.AuxPow.Check(hash, params.AuxPowChainID)
+		if err != nil {
+			return fmt.Errorf("block: %s auxpow is invalid, %v", hash.String(), err)
+		}
+	}
+
+	powHash := h.PowHash()
+	if blockchain.HashToBig(&powHash).Cmp(target) > 0 {
+		return fmt.Errorf("block: %s proof of work hash: %s is higher than target", hash.String(), powHash.String())
+	}
+
+	return nil
+}
+
+//Check 验证合并挖矿证明：coinbase交易在父链区块中，且coinbase脚本承诺了本链区块hash
+func (a *AuxPow) Check(hash chainhash.Hash, chainID int32) error {
+
+	if a.CoinbaseTx == nil || len(a.CoinbaseTx.TxIn) == 0 {
+		return errors.New("auxpow coinbase transaction is empty")
+	}
+
+	if a.CoinbaseIndex != 0 {
+		return errors.New("auxpow is not a generate")
+	}
+
+	if int32(a.ParentBlock.Version>>auxPowChainIDShift) == chainID {
+		return errors.New("auxpow parent block has our chain id")
+	}
+
+	if len(a.ChainBranch) > maxMerkleBranchLength {
+		return errors.New("auxpow chain merkle branch is too long")
+	}
+
+	//本链区块hash到合并挖矿默克尔根
+	root := checkMerkleBranch(hash, a.ChainBranch, a.ChainIndex)
+	rootBytes := make([]byte, chainhash.HashSize)
+	for i := range root {
+		rootBytes[i] = root[chainhash.HashSize-1-i]
+	}
+
+	//coinbase交易到父链区块默克尔根
+	merkleRoot := checkMerkleBranch(a.CoinbaseTx.TxHash(), a.CoinbaseBranch, a.CoinbaseIndex)
+	if merkleRoot != a.ParentBlock.MerkleRoot {
+		return errors.New("auxpow merkle root is incorrect")
+	}
+
+	script := a.CoinbaseTx.TxIn[0].SignatureScript
+
+	pc := bytes.Index(script, rootBytes)
+	if pc < 0 {
+		return errors.New("auxpow missing chain merkle root in parent coinbase")
+	}
+
+	head := bytes.Index(script, mergedMiningHeader)
+	if head >= 0 {
+		if bytes.Index(script[head+1:], mergedMiningHeader) >= 0 {
+			return errors.New("auxpow multiple merged mining headers in coinbase")
+		}
+		if head+len(mergedMiningHeader) != pc {
+			return errors.New("auxpow merged mining header is not just before chain merkle root")
+		}
+	} else if pc > 20 {
+		return errors.New("auxpow chain merkle root must start in the first 20 bytes of the parent coinbase")
+	}
+
+	pc += len(rootBytes)
+	if len(script)-pc < 8 {
+		return errors.New("auxpow missing chain merkle tree size and nonce in parent coinbase")
+	}
+
+	size := int32(binary.LittleEndian.Uint32(script[pc : pc+4]))
+	if size != 1<<uint(len(a.ChainBranch)) {
+		return errors.New("auxpow merkle branch size does not match parent coinbase")
+	}
+
+	nonce := binary.LittleEndian.Uint32(script[pc+4 : pc+8])
+	if uint32(a.ChainIndex) != auxPowExpectedIndex(nonce, chainID, uint(len(a.ChainBranch))) {
+		return errors.New("auxpow wrong index")
+	}
+
+	return nil
+}
+
+//auxPowExpectedIndex 合并挖矿默克尔树中本链的位置，由nonce和链ID决定
+func auxPowExpectedIndex(nonce uint32, chainID int32, h uint) uint32 {
+	rand := nonce
+	rand = rand*1103515245 + 12345
+	rand += uint32(chainID)
+	rand = rand*1103515245 + 12345
+	return rand % (1 << h)
+}
+
+//checkMerkleBranch 根据默克尔分支计算默克尔根
+func checkMerkleBranch(hash chainhash.Hash, branch []chainhash.Hash, index int32) chainhash.Hash {
+	if index == -1 {
+		return chainhash.Hash{}
+	}
+	for i := range branch {
+		if index&1 == 1 {
+			hash = hashMerkleNode(&branch[i], &hash)
+		} else {
+			hash = hashMerkleNode(&hash, &branch[i])
+		}
+		index >>= 1
+	}
+	return hash
+}
+
+//ParseBlockHeader 解析原始区块头数据，包括AuxPow
+func ParseBlockHeader(raw []byte) (*SyscoinBlockHeader, error) {
+
+	r := bytes.NewReader(raw)
+
+	header := &SyscoinBlockHeader{}
+	err := header.Deserialize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("block header has %d unexpected trailing bytes", r.Len())
+	}
+
+	return header, nil
+}
+
+//Deserialize 解析合并挖矿证明
+func (a *AuxPow) Deserialize(r io.Reader) error {
+
+	a.CoinbaseTx = &wire.MsgTx{}
+	err := a.CoinbaseTx.BtcDecode(r, 0, wire.WitnessEncoding)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.ReadFull(r, a.ParentHash[:])
+	if err != nil {
+		return err
+	}
+
+	a.CoinbaseBranch, a.CoinbaseIndex, err = readMerkleBranch(r)
+	if err != nil {
+		return err
+	}
+
+	a.ChainBranch, a.ChainIndex, err = readMerkleBranch(r)
+	if err != nil {
+		return err
+	}
+
+	return a.ParentBlock.Deserialize(r)
+}
+
+//Serialize 序列化合并挖矿证明
+func (a *AuxPow) Serialize(w io.Writer) error {
+
+	if a.CoinbaseTx == nil {
+		return errors.New("auxpow coinbase transaction is empty")
+	}
+
+	err := a.CoinbaseTx.BtcEncode(w, 0, wire.WitnessEncoding)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(a.ParentHash[:])
+	if err != nil {
+		return err
+	}
+
+	err = writeMerkleBranch(w, a.CoinbaseBranch, a.CoinbaseIndex)
+	if err != nil {
+		return err
+	}
+
+	err = writeMerkleBranch(w, a.ChainBranch, a.ChainIndex)
+	if err != nil {
+		return err
+	}
+
+	return a.ParentBlock.Serialize(w)
+}
+
+//Serialize 序列化区块头及AuxPow数据
+func (h *SyscoinBlockHeader) Serialize(w io.Writer) error {
+
+	err := h.BlockHeader.Serialize(w)
+	if err != nil {
+		return err
+	}
+
+	if !h.IsAuxPow() {
+		return nil
+	}
+
+	if h.AuxPow == nil {
+		return fmt.Errorf("block: %s auxpow is empty", h.BlockHash().String())
+	}
+
+	return h.AuxPow.Serialize(w)
+}
+
+//Deserialize 解析完整区块
+func (b *SyscoinBlock) Deserialize(r io.Reader) error {
+
+	err := b.Header.Deserialize(r)
+	if err != nil {
+		return err
+	}
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return err
+	}
+
+	if count > wire.MaxBlockPayload/wire.MinTxOutPayload {
+		return fmt.Errorf("block transaction count: %d is too large", count)
+	}
+
+	b.Transactions = make([]*wire.MsgTx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tx := &wire.MsgTx{}
+		err = tx.BtcDecode(r, 0, wire.WitnessEncoding)
+		if err != nil {
+			return err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	return nil
+}
+
+//CheckMerkleRoot 检查交易列表与区块头的默克尔根是否一致
+func (b *SyscoinBlock) CheckMerkleRoot() error {
+
+	hashes := make([]chainhash.Hash, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		hashes = append(hashes, tx.TxHash())
+	}
+
+	root := calcMerkleRoot(hashes)
+	if !root.IsEqual(&b.Header.MerkleRoot) {
+		return fmt.Errorf("block: %s merkle root mismatch", b.Header.BlockHash().String())
+	}
+
+	return nil
+}
+
+//readHeaders 解析headers消息，每个区块头后面跟着交易数量（总是0）
+func readHeaders(payload []byte) ([]*SyscoinBlockHeader, error) {
+
+	r := bytes.NewReader(payload)
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > wire.MaxBlockHeadersPerMsg {
+		return nil, fmt.Errorf("headers count: %d is too large", count)
+	}
+
+	headers := make([]*SyscoinBlockHeader, 0, count)
+	for i := uint64(0); i < count; i++ {
+		header := &SyscoinBlockHeader{}
+		err = header.Deserialize(r)
+		if err != nil {
+			return nil, err
+		}
+
+		txCount, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if txCount > 0 {
+			return nil, fmt.Errorf("block: %s headers contain transactions", header.BlockHash().String())
+		}
+
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+//readMerkleBranch 读取默克尔分支及叶子的位置
+func readMerkleBranch(r io.Reader) ([]chainhash.Hash, int32, error) {
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if count > maxMerkleBranchLength {
+		return nil, 0, fmt.Errorf("merkle branch length: %d is too large", count)
+	}
+
+	branch := make([]chainhash.Hash, count)
+	for i := range branch {
+		_, err = io.ReadFull(r, branch[i][:])
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var index int32
+	err = readInt32(r, &index)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return branch, index, nil
+}
+
+//writeMerkleBranch 写入默克尔分支及叶子的位置
+func writeMerkleBranch(w io.Writer, branch []chainhash.Hash, index int32) error {
+
+	err := wire.WriteVarInt(w, 0, uint64(len(branch)))
+	if err != nil {
+		return err
+	}
+
+	for i := range branch {
+		_, err = w.Write(branch[i][:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeInt32(w, index)
+}
+
+func readInt32(r io.Reader, v *int32) error {
+	var b [4]byte
+	_, err := io.ReadFull(r, b[:])
+	if err != nil {
+		return err
+	}
+	*v = int32(binary.LittleEndian.Uint32(b[:]))
+	return nil
+}
+
+func writeInt32(w io.Writer, v int32) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	_, err := w.Write(b[:])
+	return err
+}
+
+//calcMerkleRoot 计算默克尔根，奇数个节点时复制最后一个
+func calcMerkleRoot(hashes []chainhash.Hash) chainhash.Hash {
+
+	if len(hashes) == 0 {
+		return chainhash.Hash{}
+	}
+
+	level := append([]chainhash.Hash{}, hashes...)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, hashMerkleNode(&level[i], &level[i+1]))
+		}
+		level = next
+	}
+
+	return level[0]
+}
+
+//hashMerkleNode 计算默克尔树的父节点
+func hashMerkleNode(left, right *chainhash.Hash) chainhash.Hash {
+	var b [chainhash.HashSize * 2]byte
+	copy(b[:chainhash.HashSize], left[:])
+	copy(b[chainhash.HashSize:], right[:])
+	return chainhash.DoubleHashH(b[:])
+}
@@ -0,0 +1,431 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+/******************* 核心钱包、浏览器和本地UTXO之间的一致性检查 *******************/
+
+//数据源名称
+const (
+	AuditBackendCore     = "core"
+	AuditBackendExplorer = "explorer"
+	AuditBackendLocal    = "local"
+)
+
+//不一致的类型
+const (
+	AuditKindUnspent = "unspent"
+	AuditKindBalance = "balance"
+	AuditKindBlock   = "block"
+	AuditKindTx      = "tx"
+)
+
+//AuditDiscrepancy 数据源之间的一处不一致，Values记录各数据源的值，缺失为空字符串
+type AuditDiscrepancy struct {
+	Kind    string            `json:"kind"`
+	Address string            `json:"address,omitempty"`
+	Height  uint64            `json:"height,omitempty"`
+	TxID    string            `json:"txid,omitempty"`
+	Vout    uint64            `json:"vout,omitempty"`
+	Values  map[string]string `json:"values"`
+}
+
+//AuditReport 一致性检查报告
+type AuditReport struct {
+	StartTime     int64               `json:"startTime"`
+	EndTime       int64               `json:"endTime"`
+	Backends      []string            `json:"backends"`
+	Addresses     []string            `json:"addresses"`
+	Heights       []uint64            `json:"heights"`
+	Discrepancies []*AuditDiscrepancy `json:"discrepancies"`
+	Errors        []string            `json:"errors"` //查询失败的数据源，不计入不一致
+}
+
+//Consistent 是否没有发现不一致
+func (r *AuditReport) Consistent() bool {
+	return len(r.Discrepancies) == 0
+}
+
+func (r *AuditReport) addError(backend string, err error) {
+	r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", backend, err))
+}
+
+//auditCoreClient 一致性检查使用的核心钱包客户端，浏览器模式为auditServerAPI
+func (wm *WalletManager) auditCoreClient() *Client {
+	if wm.Config.RPCServerType == RPCServerExplorer {
+		return wm.AuditClient
+	}
+	return wm.WalletClient
+}
+
+//auditUnspentSources 可查询未花记录的数据源，过滤器模式下节点不开启钱包，使用本地UTXO
+//浏览器模式下地址没有导入核心钱包，按地址通过scantxoutset查询UTXO集合
+func (wm *WalletManager) auditUnspentSources() map[string]func(addresses ...string) ([]*Unspent, error) {
+
+	sources := make(map[string]func(addresses ...string) ([]*Unspent, error))
+
+	switch wm.Config.RPCServerType {
+	case RPCServerCore:
+		if wm.WalletClient != nil {
+			sources[AuditBackendCore] = func(addresses ...string) ([]*Unspent, error) {
+				return wm.getListUnspentByCore(1, addresses...)
+			}
+		}
+	case RPCServerExplorer:
+		if wm.AuditClient != nil {
+			sources[AuditBackendCore] = func(addresses ...string) ([]*Unspent, error) {
+				return wm.scanUnspentByCore(wm.AuditClient, addresses...)
+			}
+		}
+	case RPCServerCFilter:
+		sources[AuditBackendLocal] = func(addresses ...string) ([]*Unspent, error) {
+			return wm.listUnspentByLocal(1, addresses...)
+		}
+	}
+	if wm.ExplorerClient != nil {
+		sources[AuditBackendExplorer] = func(addresses ...string) ([]*Unspent, error) {
+			return wm.listUnspentByExplorer(1, addresses...)
+		}
+	}
+
+	return sources
+}
+
+//scanUnspentByCore 通过scantxoutset按地址查询UTXO集合，不需要地址导入节点钱包
+func (wm *WalletManager) scanUnspentByCore(client *Client, addresses ...string) ([]*Unspent, error) {
+
+	scripts := make(map[string]string, len(addresses))
+	descriptors := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		script, err := wm.addressScriptPubKey(a)
+		if err != nil {
+			return nil, err
+		}
+		scripts[hex.EncodeToString(script)] = a
+		descriptors = append(descriptors, fmt.Sprintf("addr(%s)", a))
+	}
+
+	request := []interface{}{
+		"start",
+		descriptors,
+	}
+
+	result, err := client.Call("scantxoutset", request)
+	if err != nil {
+		return nil, err
+	}
+
+	tip := result.Get("height").Uint()
+	utxos := make([]*Unspent, 0)
+	for _, u := range result.Get("unspents").Array() {
+		obj := NewUnspent(&u)
+		obj.Address = scripts[obj.ScriptPubKey]
+		if height := u.Get("height").Uint(); height > 0 && tip >= height {
+			obj.Confirmations = tip - height + 1
+		}
+		utxos = append(utxos, obj)
+	}
+
+	return utxos, nil
+}
+
+//AuditBackends 比较各数据源指定地址的未花记录、余额，以及指定高度的区块交易列表
+//需要同时配置核心钱包和浏览器接口（auditServerAPI），或使用过滤器模式的本地UTXO
+func (wm *WalletManager) AuditBackends(addresses []string, heights []uint64) (*AuditReport, error) {
+
+	report := &AuditReport{
+		StartTime:     time.Now().Unix(),
+		Addresses:     addresses,
+		Heights:       heights,
+		Discrepancies: make([]*AuditDiscrepancy, 0),
+		Errors:        make([]string, 0),
+	}
+
+	sources := wm.auditUnspentSources()
+	for name := range sources {
+		report.Backends = append(report.Backends, name)
+	}
+	sort.Strings(report.Backends)
+
+	client := wm.auditCoreClient()
+	if len(sources) < 2 && (client == nil || wm.ExplorerClient == nil) {
+		return nil, errors.New("audit needs at least two backends, please setup auditServerAPI")
+	}
+
+	if len(addresses) > 0 {
+		wm.auditUnspent(report, sources, addresses)
+	}
+
+	if len(heights) > 0 && client != nil && wm.ExplorerClient != nil {
+		for _, height := range heights {
+			wm.auditBlock(report, client, height)
+		}
+	}
+
+	report.EndTime = time.Now().Unix()
+
+	for _, d := range report.Discrepancies {
+		wm.Log.Warningf("audit discrepancy: %s address: %s height: %d txid: %s vout: %d values: %v",
+			d.Kind, d.Address, d.Height, d.TxID, d.Vout, d.Values)
+	}
+	wm.Log.Infof("audit finished, addresses: %d, heights: %d, discrepancies: %d, errors: %d",
+		len(addresses), len(heights), len(report.Discrepancies), len(report.Errors))
+
+	return report, nil
+}
+
+//auditUnspent 比较未花记录和余额
+func (wm *WalletManager) auditUnspent(report *AuditReport, sources map[string]func(addresses ...string) ([]*Unspent, error), addresses []string) {
+
+	//数据源 -> txid_vout -> 未花记录
+	unspents := make(map[string]map[string]*Unspent)
+	//数据源 -> 地址 -> 已确认余额
+	balances := make(map[string]map[string]string)
+
+	for name, list := range sources {
+		utxos, err := list(addresses...)
+		if err != nil {
+			report.addError(name, err)
+			continue
+		}
+		set := make(map[string]*Unspent, len(utxos))
+		for _, u := range utxos {
+			set[localUnspentKey(u.TxID, u.Vout)] = u
+		}
+		unspents[name] = set
+		balances[name] = auditConfirmBalances(wm.calculateUnspent(utxos))
+	}
+
+	//浏览器的地址余额接口单独比较，用于发现余额缓存过期
+	if wm.ExplorerClient != nil {
+		native := make(map[string]string, len(addresses))
+		for _, a := range addresses {
+			b, err := wm.getBalanceByExplorer(a)
+			if err != nil {
+				report.addError(AuditBackendExplorer+"-balance", err)
+				native = nil
+				break
+			}
+			native[a] = b.ConfirmBalance
+		}
+		if native != nil {
+			balances[AuditBackendExplorer+"-balance"] = native
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, set := range unspents {
+		for key := range set {
+			keys[key] = true
+		}
+	}
+
+	for _, key := range sortedKeys(keys) {
+		var (
+			sample *Unspent
+			values = make(map[string]string, len(unspents))
+		)
+		for name, set := range unspents {
+			if u, ok := set[key]; ok {
+				values[name] = normalizeAmount(u.Amount)
+				sample = u
+			} else {
+				values[name] = ""
+			}
+		}
+		if !auditValuesEqual(values) {
+			report.Discrepancies = append(report.Discrepancies, &AuditDiscrepancy{
+				Kind:    AuditKindUnspent,
+				Address: sample.Address,
+				TxID:    sample.TxID,
+				Vout:    sample.Vout,
+				Values:  values,
+			})
+		}
+	}
+
+	for _, a := range addresses {
+		values := make(map[string]string, len(balances))
+		for name, set := range balances {
+			values[name] = normalizeAmount(set[a])
+		}
+		if !auditValuesEqual(values) {
+			report.Discrepancies = append(report.Discrepancies, &AuditDiscrepancy{
+				Kind:    AuditKindBalance,
+				Address: a,
+				Values:  values,
+			})
+		}
+	}
+}
+
+//auditBlock 比较核心钱包和浏览器在同一高度的区块hash和交易列表
+func (wm *WalletManager) auditBlock(report *AuditReport, client *Client, height uint64) {
+
+	result, err := client.Call("getblockhash", []interface{}{height})
+	if err != nil {
+		report.addError(AuditBackendCore, err)
+		return
+	}
+	coreHash := result.String()
+
+	explorerHash, err := wm.getBlockHashByExplorer(height)
+	if err != nil {
+		report.addError(AuditBackendExplorer, err)
+		return
+	}
+
+	if coreHash != explorerHash {
+		report.Discrepancies = append(report.Discrepancies, &AuditDiscrepancy{
+			Kind:   AuditKindBlock,
+			Height: height,
+			Values: map[string]string{AuditBackendCore: coreHash, AuditBackendExplorer: explorerHash},
+		})
+		return
+	}
+
+	result, err = client.Call("getblock", []interface{}{coreHash})
+	if err != nil {
+		report.addError(AuditBackendCore, err)
+		return
+	}
+	coreBlock := wm.NewBlock(result)
+
+	explorerBlock, err := wm.getBlockByExplorer(explorerHash)
+	if err != nil {
+		report.addError(AuditBackendExplorer, err)
+		return
+	}
+
+	txs := make(map[string]map[string]string)
+	for _, txid := range coreBlock.tx {
+		txs[txid] = map[string]string{AuditBackendCore: coreHash, AuditBackendExplorer: ""}
+	}
+	for _, txid := range explorerBlock.tx {
+		if values, ok := txs[txid]; ok {
+			values[AuditBackendExplorer] = explorerHash
+		} else {
+			txs[txid] = map[string]string{AuditBackendCore: "", AuditBackendExplorer: explorerHash}
+		}
+	}
+
+	ids := make(map[string]bool, len(txs))
+	for txid := range txs {
+		ids[txid] = true
+	}
+
+	for _, txid := range sortedKeys(ids) {
+		if !auditValuesEqual(txs[txid]) {
+			report.Discrepancies = append(report.Discrepancies, &AuditDiscrepancy{
+				Kind:   AuditKindTx,
+				Height: height,
+				TxID:   txid,
+				Values: txs[txid],
+			})
+		}
+	}
+}
+
+//AuditSample 从钱包地址中随机抽取addressCount个地址，从已扫描的区块中随机抽取heightCount个高度，执行一致性检查
+func (bs *BTCBlockScanner) AuditSample(addressCount, heightCount int) (*AuditReport, error) {
+
+	if bs.WalletDAI == nil {
+		return nil, errors.New("wallet DAI is not setup")
+	}
+
+	list, err := bs.WalletDAI.GetAddressList(0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(list))
+	for _, a := range list {
+		addresses = append(addresses, a.Address)
+	}
+	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+	if len(addresses) > addressCount {
+		addresses = addresses[:addressCount]
+	}
+
+	heights := make([]uint64, 0, heightCount)
+	if scanned := bs.GetScannedBlockHeight(); scanned > 0 {
+		for i := 0; i < heightCount; i++ {
+			heights = append(heights, uint64(rand.Int63n(int64(scanned)))+1)
+		}
+	}
+
+	return bs.wm.AuditBackends(addresses, heights)
+}
+
+//auditConfirmBalances 地址的已确认余额
+func auditConfirmBalances(balances map[string]*openwallet.Balance) map[string]string {
+	result := make(map[string]string, len(balances))
+	for address, b := range balances {
+		result[address] = b.ConfirmBalance
+	}
+	return result
+}
+
+//auditValuesEqual 各数据源的值是否一致
+func auditValuesEqual(values map[string]string) bool {
+	var (
+		first string
+		init  bool
+	)
+	for _, v := range values {
+		if !init {
+			first, init = v, true
+			continue
+		}
+		if v != first {
+			return false
+		}
+	}
+	return true
+}
+
+//normalizeAmount 统一金额格式，空值视为0
+func normalizeAmount(amount string) string {
+	if len(strings.TrimSpace(amount)) == 0 {
+		return "0"
+	}
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return amount
+	}
+	return d.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
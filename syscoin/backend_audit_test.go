@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditUnspent(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	utxo := func(txid string, vout uint64, address, amount string) *Unspent {
+		return &Unspent{TxID: txid, Vout: vout, Address: address, Amount: amount, Confirmations: 10, Spendable: true}
+	}
+
+	sources := map[string]func(addresses ...string) ([]*Unspent, error){
+		AuditBackendCore: func(addresses ...string) ([]*Unspent, error) {
+			return []*Unspent{
+				utxo("aa", 0, "addr1", "1.5"),
+				utxo("bb", 1, "addr2", "2"),
+			}, nil
+		},
+		AuditBackendLocal: func(addresses ...string) ([]*Unspent, error) {
+			return []*Unspent{
+				utxo("aa", 0, "addr1", "1.50000000"),
+				utxo("cc", 0, "addr2", "2"),
+			}, nil
+		},
+	}
+
+	report := &AuditReport{}
+	wm.auditUnspent(report, sources, []string{"addr1", "addr2"})
+
+	if len(report.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", report.Errors)
+	}
+
+	//bb和cc各缺失一次，addr2余额一致
+	var kinds []string
+	for _, d := range report.Discrepancies {
+		kinds = append(kinds, d.Kind+":"+d.TxID+":"+d.Address)
+	}
+	if len(report.Discrepancies) != 2 ||
+		report.Discrepancies[0].TxID != "bb" || report.Discrepancies[0].Values[AuditBackendLocal] != "" ||
+		report.Discrepancies[1].TxID != "cc" || report.Discrepancies[1].Values[AuditBackendCore] != "" {
+		t.Errorf("unexpected discrepancies: %v", kinds)
+	}
+}
+
+func TestAuditUnspentSources_Explorer(t *testing.T) {
+
+	wm := NewWalletManager()
+	script := append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x11}, 20)...)
+	script = append(script, 0x88, 0xac)
+	address, _ := wm.scriptPubKeyAddress(script)
+
+	var request string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		request = string(body)
+		fmt.Fprintf(w, `{"result":{"success":true,"height":110,"unspents":[{"txid":"aa","vout":1,"scriptPubKey":"%x","amount":1.5,"height":101}]},"error":null,"id":"1"}`, script)
+	}))
+	defer server.Close()
+
+	wm.Config.RPCServerType = RPCServerExplorer
+	wm.AuditClient = NewClient(server.URL, "", false)
+
+	if wm.WalletClient != nil {
+		t.Fatalf("audit client should not be used as wallet client")
+	}
+
+	sources := wm.auditUnspentSources()
+	list, ok := sources[AuditBackendCore]
+	if !ok {
+		t.Fatalf("explorer mode should audit with auditServerAPI")
+	}
+
+	utxos, err := list(address)
+	if err != nil {
+		t.Fatalf("scan unspent failed unexpected error: %v", err)
+	}
+	if !strings.Contains(request, "scantxoutset") || !strings.Contains(request, "addr("+address+")") {
+		t.Errorf("unexpected request: %s", request)
+	}
+	if len(utxos) != 1 || utxos[0].Address != address || utxos[0].Amount != "1.5" || utxos[0].Confirmations != 10 {
+		t.Errorf("unexpected utxos: %+v", utxos)
+	}
+}
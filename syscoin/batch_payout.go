@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"sort"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//maxStandardTxSize 节点中继的标准交易最大大小(vbytes)
+	maxStandardTxSize = 100000
+)
+
+//BatchPayout 批量出账的一个收款
+type BatchPayout struct {
+	Address string
+	Amount  string
+}
+
+//BatchRawTransaction 批量出账请求，按输入数量和标准交易大小分拆成多笔交易单
+type BatchRawTransaction struct {
+	Coin     openwallet.Coin
+	Account  *openwallet.AssetsAccount
+	Payouts  []*BatchPayout
+	FeeRate  string //每KB的费率，为空则预估
+	Priority string //预估费率使用的优先级，为空则使用配置的默认优先级
+}
+
+//estimateTxSize 估算交易大小，与EstimateFee的计算公式一致
+func estimateTxSize(inputs, outputs int) int64 {
+	return int64(inputs*148 + outputs*34 + 10)
+}
+
+//batchBuilder 正在装配的一笔批量交易
+type batchBuilder struct {
+	inputs  []*Unspent
+	balance decimal.Decimal
+	outputs []*BatchPayout
+	total   decimal.Decimal
+}
+
+func newBatchBuilder() *batchBuilder {
+	return &batchBuilder{
+		inputs:  make([]*Unspent, 0),
+		balance: decimal.Zero,
+		outputs: make([]*BatchPayout, 0),
+		total:   decimal.Zero,
+	}
+}
+
+//CreateBatchRawTransaction 创建批量出账交易单，收款按顺序装入尽量少的交易单，
+//每笔交易单的输入不超过MaxTxInputs，大小不超过标准交易限制；无法支付的收款以带错误的交易单返回
+func (decoder *TransactionDecoder) CreateBatchRawTransaction(wrapper openwallet.WalletDAI, batch *BatchRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+
+	var (
+		feesRate   decimal.Decimal
+		err        error
+		rawTxArray = make([]*openwallet.RawTransactionWithError, 0)
+	)
+
+	if batch.Coin.IsContract {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "batch payout is not supported for omni token")
+	}
+
+	//同一账户串行构建
+	defer lockAccountBuild(batch.Account.AccountID)()
+
+	if len(batch.Payouts) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "batch payouts is empty")
+	}
+
+	unspents, findErr := decoder.getAssetsAccountUnspents(wrapper, batch.Account)
+	if findErr != nil {
+		return nil, findErr
+	}
+
+	feesRate, err = decoder.wm.ResolveFeeRate(batch.FeeRate, batch.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		if u.Spendable {
+			available = append(available, u)
+		}
+	}
+	available = decoder.filterEconomicalUTXO(available, feesRate)
+
+	//按金额从大到小使用utxo，减少每笔交易的输入数量
+	sort.Slice(available, func(i, j int) bool {
+		a, _ := decimal.NewFromString(available[i].Amount)
+		b, _ := decimal.NewFromString(available[j].Amount)
+		return a.GreaterThan(b)
+	})
+
+	batches, failures := decoder.packBatchPayouts(available, batch.Payouts, feesRate)
+
+	for _, b := range batches {
+		rawTxArray = append(rawTxArray, decoder.createBatchRawTransaction(wrapper, batch, b, feesRate))
+	}
+
+	for _, f := range failures {
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+			RawTx: &openwallet.RawTransaction{
+				Coin:    batch.Coin,
+				Account: batch.Account,
+				To:      map[string]string{f.payout.Address: f.payout.Amount},
+			},
+			Error: f.err,
+		})
+	}
+
+	return rawTxArray, nil
+}
+
+//batchFailure 无法装入交易单的收款
+type batchFailure struct {
+	payout *BatchPayout
+	err    *openwallet.Error
+}
+
+//packBatchPayouts 按顺序把收款装入交易单，utxo按传入顺序使用
+func (decoder *TransactionDecoder) packBatchPayouts(available []*Unspent, payouts []*BatchPayout, feesRate decimal.Decimal) ([]*batchBuilder, []*batchFailure) {
+
+	var (
+		batches  = make([]*batchBuilder, 0)
+		failures = make([]*batchFailure, 0)
+		builder  = newBatchBuilder()
+		next     = 0
+	)
+
+	failed := func(p *BatchPayout, e *openwallet.Error) {
+		failures = append(failures, &batchFailure{payout: p, err: e})
+	}
+
+	flush := func() {
+		batches = append(batches, builder)
+		builder = newBatchBuilder()
+	}
+
+	for _, p := range payouts {
+
+		amount, parseErr := decimal.NewFromString(p.Amount)
+		if parseErr != nil || amount.LessThanOrEqual(decimal.Zero) {
+			failed(p, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid payout amount: %s", p.Amount))
+			continue
+		}
+
+		if decoder.wm.IsDust(p.Address, amount) {
+			failed(p, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "payout amount: %s to address: %s is dust", p.Amount, p.Address))
+			continue
+		}
+
+		for {
+			//加入该收款后的输出数量，包括找零
+			outputs := len(builder.outputs) + 2
+			fees, _ := decoder.wm.EstimateFee(int64(len(builder.inputs)), int64(outputs), feesRate)
+			need := builder.total.Add(amount).Add(fees)
+
+			if estimateTxSize(len(builder.inputs), outputs) > maxStandardTxSize && len(builder.outputs) > 0 {
+				flush()
+				continue
+			}
+
+			if builder.balance.GreaterThanOrEqual(need) {
+				builder.outputs = append(builder.outputs, p)
+				builder.total = builder.total.Add(amount)
+				break
+			}
+
+			full := len(builder.inputs) >= decoder.wm.Config.MaxTxInputs ||
+				estimateTxSize(len(builder.inputs)+1, outputs) > maxStandardTxSize
+
+			if full || next >= len(available) {
+				if len(builder.outputs) > 0 {
+					//当前交易单已满，使用新的交易单装配该收款
+					flush()
+					continue
+				}
+				if full {
+					failed(p, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "payout amount: %s to address: %s need inputs over: %d", p.Amount, p.Address, decoder.wm.Config.MaxTxInputs))
+				} else {
+					failed(p, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "balance: %s is not enough for payout amount: %s to address: %s", builder.balance.StringFixed(decoder.wm.Decimal()), p.Amount, p.Address))
+				}
+				break
+			}
+
+			u := available[next]
+			next++
+			ua, _ := decimal.NewFromString(u.Amount)
+			builder.inputs = append(builder.inputs, u)
+			builder.balance = builder.balance.Add(ua)
+		}
+	}
+
+	if len(builder.outputs) > 0 {
+		flush()
+	}
+
+	return batches, failures
+}
+
+//createBatchRawTransaction 使用装配好的输入和收款创建一笔交易单
+func (decoder *TransactionDecoder) createBatchRawTransaction(wrapper openwallet.WalletDAI, batch *BatchRawTransaction, builder *batchBuilder, feesRate decimal.Decimal) *openwallet.RawTransactionWithError {
+
+	var (
+		outputAddrs = make(map[string]decimal.Decimal)
+		to          = make(map[string]string)
+		outputs     = make([]*TxOutput, 0, len(builder.outputs)+1)
+	)
+
+	for _, p := range builder.outputs {
+		amount, _ := decimal.NewFromString(p.Amount)
+		outputAddrs = appendOutput(outputAddrs, p.Address, amount)
+		outputs = append(outputs, &TxOutput{Address: p.Address, Amount: amount})
+	}
+	for addr, amount := range outputAddrs {
+		to[addr] = amount.StringFixed(decoder.wm.Decimal())
+	}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:     batch.Coin,
+		Account:  batch.Account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       to,
+		Required: 1,
+	}
+
+	fees, err := decoder.wm.EstimateFee(int64(len(builder.inputs)), int64(len(builder.outputs)+1), feesRate)
+	if err != nil {
+		return &openwallet.RawTransactionWithError{RawTx: rawTx, Error: openwallet.ConvertError(err)}
+	}
+
+	change, err := decoder.selectChangeAddress(wrapper, batch.Account, builder.inputs)
+	if err != nil {
+		return &openwallet.RawTransactionWithError{RawTx: rawTx, Error: openwallet.ConvertError(err)}
+	}
+
+	changeAmount := builder.balance.Sub(builder.total).Sub(fees)
+	changeAmount, fees = decoder.foldDustChange(change.Address, changeAmount, fees)
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	//手续费安全上限
+	if err = decoder.wm.CheckFees(fees, builder.total); err != nil {
+		return &openwallet.RawTransactionWithError{RawTx: rawTx, Error: openwallet.ConvertError(err)}
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Batch From Account: %s", batch.Account.AccountID)
+	decoder.wm.Log.Std.Notice("Payouts: %d", len(builder.outputs))
+	decoder.wm.Log.Std.Notice("Inputs: %d", len(builder.inputs))
+	decoder.wm.Log.Std.Notice("Use: %v", builder.balance.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Receive: %v", builder.total.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change: %v", changeAmount.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change Address: %v", change.Address)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	if changeAmount.GreaterThan(decimal.Zero) {
+		outputs = append(outputs, &TxOutput{Address: change.Address, Amount: changeAmount, IsChange: true})
+		rawTx.Change = change
+	}
+
+	err = decoder.createBTCRawTransaction(wrapper, rawTx, builder.inputs, outputs)
+	if err == nil {
+		err = decoder.commitChangeAddress(wrapper, rawTx)
+	}
+
+	return &openwallet.RawTransactionWithError{RawTx: rawTx, Error: openwallet.ConvertError(err)}
+}
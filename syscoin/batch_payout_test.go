@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestTransactionDecoder_PackBatchPayouts(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{Decimals: Decimals, MaxTxInputs: 2}}
+	wm.Log = log.NewOWLogger(Symbol)
+	decoder := NewTransactionDecoder(wm)
+
+	address := "sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc"
+	unspents := make([]*Unspent, 0)
+	for i := 0; i < 5; i++ {
+		unspents = append(unspents, &Unspent{Address: address, Amount: "1", Spendable: true})
+	}
+
+	payouts := []*BatchPayout{
+		{Address: address, Amount: "1.5"},
+		{Address: address, Amount: "1.5"},
+		{Address: address, Amount: "1.5"},
+		{Address: address, Amount: "0.00000001"},
+		{Address: address, Amount: "abc"},
+		{Address: address, Amount: "0.5"},
+	}
+
+	batches, failures := decoder.packBatchPayouts(unspents, payouts, decimal.Zero)
+
+	if len(batches) != 3 {
+		t.Fatalf("unexpected batches: %d", len(batches))
+	}
+	for i, want := range []string{"1.5", "1.5", "0.5"} {
+		b := batches[i]
+		if len(b.inputs) > wm.Config.MaxTxInputs {
+			t.Errorf("batch %d inputs: %d over limit", i, len(b.inputs))
+		}
+		if len(b.outputs) != 1 || b.outputs[0].Amount != want {
+			t.Errorf("batch %d unexpected outputs: %+v", i, b.outputs)
+		}
+	}
+
+	//第3笔1.5只剩1个utxo，余额不足；粉尘和无效金额直接失败
+	if len(failures) != 3 {
+		t.Fatalf("unexpected failures: %d", len(failures))
+	}
+	if failures[0].payout != payouts[2] || failures[0].err.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("unexpected failure: %+v", failures[0])
+	}
+	if failures[1].payout != payouts[3] || failures[2].payout != payouts[4] {
+		t.Errorf("unexpected failures: %+v, %+v", failures[1], failures[2])
+	}
+}
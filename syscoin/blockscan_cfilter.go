@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+/******************* 使用紧凑区块过滤器(BIP157/158)扫描区块 *******************/
+
+const (
+	//监听地址列表的缓存时间
+	watchedScriptsCacheTime = 10 * time.Second
+)
+
+//BlockFilterSource 紧凑区块过滤器的来源
+type BlockFilterSource interface {
+
+	//GetBlockFilter 获取区块的basic过滤器
+	GetBlockFilter(blockHash string) ([]byte, error)
+}
+
+//rpcBlockFilterSource 通过节点的getblockfilter接口获取过滤器，节点需要开启blockfilterindex
+type rpcBlockFilterSource struct {
+	wm *WalletManager
+}
+
+func (s *rpcBlockFilterSource) GetBlockFilter(blockHash string) ([]byte, error) {
+	return s.wm.GetBlockFilter(blockHash)
+}
+
+//GetBlockFilter 获取区块的basic过滤器
+func (wm *WalletManager) GetBlockFilter(blockHash string) ([]byte, error) {
+
+	request := []interface{}{
+		blockHash,
+		"basic",
+	}
+
+	result, err := wm.WalletClient.Call("getblockfilter", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(result.Get("filter").String())
+}
+
+//GetBlockHeader 获取区块头，不包含交易列表
+func (wm *WalletManager) GetBlockHeader(hash string) (*Block, error) {
+
+	request := []interface{}{
+		hash,
+	}
+
+	result, err := wm.WalletClient.Call("getblockheader", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.NewBlock(result), nil
+}
+
+//GetRawBlockHeader 获取原始区块头并解析，包括合并挖矿的AuxPow数据
+func (wm *WalletManager) GetRawBlockHeader(hash string) (*SyscoinBlockHeader, error) {
+
+	request := []interface{}{
+		hash,
+		false,
+	}
+
+	result, err := wm.WalletClient.Call("getblockheader", request)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := hex.DecodeString(result.String())
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := ParseBlockHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.BlockHash().String() != hash {
+		return nil, fmt.Errorf("block header hash: %s is not %s", header.BlockHash().String(), hash)
+	}
+
+	return header, nil
+}
+
+//GetVerifiedBlockHeader 获取区块头并验证工作量证明和合并挖矿证明
+func (wm *WalletManager) GetVerifiedBlockHeader(hash string) (*Block, error) {
+
+	block, err := wm.GetBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := wm.GetRawBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.PrevBlock.String() != block.Previousblockhash && block.Height > 0 {
+		return nil, fmt.Errorf("block: %s previous block hash mismatch", hash)
+	}
+
+	err = header.CheckProofOfWork(wm.P2PNetParams())
+	if err != nil {
+		return nil, err
+	}
+
+	block.AuxPow = header.AuxPow
+
+	return block, nil
+}
+
+//useBlockFilter 是否使用紧凑区块过滤器扫描
+func (bs *BTCBlockScanner) useBlockFilter() bool {
+	return bs.wm.Config.RPCServerType == RPCServerCFilter
+}
+
+//getScanBlock 获取扫描的区块，使用过滤器时只获取并验证区块头，匹配后再获取交易
+func (bs *BTCBlockScanner) getScanBlock(hash string) (*Block, error) {
+	if bs.useBlockFilter() {
+		if bs.useP2PBlockSource() {
+			return bs.getP2PBlockHeader(hash)
+		}
+		return bs.wm.GetVerifiedBlockHeader(hash)
+	}
+	return bs.getFullBlock(hash)
+}
+
+//extractBlock 提取区块的交易，使用过滤器时只有匹配监听地址的区块才获取交易
+func (bs *BTCBlockScanner) extractBlock(block *Block) error {
+
+	if !bs.useBlockFilter() {
+		return bs.extractFullBlock(block)
+	}
+
+	scripts, err := bs.getWatchedScripts()
+	if err != nil {
+		return bs.saveUnscanBlock(block.Height, err)
+	}
+
+	match, err := bs.matchBlockFilter(block.Hash, scripts)
+	if err != nil {
+		return bs.saveUnscanBlock(block.Height, err)
+	}
+
+	if !match {
+		return nil
+	}
+
+	bs.wm.Log.Std.Info("block scanner block filter matched on height: %d", block.Height)
+
+	full, err := bs.getFullBlock(block.Hash)
+	if err != nil {
+		return bs.saveUnscanBlock(block.Height, err)
+	}
+
+	return bs.extractFullBlock(full)
+}
+
+//saveUnscanBlock 记录未扫区块，由重扫策略获取完整区块处理
+func (bs *BTCBlockScanner) saveUnscanBlock(height uint64, reason error) error {
+	unscanRecord := openwallet.NewUnscanRecord(height, "", reason.Error(), bs.wm.Symbol())
+	bs.SaveUnscanRecord(unscanRecord)
+	bs.wm.Log.Std.Info("block height: %d extract failed.", height)
+	return reason
+}
+
+//matchBlockFilter 区块过滤器是否包含任意一个输出脚本
+func (bs *BTCBlockScanner) matchBlockFilter(blockHash string, scripts [][]byte) (bool, error) {
+
+	if len(scripts) == 0 {
+		return false, nil
+	}
+
+	var source BlockFilterSource = &rpcBlockFilterSource{wm: bs.wm}
+	if bs.FilterSource != nil {
+		source = bs.FilterSource
+	} else if bs.wm.P2P != nil {
+		source = bs.wm.P2P
+	}
+
+	raw, err := source.GetBlockFilter(blockHash)
+	if err != nil {
+		return false, err
+	}
+
+	filter, err := NewBasicBlockFilter(blockHash, raw)
+	if err != nil {
+		return false, err
+	}
+
+	return filter.MatchAny(scripts)
+}
+
+//getWatchedScripts 获取监听地址的输出脚本，短时间内使用缓存
+func (bs *BTCBlockScanner) getWatchedScripts() ([][]byte, error) {
+
+	bs.watchedMu.Lock()
+	defer bs.watchedMu.Unlock()
+
+	if bs.watchedScripts != nil && time.Since(bs.watchedUpdated) < watchedScriptsCacheTime {
+		return bs.watchedScripts, nil
+	}
+
+	if bs.WalletDAI == nil {
+		return nil, errors.New("wallet DAI is not setup")
+	}
+
+	addresses, err := bs.WalletDAI.GetAddressList(0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		list = append(list, a.Address)
+	}
+
+	bs.watchedScripts = bs.wm.addressesScriptPubKey(list)
+	bs.watchedUpdated = time.Now()
+
+	return bs.watchedScripts, nil
+}
+
+//addressesScriptPubKey 地址列表转为输出脚本，忽略非本链的地址
+func (wm *WalletManager) addressesScriptPubKey(addresses []string) [][]byte {
+	scripts := make([][]byte, 0, len(addresses))
+	for _, a := range addresses {
+		script, err := wm.addressScriptPubKey(a)
+		if err != nil {
+			continue
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
+//addressScriptPubKey 地址转为输出脚本
+func (wm *WalletManager) addressScriptPubKey(address string) ([]byte, error) {
+
+	p2pkh, p2sh, bech32 := SYS_mainnetAddressP2PKH, SYS_mainnetAddressP2SH, SYS_mainnetAddressBech32V0
+	if wm.Config.IsTestNet {
+		p2pkh, p2sh, bech32 = SYS_testnetAddressP2PKH, SYS_testnetAddressP2SH, SYS_testnetAddressBech32V0
+	}
+
+	if hash, err := addressEncoder.AddressDecode(address, bech32); err == nil {
+		return append([]byte{0x00, byte(len(hash))}, hash...), nil
+	}
+
+	if hash, err := addressEncoder.AddressDecode(address, p2pkh); err == nil {
+		script := append([]byte{0x76, 0xa9, 0x14}, hash...)
+		return append(script, 0x88, 0xac), nil
+	}
+
+	if hash, err := addressEncoder.AddressDecode(address, p2sh); err == nil {
+		script := append([]byte{0xa9, 0x14}, hash...)
+		return append(script, 0x87), nil
+	}
+
+	return nil, fmt.Errorf("address: %s is invalid", address)
+}
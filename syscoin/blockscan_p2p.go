@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/blocktree/go-owcdrivers/addressEncoder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+)
+
+/******************* 使用P2P节点作为扫块的区块来源 *******************/
+
+//useP2PBlockSource 是否通过P2P节点同步区块头并下载区块
+func (bs *BTCBlockScanner) useP2PBlockSource() bool {
+	return bs.wm.Config.P2PBlockSource && bs.wm.P2P != nil
+}
+
+//getScanBlockHeight 获取扫块的最新高度，P2P来源为已验证区块头链的高度
+func (bs *BTCBlockScanner) getScanBlockHeight() (uint64, error) {
+	if !bs.useP2PBlockSource() {
+		return bs.wm.GetBlockHeight()
+	}
+
+	tip, err := bs.wm.P2P.SyncHeaders()
+	if err != nil {
+		return 0, err
+	}
+	return tip.Height, nil
+}
+
+//getScanBlockHash 获取扫块高度的区块hash，P2P来源从已验证区块头链查找
+func (bs *BTCBlockScanner) getScanBlockHash(height uint64) (string, error) {
+	if !bs.useP2PBlockSource() {
+		return bs.wm.GetBlockHash(height)
+	}
+
+	chain := bs.wm.P2P.headerChain()
+	if chain == nil {
+		return "", errors.New("p2p checkpoint is not set")
+	}
+
+	header, ok := chain.HeaderByHeight(height)
+	if !ok {
+		return "", fmt.Errorf("block height: %d is not in validated header chain", height)
+	}
+	return header.Hash.String(), nil
+}
+
+//getFullBlock 获取包含交易列表的区块
+func (bs *BTCBlockScanner) getFullBlock(hash string) (*Block, error) {
+	if bs.useP2PBlockSource() {
+		return bs.getP2PBlock(hash)
+	}
+	return bs.wm.GetBlock(hash)
+}
+
+//getP2PBlock 通过P2P下载区块，区块头已验证工作量证明，交易已验证默克尔根
+func (bs *BTCBlockScanner) getP2PBlock(hash string) (*Block, error) {
+
+	raw, err := bs.wm.P2P.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := bs.getP2PBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block.tx = make([]string, 0, len(raw.Transactions))
+	block.txDetails = make([]*Transaction, 0, len(raw.Transactions))
+	for _, msgTx := range raw.Transactions {
+		tx, err := bs.wm.newTxByWire(msgTx)
+		if err != nil {
+			return nil, err
+		}
+		tx.BlockHeight = block.Height
+		tx.BlockHash = block.Hash
+		tx.Blocktime = int64(block.Time)
+		block.tx = append(block.tx, tx.TxID)
+		block.txDetails = append(block.txDetails, tx)
+	}
+	block.isVerbose = true
+
+	return block, nil
+}
+
+//getP2PBlockHeader 从已验证区块头链获取区块头，不包含交易列表
+func (bs *BTCBlockScanner) getP2PBlockHeader(hash string) (*Block, error) {
+
+	chain := bs.wm.P2P.headerChain()
+	if chain == nil {
+		return nil, errors.New("p2p checkpoint is not set")
+	}
+
+	h, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	header, ok := chain.HeaderByHash(h)
+	if !ok || header.Header == nil {
+		return nil, fmt.Errorf("block: %s is not in validated header chain", hash)
+	}
+
+	block := &Block{
+		Hash:              hash,
+		Merkleroot:        header.Header.MerkleRoot.String(),
+		Previousblockhash: header.Header.PrevBlock.String(),
+		Height:            header.Height,
+		Version:           uint64(uint32(header.Header.Version)),
+		Time:              uint64(header.Header.Timestamp.Unix()),
+		Bits:              fmt.Sprintf("%08x", header.Header.Bits),
+		Nonce:             uint64(header.Header.Nonce),
+		AuxPow:            header.Header.AuxPow,
+	}
+
+	if tip := chain.Tip(); tip.Height >= header.Height {
+		block.Confirmations = tip.Height - header.Height + 1
+	}
+
+	return block, nil
+}
+
+//extractFullBlock 提取完整区块的交易，P2P区块的交易详情在提取期间缓存
+func (bs *BTCBlockScanner) extractFullBlock(block *Block) error {
+
+	if len(block.tx) == 0 {
+		return nil
+	}
+
+	if block.isVerbose {
+		bs.cacheBlockTxs(block.txDetails)
+		defer bs.uncacheBlockTxs(block.txDetails)
+	}
+
+	return bs.BatchExtractTransaction(block.Height, block.Hash, block.tx)
+}
+
+func (bs *BTCBlockScanner) cacheBlockTxs(txs []*Transaction) {
+	bs.blockTxsMu.Lock()
+	defer bs.blockTxsMu.Unlock()
+
+	if bs.blockTxs == nil {
+		bs.blockTxs = make(map[string]*Transaction)
+	}
+	for _, tx := range txs {
+		bs.blockTxs[tx.TxID] = tx
+	}
+}
+
+func (bs *BTCBlockScanner) uncacheBlockTxs(txs []*Transaction) {
+	bs.blockTxsMu.Lock()
+	defer bs.blockTxsMu.Unlock()
+
+	for _, tx := range txs {
+		delete(bs.blockTxs, tx.TxID)
+	}
+}
+
+//getScanTransaction 获取交易单，优先使用正在提取的区块中的交易
+func (bs *BTCBlockScanner) getScanTransaction(txid string) (*Transaction, error) {
+
+	bs.blockTxsMu.Lock()
+	tx, ok := bs.blockTxs[txid]
+	bs.blockTxsMu.Unlock()
+
+	if ok {
+		//返回副本，提取时会填充输入的地址和金额
+		return tx.copy(), nil
+	}
+
+	return bs.wm.GetTransaction(txid)
+}
+
+//copy 复制交易单及其输入输出
+func (tx *Transaction) copy() *Transaction {
+	obj := *tx
+	obj.Vins = make([]*Vin, 0, len(tx.Vins))
+	for _, in := range tx.Vins {
+		vin := *in
+		obj.Vins = append(obj.Vins, &vin)
+	}
+	obj.Vouts = make([]*Vout, 0, len(tx.Vouts))
+	for _, out := range tx.Vouts {
+		vout := *out
+		obj.Vouts = append(obj.Vouts, &vout)
+	}
+	return &obj
+}
+
+//newTxByWire 解析P2P区块中的交易，输入的地址和金额需要查询上一笔交易
+func (wm *WalletManager) newTxByWire(msgTx *wire.MsgTx) (*Transaction, error) {
+
+	var buf bytes.Buffer
+	err := msgTx.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := Transaction{}
+	obj.TxID = msgTx.TxHash().String()
+	obj.Version = uint64(uint32(msgTx.Version))
+	obj.LockTime = int64(msgTx.LockTime)
+	obj.Hex = hex.EncodeToString(buf.Bytes())
+	obj.Size = uint64(buf.Len())
+	obj.Decimals = wm.Decimal()
+	obj.IsCoinBase = isCoinBaseTx(msgTx)
+
+	obj.Vins = make([]*Vin, 0, len(msgTx.TxIn))
+	for i, in := range msgTx.TxIn {
+		input := &Vin{N: uint64(i)}
+		if obj.IsCoinBase {
+			input.Coinbase = hex.EncodeToString(in.SignatureScript)
+		} else {
+			input.TxID = in.PreviousOutPoint.Hash.String()
+			input.Vout = uint64(in.PreviousOutPoint.Index)
+		}
+		obj.Vins = append(obj.Vins, input)
+	}
+
+	obj.Vouts = make([]*Vout, 0, len(msgTx.TxOut))
+	for i, out := range msgTx.TxOut {
+		addr, scriptType := wm.scriptPubKeyAddress(out.PkScript)
+		obj.Vouts = append(obj.Vouts, &Vout{
+			N:            uint64(i),
+			Addr:         addr,
+			Value:        decimal.New(out.Value, -wm.Decimal()).String(),
+			ScriptPubKey: hex.EncodeToString(out.PkScript),
+			Type:         scriptType,
+		})
+	}
+
+	return &obj, nil
+}
+
+//isCoinBaseTx 是否coinbase交易
+func isCoinBaseTx(msgTx *wire.MsgTx) bool {
+	if len(msgTx.TxIn) != 1 {
+		return false
+	}
+	prev := msgTx.TxIn[0].PreviousOutPoint
+	return prev.Index == wire.MaxPrevOutIndex && prev.Hash == (chainhash.Hash{})
+}
+
+//scriptPubKeyAddress 输出脚本转为地址，类型名称与节点接口一致，非标准脚本没有地址
+func (wm *WalletManager) scriptPubKeyAddress(script []byte) (string, string) {
+
+	p2pkh, p2sh, bech32 := SYS_mainnetAddressP2PKH, SYS_mainnetAddressP2SH, SYS_mainnetAddressBech32V0
+	if wm.Config.IsTestNet {
+		p2pkh, p2sh, bech32 = SYS_testnetAddressP2PKH, SYS_testnetAddressP2SH, SYS_testnetAddressBech32V0
+	}
+
+	switch {
+	case len(script) == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 0x14 && script[23] == 0x88 && script[24] == 0xac:
+		return addressEncoder.AddressEncode(script[3:23], p2pkh), "pubkeyhash"
+	case len(script) == 23 && script[0] == 0xa9 && script[1] == 0x14 && script[22] == 0x87:
+		return addressEncoder.AddressEncode(script[2:22], p2sh), "scripthash"
+	case len(script) == 22 && script[0] == 0x00 && script[1] == 0x14:
+		return addressEncoder.AddressEncode(script[2:], bech32), "witness_v0_keyhash"
+	case len(script) == 34 && script[0] == 0x00 && script[1] == 0x20:
+		return addressEncoder.AddressEncode(script[2:], bech32), "witness_v0_scripthash"
+	case len(script) > 0 && script[0] == 0x6a:
+		return "", "nulldata"
+	}
+
+	return "", "nonstandard"
+}
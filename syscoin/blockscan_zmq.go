@@ -0,0 +1,157 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+/******************* 使用核心钱包 zmq 监听区块 *******************/
+
+//sequence主题的事件标记
+const (
+	zmqSequenceBlockConnect    = 'C'
+	zmqSequenceBlockDisconnect = 'D'
+	zmqSequenceTxAdded         = 'A'
+	zmqSequenceTxRemoved       = 'R'
+)
+
+//subscribeZMQ 连接zmq，订阅新区块和内存池交易，阻塞直到断线或停止
+func (bs *BTCBlockScanner) subscribeZMQ(ctx context.Context, connected func()) error {
+
+	var (
+		messages     = make(chan *ZMQMessage)
+		disconnected = make(chan error, 1)
+	)
+
+	sub, err := DialZMQ(bs.wm.Config.ZMQAPI, ZMQTopicHashBlock, ZMQTopicRawTx, ZMQTopicSequence)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	bs.wm.Log.Info("block scanner zmq connected")
+	connected()
+
+	//连接后马上补扫一次，避免断线期间错过区块
+	bs.triggerScanBlockTask()
+
+	go bs.readZMQ(ctx, sub, messages, disconnected)
+
+	for {
+		select {
+		case msg := <-messages:
+			bs.handleZMQMessage(msg)
+		case err = <-disconnected:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+//readZMQ 读取推送消息，连接断开后通知重连
+func (bs *BTCBlockScanner) readZMQ(ctx context.Context, sub *ZMQSubscriber, messages chan<- *ZMQMessage, disconnected chan<- error) {
+	for {
+		msg, err := sub.ReadMessage()
+		if err != nil {
+			disconnected <- err
+			return
+		}
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//handleZMQMessage 处理推送消息
+func (bs *BTCBlockScanner) handleZMQMessage(msg *ZMQMessage) {
+
+	switch msg.Topic {
+	case ZMQTopicHashBlock:
+		bs.wm.Log.Debugf("block scanner zmq new block: %s", hex.EncodeToString(msg.Body))
+		bs.triggerScanBlockTask()
+	case ZMQTopicRawTx:
+		bs.extractZMQRawTx(msg.Body)
+	case ZMQTopicSequence:
+		//格式：32字节hash | 1字节事件 | 8字节内存池序号(A/R)
+		if len(msg.Body) < 33 {
+			return
+		}
+		hash := hex.EncodeToString(msg.Body[:32])
+		switch msg.Body[32] {
+		case zmqSequenceBlockConnect, zmqSequenceBlockDisconnect:
+			//区块连接或断开，扫描任务会处理分叉
+			bs.triggerScanBlockTask()
+		case zmqSequenceTxAdded:
+			//新交易的内容由rawtx主题提取
+		case zmqSequenceTxRemoved:
+			bs.wm.Log.Debugf("block scanner zmq mempool tx removed: %s", hash)
+			//交易离开内存池，检查是否被移除或已确认
+			bs.Mempool.Recheck([]string{hash})
+		}
+	}
+}
+
+//extractZMQRawTx 提取内存池推送的原始交易
+func (bs *BTCBlockScanner) extractZMQRawTx(raw []byte) {
+
+	if !bs.IsScanMemPool {
+		return
+	}
+
+	var msgTx wire.MsgTx
+	err := msgTx.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not decode zmq raw transaction; unexpected error: %v", err)
+		return
+	}
+
+	txid := msgTx.TxHash().String()
+
+	err = bs.BatchExtractTransaction(0, "", []string{txid})
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+	}
+}
+
+//triggerScanBlockTask 通知马上执行扫描任务，正在扫描时只保留一次等待
+func (bs *BTCBlockScanner) triggerScanBlockTask() {
+	select {
+	case bs.scanSignal <- struct{}{}:
+	default:
+	}
+}
+
+//scanBlockTaskRuntime 接收扫描通知，执行扫描任务
+func (bs *BTCBlockScanner) scanBlockTaskRuntime(ctx context.Context) {
+	for {
+		select {
+		case <-bs.scanSignal:
+			if bs.Scanning {
+				bs.ScanBlockTask()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -16,9 +16,11 @@
 package syscoin
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/tidwall/gjson"
+	"math"
 	"net/url"
 	"strings"
 	"sync"
@@ -38,20 +40,37 @@ const (
 
 	RPCServerCore     = 0 //RPC服务，bitcoin核心钱包
 	RPCServerExplorer = 1 //RPC服务，insight-API
+	RPCServerCFilter  = 2 //RPC服务，全节点的紧凑区块过滤器(BIP157/158)，节点不需要开启钱包
 )
 
 //BTCBlockScanner bitcoin的区块链扫描器
 type BTCBlockScanner struct {
 	*openwallet.BlockScannerBase
 
-	CurrentBlockHeight   uint64             //当前区块高度
-	extractingCH         chan struct{}      //扫描工作令牌
-	wm                   *WalletManager     //钱包管理者
-	IsScanMemPool        bool               //是否扫描交易池
-	RescanLastBlockCount uint64             //重扫上N个区块数量
-	socketIO             *gosocketio.Client //socketIO客户端
-	setupSocketIOOnce    sync.Once
-	stopSocketIO         chan struct{}
+	CurrentBlockHeight    uint64               //当前区块高度
+	extractingCH          chan struct{}        //扫描工作令牌
+	wm                    *WalletManager       //钱包管理者
+	IsScanMemPool         bool                 //是否扫描交易池
+	RescanLastBlockCount  uint64               //重扫上N个区块数量
+	NotifyDedupWindow     time.Duration        //推送去重的时间窗口，0则不去重
+	Mempool               *MempoolWatcher      //内存池交易跟踪
+	UnscanMaxAttempts     int                  //未扫记录最大重试次数，超过转入死信，0则不限制
+	UnscanRetryMinBackoff time.Duration        //未扫记录重试的最小等待时间
+	UnscanRetryMaxBackoff time.Duration        //未扫记录重试的最大等待时间
+	subscriptions         *SubscriptionManager //推送订阅管理
+	notified              *notifyDedup         //已通知的交易记录
+	scanSignal            chan struct{}        //马上执行扫描任务的通知
+	scanTaskMu            sync.Mutex           //扫描任务锁，定时任务与推送通知不能同时扫描
+	rescanJobs            map[string]*AddressRescanJob
+	rescanJobsMu          sync.Mutex
+	FilterSource          BlockFilterSource //紧凑区块过滤器来源，为空则使用节点的getblockfilter
+	watchedScripts        [][]byte          //监听地址的输出脚本缓存
+	watchedUpdated        time.Time
+	watchedMu             sync.Mutex
+	blockTxs              map[string]*Transaction //正在提取的P2P区块交易
+	blockTxsMu            sync.Mutex
+	trustMu               sync.Mutex
+	untrustedReason       string //数据源不可信的原因，空为可信
 
 	//用于实现浏览器
 	IsSkipFailedBlock bool                                    //是否跳过失败区块
@@ -61,11 +80,28 @@ type BTCBlockScanner struct {
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
 	extractData     map[string]*openwallet.TxExtractData
-	extractOmniData map[string]*openwallet.TxExtractData //代币交易
+	extractOmniData map[string][]*openwallet.TxExtractData //代币交易，一笔交易可能有多个资产
 	TxID            string
 	BlockHeight     uint64
+	BlockHash       string
 	Success         bool
 	IsOmniTransfer  bool
+	trx             *Transaction
+	reason          string //提取失败的原因
+}
+
+//sourceKeys 提取结果相关的账户
+func (r *ExtractResult) sourceKeys() []string {
+	keys := make([]string, 0, len(r.extractData)+len(r.extractOmniData))
+	for key := range r.extractData {
+		keys = append(keys, key)
+	}
+	for key := range r.extractOmniData {
+		if _, ok := r.extractData[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 //SaveResult 保存结果
@@ -85,7 +121,14 @@ func NewBTCBlockScanner(wm *WalletManager) *BTCBlockScanner {
 	bs.wm = wm
 	bs.IsScanMemPool = true
 	bs.RescanLastBlockCount = 0
-	bs.stopSocketIO = make(chan struct{})
+	bs.NotifyDedupWindow = defaultNotifyDedupWindow
+	bs.notified = newNotifyDedup()
+	bs.Mempool = NewMempoolWatcher(wm)
+	bs.UnscanMaxAttempts = defaultUnscanMaxAttempts
+	bs.rescanJobs = make(map[string]*AddressRescanJob)
+	bs.UnscanRetryMinBackoff = defaultUnscanRetryMinBackoff
+	bs.UnscanRetryMaxBackoff = defaultUnscanRetryMaxBackoff
+	bs.scanSignal = make(chan struct{}, 1)
 	bs.BTCBlockObservers = make(map[BTCBlockScanNotificationObject]bool)
 	//bs.RPCServer = RPCServerCore
 
@@ -109,12 +152,18 @@ func (bs *BTCBlockScanner) SetRescanBlockHeight(height uint64) error {
 
 	bs.SaveLocalNewBlock(height, hash)
 
+	//重扫的区块需要重新通知
+	bs.notified.ClearHeights(height+1, math.MaxUint64)
+
 	return nil
 }
 
 //ScanBlockTask 扫描任务
 func (bs *BTCBlockScanner) ScanBlockTask() {
 
+	bs.scanTaskMu.Lock()
+	defer bs.scanTaskMu.Unlock()
+
 	//获取本地区块高度
 	blockHeader, err := bs.GetScannedBlockHeader()
 	if err != nil {
@@ -133,7 +182,7 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 		}
 
 		//获取最大高度
-		maxHeight, err := bs.wm.GetBlockHeight()
+		maxHeight, err := bs.getScanBlockHeight()
 		if err != nil {
 			//下一个高度找不到会报异常
 			bs.wm.Log.Std.Info("block scanner can not get rpc-server block height; unexpected error: %v", err)
@@ -151,7 +200,7 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 
 		bs.wm.Log.Std.Info("block scanner scanning height: %d ...", currentHeight)
 
-		hash, err := bs.wm.GetBlockHash(currentHeight)
+		hash, err := bs.getScanBlockHash(currentHeight)
 		if err != nil {
 			//下一个高度找不到会报异常
 			bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
@@ -173,7 +222,7 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			}
 		}
 
-		block, err := bs.wm.GetBlock(hash)
+		block, err := bs.getScanBlock(hash)
 		if err != nil {
 			bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -214,13 +263,13 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 				//查找core钱包的RPC
 				bs.wm.Log.Info("block scanner prev block height:", currentHeight)
 
-				prevHash, err := bs.wm.GetBlockHash(currentHeight)
+				prevHash, err := bs.getScanBlockHash(currentHeight)
 				if err != nil {
 					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
 					break
 				}
 
-				localBlock, err = bs.wm.GetBlock(prevHash)
+				localBlock, err = bs.getFullBlock(prevHash)
 				if err != nil {
 					bs.wm.Log.Std.Error("block scanner can not get prev block; unexpected error: %v", err)
 					break
@@ -236,6 +285,17 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 			//重新记录一个新扫描起点
 			bs.SaveLocalNewBlock(localBlock.Height, localBlock.Hash)
 
+			//分叉后重扫的区块需要重新通知
+			bs.notified.ClearHeights(localBlock.Height+1, math.MaxUint64)
+
+			//回滚分叉区块的本地UTXO
+			if bs.useBlockFilter() {
+				err = bs.wm.rollbackLocalUnspent(localBlock.Height + 1)
+				if err != nil {
+					bs.wm.Log.Std.Error("block scanner can not rollback local unspent; unexpected error: %v", err)
+				}
+			}
+
 			isFork = true
 
 			if forkBlock != nil {
@@ -246,7 +306,7 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 
 		} else {
 
-			err = bs.BatchExtractTransaction(block.Height, block.Hash, block.tx)
+			err = bs.extractBlock(block)
 			if err != nil {
 				bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 			}
@@ -284,6 +344,9 @@ func (bs *BTCBlockScanner) ScanBlockTask() {
 //ScanBlock 扫描指定高度区块
 func (bs *BTCBlockScanner) ScanBlock(height uint64) error {
 
+	//重扫的区块需要重新通知
+	bs.notified.ClearHeights(height, height)
+
 	block, err := bs.scanBlock(height)
 	if err != nil {
 		return err
@@ -297,14 +360,14 @@ func (bs *BTCBlockScanner) ScanBlock(height uint64) error {
 
 func (bs *BTCBlockScanner) scanBlock(height uint64) (*Block, error) {
 
-	hash, err := bs.wm.GetBlockHash(height)
+	hash, err := bs.getScanBlockHash(height)
 	if err != nil {
 		//下一个高度找不到会报异常
 		bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
 		return nil, err
 	}
 
-	block, err := bs.wm.GetBlock(hash)
+	block, err := bs.getScanBlock(hash)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
 
@@ -317,7 +380,7 @@ func (bs *BTCBlockScanner) scanBlock(height uint64) (*Block, error) {
 
 	bs.wm.Log.Std.Info("block scanner scanning height: %d ...", block.Height)
 
-	err = bs.BatchExtractTransaction(block.Height, block.Hash, block.tx)
+	err = bs.extractBlock(block)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
 	}
@@ -340,6 +403,9 @@ func (bs *BTCBlockScanner) ScanTxMemPool() {
 		return
 	}
 
+	//检查跟踪中的交易是否已离开内存池
+	bs.Mempool.Reconcile(txIDsInMemPool)
+
 	if txIDsInMemPool == nil || len(txIDsInMemPool) == 0 {
 		return
 	}
@@ -351,73 +417,104 @@ func (bs *BTCBlockScanner) ScanTxMemPool() {
 
 }
 
-//rescanFailedRecord 重扫失败记录
+//RescanFailedRecord 重扫失败记录，按重试策略退避，超过最大重试次数的记录转入死信
 func (bs *BTCBlockScanner) RescanFailedRecord() {
 
-	var (
-		blockMap = make(map[uint64][]string)
-	)
+	//数据源不可信时暂停重扫，避免暂停通知的充值记录耗尽重试次数
+	if trusted, reason := bs.IsBackendTrusted(); !trusted {
+		bs.wm.Log.Std.Info("block scanner skip rescan, backend is untrusted: %s", reason)
+		return
+	}
 
 	list, err := bs.GetUnscanRecords()
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not get rescan data; unexpected error: %v", err)
+		return
 	}
 
-	//组合成批处理
+	now := time.Now()
+
 	for _, r := range list {
 
-		if _, exist := blockMap[r.BlockHeight]; !exist {
-			blockMap[r.BlockHeight] = make([]string, 0)
+		if !bs.Scanning {
+			return
 		}
 
-		if len(r.TxID) > 0 {
-			arr := blockMap[r.BlockHeight]
-			arr = append(arr, r.TxID)
-
-			blockMap[r.BlockHeight] = arr
+		retry := bs.getUnscanRetry(r.ID)
+		if retry.NextRetry > now.Unix() {
+			continue
 		}
-	}
 
-	for height, txs := range blockMap {
+		if len(r.TxID) > 0 {
+			bs.wm.Log.Std.Info("block scanner rescanning height: %d, txid: %s ...", r.BlockHeight, r.TxID)
+		} else {
+			bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", r.BlockHeight)
+		}
 
-		if height == 0 {
+		err = bs.rescanUnscanRecord(r)
+		if err == nil {
+			//删除未扫记录
+			delErr := bs.deleteUnscanRecordByID(r.ID)
+			if delErr != nil {
+				bs.wm.Log.Std.Error("block scanner delete unscan record: %s failed; unexpected error: %v", r.ID, delErr)
+			}
+			bs.deleteUnscanRetry(r.ID)
 			continue
 		}
 
-		var hash string
+		bs.wm.Log.Std.Info("block scanner rescan record: %s failed; unexpected error: %v", r.ID, err)
 
-		bs.wm.Log.Std.Info("block scanner rescanning height: %d ...", height)
+		retry.Attempts++
+		retry.LastError = err.Error()
+
+		if bs.UnscanMaxAttempts > 0 && retry.Attempts >= bs.UnscanMaxAttempts {
+			bs.moveToDeadUnscanRecord(r, retry)
+			continue
+		}
 
-		if len(txs) == 0 {
+		retry.NextRetry = now.Add(bs.unscanRetryBackoff(retry.Attempts)).Unix()
+		bs.saveUnscanRetry(retry)
+	}
 
-			hash, err := bs.wm.GetBlockHash(height)
-			if err != nil {
-				//下一个高度找不到会报异常
-				bs.wm.Log.Std.Info("block scanner can not get new block hash; unexpected error: %v", err)
-				continue
-			}
+	//删除未没有找到交易记录的重扫记录
+	bs.DeleteUnscanRecordNotFindTX()
+}
 
-			block, err := bs.wm.GetBlock(hash)
-			if err != nil {
-				bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", err)
-				continue
-			}
+//rescanUnscanRecord 重扫一条未扫记录，没有交易id的记录重扫整个区块
+func (bs *BTCBlockScanner) rescanUnscanRecord(r *openwallet.UnscanRecord) error {
 
-			txs = block.tx
-		}
+	var (
+		hash string
+		err  error
+	)
 
-		err = bs.BatchExtractTransaction(height, hash, txs)
+	if r.BlockHeight > 0 {
+		hash, err = bs.getScanBlockHash(r.BlockHeight)
 		if err != nil {
-			bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
-			continue
+			return err
 		}
+	}
 
-		//删除未扫记录
-		bs.DeleteUnscanRecord(height)
+	if len(r.TxID) > 0 {
+		return bs.BatchExtractTransaction(r.BlockHeight, hash, []string{r.TxID})
 	}
 
-	//删除未没有找到交易记录的重扫记录
-	bs.DeleteUnscanRecordNotFindTX()
+	if r.BlockHeight == 0 {
+		return nil
+	}
+
+	block, err := bs.getFullBlock(hash)
+	if err != nil {
+		return err
+	}
+
+	//区块中提取失败的交易会单独记录，区块记录可以删除
+	err = bs.extractFullBlock(block)
+	if err != nil {
+		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
+	}
+
+	return nil
 }
 
 //newBlockNotify 获得新区块后，通知给观测者
@@ -430,6 +527,11 @@ func (bs *BTCBlockScanner) newBlockNotify(block *Block, isFork bool) {
 //BatchExtractTransaction 批量提取交易单
 //bitcoin 1M的区块链可以容纳3000笔交易，批量多线程处理，速度更快
 func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash string, txs []string) error {
+	return bs.batchExtractTransaction(blockHeight, blockHash, txs, bs.ScanTargetFuncV2, true)
+}
+
+//batchExtractTransaction 批量提取交易单，可指定查找扫描对象的方法，dedup为false时不检查是否已通知
+func (bs *BTCBlockScanner) batchExtractTransaction(blockHeight uint64, blockHash string, txs []string, scanAddressFunc openwallet.BlockScanTargetFuncV2, dedup bool) error {
 
 	var (
 		quit       = make(chan struct{})
@@ -457,25 +559,56 @@ func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 
 			if gets.Success {
 
+				//节点不开启钱包时，本地维护UTXO
+				if bs.useBlockFilter() {
+					utxoErr := bs.wm.saveLocalUnspent(&gets)
+					if utxoErr != nil {
+						bs.wm.Log.Std.Error("block height: %d, txid: %s save local unspent failed; unexpected error: %v", height, gets.TxID, utxoErr)
+					}
+				}
+
+				//检查跟踪中的未确认交易是否已确认、被替换或双花
+				bs.Mempool.Process(gets.trx)
+				if len(gets.BlockHash) == 0 && len(gets.extractData)+len(gets.extractOmniData) > 0 {
+					bs.Mempool.Track(gets.trx, gets.sourceKeys())
+				}
+
+				//已通知过的交易，打包后不再重复通知
+				if dedup && bs.notified.Seen(gets.TxID, gets.BlockHeight, bs.NotifyDedupWindow) {
+					done++
+					if done == shouldDone {
+						close(quit)
+					}
+					continue
+				}
+
+				notified := true
+
 				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
 				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
+					notified = false
 					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
 				}
 
 				notifyErr = nil
-				notifyErr = bs.newExtractDataNotify(height, gets.extractOmniData)
+				notifyErr = bs.newOmniExtractDataNotify(height, gets.extractOmniData)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
+					notified = false
 					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
 				}
 
+				if notified && len(gets.extractData)+len(gets.extractOmniData) > 0 {
+					bs.notified.Mark(gets.TxID, gets.BlockHeight, bs.NotifyDedupWindow)
+				}
+
 			} else {
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
+				//记录未扫交易
+				unscanRecord := openwallet.NewUnscanRecord(height, gets.TxID, gets.reason, bs.wm.Symbol())
 				bs.SaveUnscanRecord(unscanRecord)
-				bs.wm.Log.Std.Info("block height: %d extract failed.", height)
+				bs.wm.Log.Std.Info("block height: %d, txid: %s extract failed.", height, gets.TxID)
 				failed++ //标记保存失败数
 			}
 			//累计完成的线程数
@@ -495,7 +628,7 @@ func (bs *BTCBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 			go func(mBlockHeight uint64, mTxid string, end chan struct{}, mProducer chan<- ExtractResult) {
 
 				//导出提出的交易
-				mProducer <- bs.ExtractTransaction(mBlockHeight, eBlockHash, mTxid, bs.ScanTargetFuncV2)
+				mProducer <- bs.ExtractTransaction(mBlockHeight, eBlockHash, mTxid, scanAddressFunc)
 				//释放
 				<-end
 
@@ -567,7 +700,7 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 			BlockHeight:     blockHeight,
 			TxID:            txid,
 			extractData:     make(map[string]*openwallet.TxExtractData),
-			extractOmniData: make(map[string]*openwallet.TxExtractData),
+			extractOmniData: make(map[string][]*openwallet.TxExtractData),
 		}
 
 		omniTrx *OmniTransaction
@@ -575,11 +708,12 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 
 	//bs.wm.Log.Std.Debug("block scanner scanning tx: %s ...", txid)
 	//获取bitcoin的交易单
-	trx, err := bs.wm.GetTransaction(txid)
+	trx, err := bs.getScanTransaction(txid)
 
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extract transaction data; unexpected error: %v", err)
 		result.Success = false
+		result.reason = err.Error()
 		return result
 	}
 
@@ -589,6 +723,10 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 		trx.BlockHash = blockHash
 	}
 
+	result.BlockHeight = trx.BlockHeight
+	result.BlockHash = trx.BlockHash
+	result.trx = trx
+
 	if bs.wm.Config.OmniSupport {
 		//获取omni的交易单
 		omniTrx, _ = bs.wm.GetOmniTransaction(txid)
@@ -604,6 +742,24 @@ func (bs *BTCBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 		bs.extractOmniTransaction(omniTrx, &result, scanAddressFunc)
 	}
 
+	//验证充值交易包含在区块中，验证失败不通知，记录为未扫交易
+	if bs.wm.Config.VerifyMerkleProof && result.Success && trx.BlockHeight > 0 &&
+		(len(result.extractData) > 0 || len(result.extractOmniData) > 0) {
+		err = bs.verifyTxInclusion(trx.TxID, trx.BlockHash)
+		if err != nil {
+			result.Success = false
+			result.reason = "merkle proof verify failed: " + err.Error()
+		}
+	}
+
+	//数据源不可信时暂停充值通知，记录为未扫交易，人工确认后重扫
+	if result.Success && (len(result.extractData) > 0 || len(result.extractOmniData) > 0) {
+		if trusted, reason := bs.IsBackendTrusted(); !trusted {
+			result.Success = false
+			result.reason = "backend is untrusted: " + reason
+		}
+	}
+
 	////bs.wm.Log.Debug("start extractTransaction")
 	//bs.extractTransaction(trx, &result, scanAddressFunc)
 	////bs.wm.Log.Debug("end extractTransaction")
@@ -629,132 +785,237 @@ func (bs *BTCBlockScanner) extractOmniTransaction(trx *OmniTransaction, result *
 
 	var (
 		success = true
-		status  = openwallet.TxStatusSuccess
 	)
 
 	if trx == nil {
 		success = true
 	} else {
 
-		if trx.Valid {
-			status = openwallet.TxStatusSuccess
-		} else {
-			status = openwallet.TxStatusFail
+		//补充节点交易详情中没有的数据，失败则记录为未扫交易
+		if err := bs.completeOmniTransaction(trx); err != nil {
+			bs.wm.Log.Std.Info("block scanner can not complete omni transaction: %s; unexpected error: %v", trx.TxID, err)
+			result.Success = false
+			result.reason = err.Error()
+			return
 		}
-		createAt := time.Now().Unix()
-		propertyID := common.NewString(trx.PropertyId).String()
-		contractId := openwallet.GenContractID(bs.wm.Symbol(), propertyID)
 
-		coin := openwallet.Coin{
-			Symbol:     bs.wm.Symbol(),
-			IsContract: true,
-			ContractID: contractId,
-			Contract: openwallet.SmartContract{
-				ContractID: contractId,
-				Address:    propertyID,
-				Protocol:   "omnicore",
-				Symbol:     bs.wm.Symbol(),
-			},
+		//一笔交易可能包含多个资产或多个接收地址
+		for _, transfer := range newOmniTransfers(trx) {
+			bs.extractOmniTransfer(trx, transfer, result, scanAddressFunc)
 		}
 
-		amountDec, _ := decimal.NewFromString(trx.Amount)
-		amountDec = amountDec.Shift(bs.wm.Decimal())
-		amount := amountDec.StringFixed(0)
-		//sourceKey, ok := scanAddressFunc(trx.SendingAddress)
-		targetResult := scanAddressFunc(openwallet.ScanTargetParam{
-			ScanTarget:     trx.SendingAddress,
-			Symbol:         bs.wm.Symbol(),
-			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
-		if targetResult.Exist {
-			input := openwallet.TxInput{}
-			input.TxID = trx.TxID
-			input.Address = trx.SendingAddress
-			//transaction.AccountID = a.AccountID
-			input.Amount = amount
-			input.Coin = coin
-			input.Index = 0
-			input.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), contractId, 0)
-			//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
-			input.CreateAt = createAt
-			//在哪个区块高度时消费
-			input.BlockHeight = trx.Block
-			input.BlockHash = trx.BlockHash
-			input.TxType = 0
+		success = true
 
-			//transactions = append(transactions, &transaction)
+	}
 
-			ed := result.extractOmniData[targetResult.SourceKey]
-			if ed == nil {
-				ed = openwallet.NewBlockExtractData()
-				result.extractOmniData[targetResult.SourceKey] = ed
-			}
+	result.Success = success
 
-			ed.TxInputs = append(ed.TxInputs, &input)
+}
+
+//completeOmniTransaction 补充Send To Owners的接收地址和DEx Purchase的资产精度
+func (bs *BTCBlockScanner) completeOmniTransaction(trx *OmniTransaction) error {
+
+	if !trx.Valid {
+		return nil
+	}
 
+	switch omniTxTypeOf(trx) {
+	case OmniTxTypeSendToOwners:
+		if len(trx.Receivers) > 0 {
+			return nil
 		}
+		receivers, err := bs.wm.GetOmniSTORecipients(trx.TxID)
+		if err != nil {
+			return err
+		}
+		trx.Receivers = receivers
+	case OmniTxTypeDExPurchase:
+		for _, p := range trx.Purchases {
+			property, err := bs.wm.GetOmniPropertyInfo(p.PropertyId)
+			if err != nil {
+				return err
+			}
+			p.Divisible = property.Divisible
+		}
+	}
 
-		//sourceKey2, ok2 := scanAddressFunc(trx.ReferenceAddress)
-		targetResult2 := scanAddressFunc(openwallet.ScanTargetParam{
-			ScanTarget:     trx.ReferenceAddress,
-			Symbol:         bs.wm.Symbol(),
-			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
-		if targetResult2.Exist {
-			output := openwallet.TxOutPut{}
-			output.TxID = trx.TxID
-			output.Address = trx.ReferenceAddress
-			//transaction.AccountID = a.AccountID
-			output.Amount = amount
+	return nil
+}
 
-			output.Coin = coin
-			output.Index = 0
-			output.Sid = openwallet.GenTxOutPutSID(trx.TxID, bs.wm.Symbol(), contractId, 0)
-			//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
-			output.CreateAt = createAt
-			//在哪个区块高度时消费
-			output.BlockHeight = trx.Block
-			output.BlockHash = trx.BlockHash
-			output.TxType = 0
+//extractOmniTransfer 提取Omni交易中一个资产的转移
+func (bs *BTCBlockScanner) extractOmniTransfer(trx *OmniTransaction, transfer *omniTransfer, result *ExtractResult, scanAddressFunc openwallet.BlockScanTargetFuncV2) {
 
-			//transactions = append(transactions, &transaction)
+	var (
+		status   = openwallet.TxStatusSuccess
+		txType   = omniNotifyTxType(transfer.TxType)
+		extracts = make(map[string]*openwallet.TxExtractData)
+	)
 
-			ed := result.extractOmniData[targetResult2.SourceKey]
-			if ed == nil {
-				ed = openwallet.NewBlockExtractData()
-				result.extractOmniData[targetResult2.SourceKey] = ed
-			}
+	if trx.Valid {
+		status = openwallet.TxStatusSuccess
+	} else {
+		status = openwallet.TxStatusFail
+	}
+	createAt := time.Now().Unix()
+	propertyID := common.NewString(transfer.PropertyID).String()
+	contractId := openwallet.GenContractID(bs.wm.Symbol(), propertyID)
 
-			ed.TxOutputs = append(ed.TxOutputs, &output)
+	//不可分割资产的精度为0
+	decimals := int32(0)
+	if transfer.Divisible {
+		decimals = omniDivisibleDecimals
+	}
+
+	coin := openwallet.Coin{
+		Symbol:     bs.wm.Symbol(),
+		IsContract: true,
+		ContractID: contractId,
+		Contract: openwallet.SmartContract{
+			ContractID: contractId,
+			Address:    propertyID,
+			Protocol:   "omnicore",
+			Symbol:     bs.wm.Symbol(),
+			Decimals:   uint64(decimals),
+		},
+	}
+
+	if property, err := bs.wm.GetOmniPropertyInfo(transfer.PropertyID); err == nil {
+		coin.Contract.Name = property.Name
+	}
+
+	//按资产精度换算为最小单位
+	toSmallest := func(amount string) string {
+		amountDec, _ := decimal.NewFromString(amount)
+		return amountDec.Shift(decimals).StringFixed(0)
+	}
+
+	extractDataOf := func(sourceKey string) *openwallet.TxExtractData {
+		ed := extracts[sourceKey]
+		if ed == nil {
+			ed = openwallet.NewBlockExtractData()
+			extracts[sourceKey] = ed
 		}
+		return ed
+	}
 
-		blocktime := trx.BlockTime
-
-		for _, extractData := range result.extractOmniData {
-			tx := &openwallet.Transaction{
-				From:        []string{trx.SendingAddress + ":" + amount},
-				To:          []string{trx.ReferenceAddress + ":" + amount},
-				Fees:        "0",
-				Coin:        coin,
-				BlockHash:   trx.BlockHash,
-				BlockHeight: trx.Block,
-				TxID:        trx.TxID,
-				Decimal:     0,
-				ConfirmTime: blocktime,
-				Status:      status,
-				TxType:      0,
-			}
-			wxID := openwallet.GenTransactionWxID(tx)
-			tx.WxID = wxID
-			extractData.Transaction = tx
+	//没有减少余额的地址时，发送地址的数量为0
+	from := trx.SendingAddress
+	amount := "0"
+	if len(transfer.From) > 0 {
+		from = transfer.From
+		amount = toSmallest(transfer.Amount)
+	}
 
-			//bs.wm.Log.Debug("Transaction:", extractData.Transaction)
+	//sourceKey, ok := scanAddressFunc(trx.SendingAddress)
+	targetResult := openwallet.ScanTargetResult{}
+	if len(transfer.From) > 0 {
+		targetResult = scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     transfer.From,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+	}
+	if targetResult.Exist {
+		input := openwallet.TxInput{}
+		input.TxID = trx.TxID
+		input.Address = transfer.From
+		//transaction.AccountID = a.AccountID
+		input.Amount = amount
+		input.Coin = coin
+		input.Index = 0
+		input.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), contractId, 0)
+		//input.Sid = base64.StdEncoding.EncodeToString(crypto.SHA1([]byte(fmt.Sprintf("input_%s_%d_%s", result.TxID, i, addr))))
+		input.CreateAt = createAt
+		//在哪个区块高度时消费
+		input.BlockHeight = trx.Block
+		input.BlockHash = trx.BlockHash
+		input.TxType = txType
+
+		ed := extractDataOf(targetResult.SourceKey)
+		ed.TxInputs = append(ed.TxInputs, &input)
+
+		//发送后余额可能为0，下次使用时重新查询
+		if err := bs.wm.RemoveOmniHolder(transfer.From); err != nil {
+			bs.wm.Log.Warningf("remove omni holder: %s failed, %v", transfer.From, err)
 		}
+	}
 
-		success = true
+	txTo := make([]string, 0, len(transfer.Receivers))
+	for _, receiver := range transfer.Receivers {
+		receiveAmount := toSmallest(receiver.Amount)
+		txTo = append(txTo, receiver.Address+":"+receiveAmount)
+
+		//sourceKey2, ok2 := scanAddressFunc(trx.ReferenceAddress)
+		targetResult2 := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     receiver.Address,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+		if !targetResult2.Exist {
+			continue
+		}
 
+		output := openwallet.TxOutPut{}
+		output.TxID = trx.TxID
+		output.Address = receiver.Address
+		//transaction.AccountID = a.AccountID
+		output.Amount = receiveAmount
+		output.Coin = coin
+		output.Index = receiver.Output
+		output.Sid = openwallet.GenTxOutPutSID(trx.TxID, bs.wm.Symbol(), contractId, receiver.Output)
+		output.CreateAt = createAt
+		//在哪个区块高度时消费
+		output.BlockHeight = trx.Block
+		output.BlockHash = trx.BlockHash
+		output.TxType = txType
+
+		ed := extractDataOf(targetResult2.SourceKey)
+		ed.TxOutputs = append(ed.TxOutputs, &output)
+
+		//收到代币的地址记录为持币地址
+		if trx.Valid {
+			if err := bs.wm.SetOmniHolder(receiver.Address, true, 0); err != nil {
+				bs.wm.Log.Warningf("save omni holder: %s failed, %v", receiver.Address, err)
+			}
+		}
 	}
 
-	result.Success = success
+	//余额不变的相关地址只通知交易记录
+	for _, party := range transfer.Parties {
+		if len(party) == 0 {
+			continue
+		}
+		if party != from && len(transfer.Receivers) == 0 {
+			txTo = append(txTo, party+":0")
+		}
+		targetResult3 := scanAddressFunc(openwallet.ScanTargetParam{
+			ScanTarget:     party,
+			Symbol:         bs.wm.Symbol(),
+			ScanTargetType: openwallet.ScanTargetTypeAccountAddress})
+		if targetResult3.Exist {
+			extractDataOf(targetResult3.SourceKey)
+		}
+	}
+
+	for sourceKey, extractData := range extracts {
+		tx := &openwallet.Transaction{
+			From:        []string{from + ":" + amount},
+			To:          txTo,
+			Fees:        "0",
+			Coin:        coin,
+			BlockHash:   trx.BlockHash,
+			BlockHeight: trx.Block,
+			TxID:        trx.TxID,
+			Decimal:     0,
+			ConfirmTime: trx.BlockTime,
+			Status:      status,
+			TxType:      txType,
+			TxAction:    trx.TypeStr,
+		}
+		wxID := openwallet.GenTransactionWxID(tx)
+		tx.WxID = wxID
+		extractData.Transaction = tx
 
+		result.extractOmniData[sourceKey] = append(result.extractOmniData[sourceKey], extractData)
+	}
 }
 
 //ExtractTransactionData 提取交易单
@@ -792,7 +1053,7 @@ func (bs *BTCBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 				intxid := input.TxID
 				vout := input.Vout
 
-				preTx, err := bs.wm.GetTransaction(intxid)
+				preTx, err := bs.getScanTransaction(intxid)
 				if err != nil {
 					success = false
 					break
@@ -1018,13 +1279,20 @@ func (bs *BTCBlockScanner) extractTxOutput(trx *Transaction, result *ExtractResu
 //newExtractDataNotify 发送通知
 func (bs *BTCBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
 
+	var notifyErr error
+
 	for o, _ := range bs.Observers {
 		for key, data := range extractData {
 			err := o.BlockExtractDataNotify(key, data)
 			if err != nil {
 				bs.wm.Log.Error("BlockExtractDataNotify unexpected error:", err)
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "ExtractData Notify failed.", bs.wm.Symbol())
+				notifyErr = err
+				txid := ""
+				if data.Transaction != nil {
+					txid = data.Transaction.TxID
+				}
+				//记录未扫交易
+				unscanRecord := openwallet.NewUnscanRecord(height, txid, "ExtractData Notify failed: "+err.Error(), bs.wm.Symbol())
 				err = bs.SaveUnscanRecord(unscanRecord)
 				if err != nil {
 					bs.wm.Log.Std.Error("block height: %d, save unscan record failed. unexpected error: %v", height, err.Error())
@@ -1034,7 +1302,24 @@ func (bs *BTCBlockScanner) newExtractDataNotify(height uint64, extractData map[s
 		}
 	}
 
-	return nil
+	return notifyErr
+}
+
+//newOmniExtractDataNotify 发送代币交易通知，每个资产单独通知
+func (bs *BTCBlockScanner) newOmniExtractDataNotify(height uint64, extractData map[string][]*openwallet.TxExtractData) error {
+
+	var notifyErr error
+
+	for key, list := range extractData {
+		for _, data := range list {
+			err := bs.newExtractDataNotify(height, map[string]*openwallet.TxExtractData{key: data})
+			if err != nil {
+				notifyErr = err
+			}
+		}
+	}
+
+	return notifyErr
 }
 
 //DeleteUnscanRecordNotFindTX 删除未没有找到交易记录的重扫记录
@@ -1043,18 +1328,17 @@ func (bs *BTCBlockScanner) DeleteUnscanRecordNotFindTX() error {
 	//删除找不到交易单
 	reason := "[-5]No information available about transaction"
 
-	if bs.BlockchainDAI == nil {
-		return fmt.Errorf("Blockchain DAI is not setup ")
-	}
-
-	list, err := bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	list, err := bs.GetUnscanRecords()
 	if err != nil {
 		return err
 	}
 
 	for _, r := range list {
 		if strings.HasPrefix(r.Reason, reason) {
-			bs.BlockchainDAI.DeleteUnscanRecordByID(r.ID, bs.wm.Symbol())
+			err = bs.deleteUnscanRecordByID(r.ID)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -1112,7 +1396,7 @@ func (bs *BTCBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, err
 
 	//如果本地没有记录，查询接口的高度
 	if blockHeight == 0 {
-		blockHeight, err = bs.wm.GetBlockHeight()
+		blockHeight, err = bs.getScanBlockHeight()
 		if err != nil {
 
 			return nil, err
@@ -1121,7 +1405,7 @@ func (bs *BTCBlockScanner) GetScannedBlockHeader() (*openwallet.BlockHeader, err
 		//就上一个区块链为当前区块
 		blockHeight = blockHeight - 1
 
-		hash, err = bs.wm.GetBlockHash(blockHeight)
+		hash, err = bs.getScanBlockHash(blockHeight)
 		if err != nil {
 			return nil, err
 		}
@@ -1195,6 +1479,9 @@ func (bs *BTCBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		txs = append(txs, data)
 		extData[key] = txs
 	}
+	for key, list := range result.extractOmniData {
+		extData[key] = append(extData[key], list...)
+	}
 	return extData, nil
 }
 
@@ -1257,6 +1544,9 @@ func (bs *BTCBlockScanner) SaveUnscanRecord(record *openwallet.UnscanRecord) err
 		return fmt.Errorf("Blockchain DAI is not setup ")
 	}
 
+	//同一记录再次失败，需要重新处理
+	bs.unresolveUnscanRecord(record.ID)
+
 	return bs.BlockchainDAI.SaveUnscanRecord(record)
 }
 
@@ -1398,6 +1688,7 @@ func (wm *WalletManager) GetBlock(hash string) (*Block, error) {
 	if wm.Config.RPCServerType == RPCServerExplorer {
 		return wm.getBlockByExplorer(hash)
 	} else {
+		//合并挖矿证明只在验证区块头时解析，见GetVerifiedBlockHeader
 		return wm.getBlockByCore(hash)
 	}
 }
@@ -1547,7 +1838,20 @@ func (bs *BTCBlockScanner) GetUnscanRecords() ([]*openwallet.UnscanRecord, error
 		return nil, fmt.Errorf("Blockchain DAI is not setup ")
 	}
 
-	return bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	list, err := bs.BlockchainDAI.GetUnscanRecords(bs.wm.Symbol())
+	if err != nil {
+		return nil, err
+	}
+
+	//过滤本地记录为已处理的未扫记录
+	records := make([]*openwallet.UnscanRecord, 0, len(list))
+	for _, r := range list {
+		if !bs.isResolvedUnscanRecord(r.ID) {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
 }
 
 //DeleteUnscanRecord 删除指定高度的未扫记录
@@ -1586,7 +1890,7 @@ func (bs *BTCBlockScanner) GetBalanceByAddress(address ...string) ([]*openwallet
 //getBalanceByExplorer 获取地址余额
 func (wm *WalletManager) getBalanceCalUnspent(address ...string) ([]*openwallet.Balance, error) {
 
-	utxos, err := wm.ListUnspent(0, address...)
+	utxos, err := wm.listUnspent(0, address...)
 	if err != nil {
 		return nil, err
 	}
@@ -1704,10 +2008,21 @@ func (bs *BTCBlockScanner) GetTransactionsByAddress(offset, limit int, coin open
 //Run 运行
 func (bs *BTCBlockScanner) Run() error {
 
-	//使用浏览器，开启socketIO监听内存池交易
-	if bs.wm.Config.RPCServerType == RPCServerExplorer {
-		if bs.socketIO == nil {
-			go bs.setupSocketIO()
+	if bs.subscriptions == nil {
+		bs.subscriptions = NewSubscriptionManager(bs.wm.Log)
+	}
+
+	if !bs.subscriptions.Running() {
+		if bs.wm.Config.RPCServerType == RPCServerExplorer {
+			//使用浏览器，开启socketIO监听新区块和内存池交易
+			bs.wm.Log.Info("block scanner use socketIO to listen new data")
+			bs.subscriptions.Go(bs.scanBlockTaskRuntime)
+			bs.subscriptions.Subscribe("socketIO", bs.subscribeSocketIO)
+		} else if len(bs.wm.Config.ZMQAPI) > 0 {
+			//使用核心钱包，开启zmq监听新区块和内存池交易
+			bs.wm.Log.Info("block scanner use zmq to listen new data")
+			bs.subscriptions.Go(bs.scanBlockTaskRuntime)
+			bs.subscriptions.Subscribe("zmq", bs.subscribeZMQ)
 		}
 	}
 
@@ -1719,13 +2034,13 @@ func (bs *BTCBlockScanner) Run() error {
 ////Stop 停止扫描
 func (bs *BTCBlockScanner) Stop() error {
 
-	if bs.socketIO != nil {
-		bs.socketIO.Close()
-		bs.socketIO = nil
+	//取消订阅，并等待订阅线程退出
+	if bs.subscriptions != nil {
+		bs.subscriptions.Stop()
 	}
 
-	//通知停止线程
-	bs.stopSocketIO <- struct{}{}
+	//取消运行中的地址重扫任务
+	bs.cancelAddressRescanJobs()
 
 	bs.BlockScannerBase.Stop()
 	return nil
@@ -1754,15 +2069,17 @@ func (bs *BTCBlockScanner) Stop() error {
 
 /******************* 使用insight socket.io 监听区块 *******************/
 
-func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocketio.Client, error) {
+//subscribeSocketIO 连接socketIO，订阅新区块和内存池交易，阻塞直到断线或停止
+func (bs *BTCBlockScanner) subscribeSocketIO(ctx context.Context, connected func()) error {
 
 	var (
-		room = "inv"
+		room         = "inv"
+		disconnected = make(chan struct{}, 1)
 	)
 
 	apiUrl, err := url.Parse(bs.wm.Config.ServerAPI)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	domain := apiUrl.Hostname()
 	port := common.NewString(apiUrl.Port()).Int()
@@ -1771,14 +2088,21 @@ func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocke
 		gosocketio.GetUrl(domain, port, false),
 		transport.GetDefaultWebsocketTransport())
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer socketIO.Close()
 
 	err = socketIO.On("tx", func(h *gosocketio.Channel, args interface{}) {
 		//bs.wm.Log.Info("block scanner socketIO get new transaction received: ", args)
+		if !bs.IsScanMemPool {
+			return
+		}
 		txMap, ok := args.(map[string]interface{})
 		if ok {
-			txid := txMap["txid"].(string)
+			txid, _ := txMap["txid"].(string)
+			if len(txid) == 0 {
+				return
+			}
 			//bs.wm.Log.Debugf("new tx: %s", txid)
 			errInner := bs.BatchExtractTransaction(0, "", []string{txid})
 			if errInner != nil {
@@ -1788,106 +2112,54 @@ func (bs *BTCBlockScanner) connectSocketIO(disconnected chan struct{}) (*gosocke
 
 	})
 	if err != nil {
-		socketIO.Close()
-		return nil, err
+		return err
 	}
 
-	/*
-		err = bs.socketIO.On("block", func(h *gosocketio.Channel, args interface{}) {
-			bs.wm.Log.Info("block scanner socketIO get new block received: ", args)
-			hash, ok := args.(string)
-			if ok {
-
-				block, errInner := bs.wm.GetBlock(hash)
-				if errInner != nil {
-					bs.wm.Log.Std.Info("block scanner can not get new block data; unexpected error: %v", errInner)
-				}
-
-				errInner = bs.scanBlock(block)
-				if errInner != nil {
-					bs.wm.Log.Std.Info("block scanner can not block: %d; unexpected error: %v", block.Height, errInner)
-				}
-			}
-
-		})
-		if err != nil {
-			return err
-		}
-	*/
+	err = socketIO.On("block", func(h *gosocketio.Channel, args interface{}) {
+		bs.wm.Log.Debugf("block scanner socketIO new block: %v", args)
+		//新区块由扫描任务按高度顺序处理，保证分叉检查和扫描高度正确
+		bs.triggerScanBlockTask()
+	})
+	if err != nil {
+		return err
+	}
 
 	err = socketIO.On(gosocketio.OnDisconnection, func(h *gosocketio.Channel) {
 		bs.wm.Log.Info("block scanner socketIO disconnected")
-		disconnected <- struct{}{}
+		select {
+		case disconnected <- struct{}{}:
+		default:
+		}
 	})
 	if err != nil {
-		socketIO.Close()
-		return nil, err
+		return err
 	}
 
 	err = socketIO.On(gosocketio.OnConnection, func(h *gosocketio.Channel) {
-		bs.wm.Log.Info("block scanner socketIO connected")
 		h.Emit("subscribe", room)
 	})
 	if err != nil {
-		socketIO.Close()
-		return nil, err
+		return err
 	}
-	return socketIO, nil
-}
-
-//setupSocketIO 配置socketIO监听新区块
-func (bs *BTCBlockScanner) setupSocketIO() error {
 
-	bs.wm.Log.Info("block scanner use socketIO to listen new data")
-
-	var (
-		err error
-		//连接状态通道
-		reconnect = make(chan bool, 1)
-		//断开状态通道
-		disconnected = make(chan struct{}, 1)
-		//重连时的等待时间
-		reconnectWait = 5
-		socketIO      *gosocketio.Client
-	)
-
-	defer func() {
-		close(reconnect)
-		close(disconnected)
-	}()
-
-	//启动连接
-	reconnect <- true
-
-	//重连运行时
-	for {
-		select {
-		case <-reconnect:
-			//重新连接
-			socketIO, err = bs.connectSocketIO(disconnected)
-			bs.socketIO = socketIO
-			if err != nil {
-				bs.wm.Log.Errorf("Connect socketIO failed unexpected error: %v", err)
-				disconnected <- struct{}{}
-			}
+	//连接在注册事件前已建立，需要主动订阅一次
+	err = socketIO.Emit("subscribe", room)
+	if err != nil {
+		return err
+	}
 
-		case <-disconnected:
+	bs.wm.Log.Info("block scanner socketIO connected")
+	connected()
 
-			if bs.socketIO != nil {
-				bs.socketIO.Close()
-			}
+	//连接后马上补扫一次，避免断线期间错过区块
+	bs.triggerScanBlockTask()
 
-			//重新连接，前等待
-			bs.wm.Log.Info("Auto reconnect after", reconnectWait, "seconds...")
-			time.Sleep(time.Duration(reconnectWait) * time.Second)
-			reconnect <- true
-		case <-bs.stopSocketIO:
-			bs.wm.Log.Info("block scanner socketIO has been stopped")
-			return nil
-		}
+	select {
+	case <-disconnected:
+		return errors.New("socketIO connection closed")
+	case <-ctx.Done():
+		return nil
 	}
-
-	return nil
 }
 
 //SupportBlockchainDAI 支持外部设置区块链数据访问接口
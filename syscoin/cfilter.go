@@ -0,0 +1,245 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+//BIP158 basic过滤器参数
+const (
+	basicFilterP = 19
+	basicFilterM = 784931
+)
+
+//BlockFilter BIP158 basic类型的紧凑区块过滤器
+type BlockFilter struct {
+	N    uint32
+	P    uint8
+	M    uint64
+	k0   uint64
+	k1   uint64
+	data []byte
+}
+
+//NewBasicBlockFilter 解析区块的basic过滤器，blockHash为显示格式的区块hash
+func NewBasicBlockFilter(blockHash string, filter []byte) (*BlockFilter, error) {
+
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil || len(hash) != 32 {
+		return nil, fmt.Errorf("block hash: %s is invalid", blockHash)
+	}
+
+	//过滤器的key是区块hash（内部字节序）的前16字节
+	key := make([]byte, 16)
+	for i := 0; i < 16; i++ {
+		key[i] = hash[31-i]
+	}
+
+	n, size, err := readCompactSize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > 0xffffffff {
+		return nil, fmt.Errorf("block filter size: %d is too large", n)
+	}
+
+	return &BlockFilter{
+		N:    uint32(n),
+		P:    basicFilterP,
+		M:    basicFilterM,
+		k0:   binary.LittleEndian.Uint64(key[0:8]),
+		k1:   binary.LittleEndian.Uint64(key[8:16]),
+		data: filter[size:],
+	}, nil
+}
+
+//MatchAny 过滤器是否包含任意一个数据项
+func (f *BlockFilter) MatchAny(items [][]byte) (bool, error) {
+
+	if f.N == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	F := uint64(f.N) * f.M
+
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		values = append(values, hashToRange(sipHash24(f.k0, f.k1, item), F))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	reader := &bitReader{data: f.data}
+
+	var (
+		value uint64
+		i     = 0
+	)
+
+	for n := uint32(0); n < f.N; n++ {
+		delta, err := reader.readGolombRice(f.P)
+		if err != nil {
+			return false, err
+		}
+		value += delta
+
+		for i < len(values) && values[i] < value {
+			i++
+		}
+		if i == len(values) {
+			return false, nil
+		}
+		if values[i] == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//hashToRange 把64位hash均匀映射到[0, F)
+func hashToRange(h, F uint64) uint64 {
+	hi, _ := bits.Mul64(h, F)
+	return hi
+}
+
+//bitReader 按位读取Golomb-Rice编码数据
+type bitReader struct {
+	data []byte
+	pos  uint64
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	index := r.pos / 8
+	if index >= uint64(len(r.data)) {
+		return 0, errors.New("block filter data is truncated")
+	}
+	bit := (r.data[index] >> (7 - r.pos%8)) & 1
+	r.pos++
+	return uint64(bit), nil
+}
+
+func (r *bitReader) readGolombRice(p uint8) (uint64, error) {
+
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+	}
+
+	var rem uint64
+	for i := uint8(0); i < p; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		rem = rem<<1 | bit
+	}
+
+	return q<<p | rem, nil
+}
+
+//readCompactSize 读取比特币的变长整数
+func readCompactSize(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errors.New("compact size is empty")
+	}
+	switch b[0] {
+	case 0xfd:
+		if len(b) < 3 {
+			return 0, 0, errors.New("compact size is truncated")
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:3])), 3, nil
+	case 0xfe:
+		if len(b) < 5 {
+			return 0, 0, errors.New("compact size is truncated")
+		}
+		return uint64(binary.LittleEndian.Uint32(b[1:5])), 5, nil
+	case 0xff:
+		if len(b) < 9 {
+			return 0, 0, errors.New("compact size is truncated")
+		}
+		return binary.LittleEndian.Uint64(b[1:9]), 9, nil
+	default:
+		return uint64(b[0]), 1, nil
+	}
+}
+
+//sipHash24 SipHash-2-4
+func sipHash24(k0, k1 uint64, msg []byte) uint64 {
+
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(msg)
+	for len(msg) >= 8 {
+		m := binary.LittleEndian.Uint64(msg)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		msg = msg[8:]
+	}
+
+	last := uint64(length) << 56
+	for i := len(msg) - 1; i >= 0; i-- {
+		last |= uint64(msg[i]) << (8 * uint(i))
+	}
+
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
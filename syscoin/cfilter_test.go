@@ -0,0 +1,83 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+//BIP158测试向量，testnet创世区块
+func TestBlockFilter_MatchAny(t *testing.T) {
+
+	filter, _ := hex.DecodeString("019dfca8")
+	coinbaseScript, _ := hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	otherScript, _ := hex.DecodeString("76a9140dfc8bafc8419853b34d5e072ad37d1a5159f58488ac")
+
+	f, err := NewBasicBlockFilter("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943", filter)
+	if err != nil {
+		t.Fatalf("NewBasicBlockFilter failed unexpected error: %v", err)
+	}
+
+	if f.N != 1 {
+		t.Errorf("unexpected filter size: %d", f.N)
+	}
+
+	match, err := f.MatchAny([][]byte{otherScript, coinbaseScript})
+	if err != nil || !match {
+		t.Errorf("coinbase script should be matched, err: %v", err)
+	}
+
+	match, err = f.MatchAny([][]byte{otherScript})
+	if err != nil || match {
+		t.Errorf("other script should not be matched, err: %v", err)
+	}
+}
+
+func TestSipHash24(t *testing.T) {
+	//SipHash论文附录的测试向量
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if h := sipHash24(0x0706050403020100, 0x0f0e0d0c0b0a0908, msg); h != 0xa129ca6149be45e5 {
+		t.Errorf("unexpected siphash: %x", h)
+	}
+}
+
+func TestWalletManager_AddressScriptPubKey(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{}}
+
+	tests := map[string]string{
+		"sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc": "00140dfc8bafc8419853b34d5e072ad37d1a5159f584",
+		"SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB":          "76a9140dfc8bafc8419853b34d5e072ad37d1a5159f58488ac",
+	}
+
+	for address, want := range tests {
+		script, err := wm.addressScriptPubKey(address)
+		if err != nil {
+			t.Errorf("address: %s unexpected error: %v", address, err)
+			continue
+		}
+		if hex.EncodeToString(script) != want {
+			t.Errorf("address: %s script: %x, want: %s", address, script, want)
+		}
+	}
+
+	if _, err := wm.addressScriptPubKey("invalid"); err == nil {
+		t.Errorf("invalid address should fail")
+	}
+}
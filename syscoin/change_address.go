@@ -0,0 +1,241 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/v2/openw"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//找零地址策略
+const (
+	ChangeAddressPolicyReuse  = "reuse"  //找零到第一个输入的地址
+	ChangeAddressPolicyFixed  = "fixed"  //找零到固定的地址
+	ChangeAddressPolicyDerive = "derive" //从BIP44找零链派生新地址
+)
+
+//changeIndexMu 派生找零地址时保护索引不被重复使用
+var changeIndexMu sync.Mutex
+
+//ChangeAddressPolicy 账户的找零地址策略，未设置的账户使用配置的默认策略
+type ChangeAddressPolicy struct {
+	AccountID string `storm:"id"`
+	Policy    string
+	Address   string //固定找零地址
+	NextIndex uint64 //找零链下一个派生的索引
+}
+
+//isValidChangeAddressPolicy 是否支持的策略
+func isValidChangeAddressPolicy(policy string) bool {
+	switch policy {
+	case ChangeAddressPolicyReuse, ChangeAddressPolicyFixed, ChangeAddressPolicyDerive:
+		return true
+	}
+	return false
+}
+
+//SetChangeAddressPolicy 设置账户的找零地址策略，fixed策略需要提供找零地址
+func (wm *WalletManager) SetChangeAddressPolicy(accountID, policy, address string) error {
+
+	if !isValidChangeAddressPolicy(policy) {
+		return fmt.Errorf("change address policy: %s is not supported", policy)
+	}
+
+	if policy == ChangeAddressPolicyFixed {
+		if _, err := wm.addressScriptPubKey(address); err != nil {
+			return err
+		}
+	}
+
+	changeIndexMu.Lock()
+	defer changeIndexMu.Unlock()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	p := &ChangeAddressPolicy{}
+	err = db.One("AccountID", accountID, p)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	p.AccountID = accountID
+	p.Policy = policy
+	p.Address = address
+
+	return db.Save(p)
+}
+
+//GetChangeAddressPolicy 获取账户的找零地址策略
+func (wm *WalletManager) GetChangeAddressPolicy(accountID string) (*ChangeAddressPolicy, error) {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ChangeAddressPolicy{}
+	err = db.One("AccountID", accountID, p)
+	if err == nil {
+		return p, nil
+	}
+	if err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	policy := wm.Config.ChangeAddressPolicy
+	if len(policy) == 0 {
+		policy = ChangeAddressPolicyReuse
+	}
+
+	return &ChangeAddressPolicy{
+		AccountID: accountID,
+		Policy:    policy,
+		Address:   wm.Config.ChangeAddress,
+	}, nil
+}
+
+//selectChangeAddress 根据账户的策略选择找零地址，派生的新地址在交易单构建成功后才登记，见commitChangeAddress
+func (decoder *TransactionDecoder) selectChangeAddress(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, usedUTXO []*Unspent) (*openwallet.Address, error) {
+
+	wm := decoder.wm
+
+	policy, err := wm.GetChangeAddressPolicy(account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch policy.Policy {
+	case ChangeAddressPolicyFixed:
+		if len(policy.Address) == 0 {
+			return nil, fmt.Errorf("account: %s fixed change address is empty", account.AccountID)
+		}
+		return &openwallet.Address{AccountID: account.AccountID, Address: policy.Address, Symbol: wm.Symbol(), IsChange: true}, nil
+	case ChangeAddressPolicyDerive:
+		return decoder.deriveChangeAddress(wrapper, account, policy)
+	case ChangeAddressPolicyReuse:
+		return &openwallet.Address{AccountID: account.AccountID, Address: usedUTXO[0].Address, Symbol: wm.Symbol()}, nil
+	default:
+		return nil, fmt.Errorf("change address policy: %s is not supported", policy.Policy)
+	}
+}
+
+//deriveChangeAddress 从账户公钥派生找零链(m/.../1/index)的下一个地址，跳过账户已登记的找零地址，
+//只派生候选地址，不保存地址和索引，构建失败或没有找零输出时不占用索引
+func (decoder *TransactionDecoder) deriveChangeAddress(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, policy *ChangeAddressPolicy) (*openwallet.Address, error) {
+
+	wm := decoder.wm
+
+	changeIndexMu.Lock()
+	defer changeIndexMu.Unlock()
+
+	index := policy.NextIndex
+
+	//账户已登记的找零地址（例如地址发现保存的）不再派生
+	registered, err := wrapper.GetAddressList(0, -1, "AccountID", account.AccountID, "IsChange", true)
+	if err == nil {
+		for _, a := range registered {
+			if a.Index+1 > index {
+				index = a.Index + 1
+			}
+		}
+	}
+
+	derivable := *account
+	if len(derivable.OwnerKeys) == 0 {
+		derivable.OwnerKeys = []string{derivable.PublicKey}
+	}
+
+	result := openwallet.CreateAddressByAccountWithIndex(&derivable, wm, int(index), addressChainChange)
+	if !result.Success {
+		return nil, result.Err
+	}
+
+	return result.Address, nil
+}
+
+//commitChangeAddress 交易单构建成功且包含找零输出后，登记派生的找零地址并推进找零索引，
+//登记到钱包数据库后扫块才能识别找零输出
+func (decoder *TransactionDecoder) commitChangeAddress(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	wm := decoder.wm
+	change := rawTx.Change
+
+	//只有派生的找零地址有HD路径
+	if change == nil || !change.IsChange || len(change.HDPath) == 0 {
+		return nil
+	}
+
+	changeIndexMu.Lock()
+	defer changeIndexMu.Unlock()
+
+	policy, err := wm.GetChangeAddressPolicy(rawTx.Account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if policy.Policy != ChangeAddressPolicyDerive {
+		return nil
+	}
+
+	err = saveChangeAddress(wrapper, change)
+	if err != nil {
+		return err
+	}
+
+	if change.Index+1 > policy.NextIndex {
+		policy.NextIndex = change.Index + 1
+	}
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Save(policy)
+}
+
+//stormWalletDAI 可打开钱包数据库的钱包数据接口，例如openw.WalletWrapper
+type stormWalletDAI interface {
+	OpenStormDB() (*openw.StormDB, error)
+	CloseDB()
+}
+
+//saveChangeAddress 保存派生的找零地址到钱包数据库，与openw创建地址的保存方式一致
+func saveChangeAddress(wrapper openwallet.WalletDAI, address *openwallet.Address) error {
+
+	w, ok := wrapper.(stormWalletDAI)
+	if !ok {
+		return fmt.Errorf("wallet DAI can not save change address: %s", address.Address)
+	}
+
+	db, err := w.OpenStormDB()
+	if err != nil {
+		return err
+	}
+	defer w.CloseDB()
+
+	address.CreatedTime = time.Now().Unix()
+
+	return db.Save(address)
+}
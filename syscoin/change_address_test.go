@@ -0,0 +1,158 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openw"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestChangeAddressPolicy(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	p, err := wm.GetChangeAddressPolicy("account1")
+	if err != nil {
+		t.Fatalf("GetChangeAddressPolicy failed unexpected error: %v", err)
+	}
+	if p.Policy != ChangeAddressPolicyReuse {
+		t.Errorf("default policy: %s, want %s", p.Policy, ChangeAddressPolicyReuse)
+	}
+
+	if err = wm.SetChangeAddressPolicy("account1", ChangeAddressPolicyFixed, "invalid"); err == nil {
+		t.Errorf("fixed policy with invalid address should fail")
+	}
+
+	if err = wm.SetChangeAddressPolicy("account1", "other", ""); err == nil {
+		t.Errorf("unsupported policy should fail")
+	}
+
+	address := "sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc"
+	err = wm.SetChangeAddressPolicy("account1", ChangeAddressPolicyFixed, address)
+	if err != nil {
+		t.Fatalf("SetChangeAddressPolicy failed unexpected error: %v", err)
+	}
+
+	decoder := NewTransactionDecoder(wm)
+	change, err := decoder.selectChangeAddress(nil, &openwallet.AssetsAccount{AccountID: "account1"}, nil)
+	if err != nil {
+		t.Fatalf("selectChangeAddress failed unexpected error: %v", err)
+	}
+	if change.Address != address || !change.IsChange {
+		t.Errorf("unexpected change address: %+v", change)
+	}
+
+	//其它账户仍使用默认策略
+	utxos := []*Unspent{{Address: "SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB"}}
+	change, err = decoder.selectChangeAddress(nil, &openwallet.AssetsAccount{AccountID: "account2"}, utxos)
+	if err != nil {
+		t.Fatalf("selectChangeAddress failed unexpected error: %v", err)
+	}
+	if change.Address != utxos[0].Address {
+		t.Errorf("unexpected change address: %s", change.Address)
+	}
+}
+
+func TestDeriveChangeAddress_SaveToWallet(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "change")
+	if err != nil {
+		t.Fatalf("create temp dir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.RPCServerType = RPCServerExplorer //不导入地址到核心钱包
+	defer wm.CloseLocalDB()
+
+	seed := bytes.Repeat([]byte{0x01}, 32)
+	key, err := hdkeystore.NewHDKey(seed, "test", "m/44'/88'")
+	if err != nil {
+		t.Fatalf("NewHDKey failed unexpected error: %v", err)
+	}
+	accountKey, err := key.DerivedKeyWithPath("m/44'/88'/0'", CurveType)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath failed unexpected error: %v", err)
+	}
+
+	account := &openwallet.AssetsAccount{
+		AccountID: "account1",
+		Symbol:    Symbol,
+		HDPath:    "m/44'/88'/0'",
+		PublicKey: accountKey.GetPublicKey().OWEncode(),
+	}
+
+	err = wm.SetChangeAddressPolicy(account.AccountID, ChangeAddressPolicyDerive, "")
+	if err != nil {
+		t.Fatalf("SetChangeAddressPolicy failed unexpected error: %v", err)
+	}
+
+	wrapper := openw.NewWalletWrapper(openw.WrapperSourceFile(filepath.Join(dir, "wallet.db")))
+	defer wrapper.CloseDB()
+
+	decoder := NewTransactionDecoder(wm)
+	change, err := decoder.selectChangeAddress(wrapper, account, nil)
+	if err != nil {
+		t.Fatalf("selectChangeAddress failed unexpected error: %v", err)
+	}
+	if !change.IsChange || change.Index != 0 {
+		t.Errorf("unexpected change address: %+v", change)
+	}
+
+	//构建成功前不登记，再次派生得到相同的候选地址
+	if _, err = wrapper.GetAddress(change.Address); err == nil {
+		t.Errorf("change address should not be saved before build succeeded")
+	}
+	again, err := decoder.selectChangeAddress(wrapper, account, nil)
+	if err != nil || again.Address != change.Address {
+		t.Fatalf("uncommitted change index should be reused: %+v, err: %v", again, err)
+	}
+
+	rawTx := &openwallet.RawTransaction{Account: account, Change: change}
+	if err = decoder.commitChangeAddress(wrapper, rawTx); err != nil {
+		t.Fatalf("commitChangeAddress failed unexpected error: %v", err)
+	}
+
+	//派生的找零地址通过钱包数据接口可以查到
+	saved, err := wrapper.GetAddress(change.Address)
+	if err != nil {
+		t.Fatalf("change address is not saved to wallet: %v", err)
+	}
+	if saved.AccountID != account.AccountID || !saved.IsChange || saved.HDPath != "m/44'/88'/0'/1/0" {
+		t.Errorf("unexpected saved address: %+v", saved)
+	}
+
+	//已登记的找零地址不再派生
+	next, err := decoder.selectChangeAddress(wrapper, account, nil)
+	if err != nil {
+		t.Fatalf("selectChangeAddress failed unexpected error: %v", err)
+	}
+	if next.Address == change.Address || next.Index != 1 {
+		t.Errorf("unexpected next change address: %+v", next)
+	}
+	policy, _ := wm.GetChangeAddressPolicy(account.AccountID)
+	if policy.NextIndex != 1 {
+		t.Errorf("next change index: %d, want: 1", policy.NextIndex)
+	}
+}
@@ -115,6 +115,70 @@ type WalletConfig struct {
 	MinFees decimal.Decimal
 	//数据目录
 	DataDir string
+	//核心钱包zmq推送地址，例如：tcp://127.0.0.1:28332
+	ZMQAPI string
+	//节点P2P地址，例如：127.0.0.1:8369
+	P2PAPI string
+	//P2P区块头同步的检查点，格式为height:hash，同步区块头必须设置
+	P2PCheckpoint string
+	//P2P网络：mainnet，testnet，regtest，为空则按IsTestNet选择
+	P2PNetwork string
+	//P2P网络魔数(十六进制，按消息头字节顺序)，为空则使用网络参数的默认值，regtest必须设置
+	P2PMagic string
+	//P2P默认端口，为空则使用网络参数的默认值，regtest必须设置
+	P2PPort string
+	//是否使用P2P节点作为扫块的区块来源
+	P2PBlockSource bool
+	//是否验证充值交易的默克尔证明
+	VerifyMerkleProof bool
+	//一致性检查使用的另一个数据源：使用浏览器时为核心钱包RPC地址，否则为浏览器API地址
+	AuditServerAPI string
+	//默认的找零地址策略：reuse，fixed，derive
+	ChangeAddressPolicy string
+	//fixed策略的默认找零地址
+	ChangeAddress string
+	//粉尘中继费率(每KB)，找零低于粉尘阈值时并入手续费
+	DustRelayFee decimal.Decimal
+	//账户可用utxo数量达到该值时合并，0则不启动合并任务
+	ConsolidateMinUTXOs int
+	//合并任务允许的最高费率(每KB)
+	ConsolidateMaxFeeRate decimal.Decimal
+	//合并任务执行间隔时间
+	ConsolidateCycleSeconds time.Duration
+	//交易单输入输出的排序方式：bip69，caller
+	OutputOrder string
+	//构建交易单时预留utxo的有效期
+	UTXOLeaseExpiry time.Duration
+	//第三方utxo的最低确认数
+	MinForeignConfirms uint64
+	//是否允许使用自己广播交易的未确认找零
+	AllowUnconfirmedChange bool
+	//未确认输入的最大祖先深度
+	MaxUnconfirmedDepth int
+	//广播交易单跟踪的检查间隔时间
+	OutboundTrackSeconds time.Duration
+	//广播交易单达到该确认数后结束跟踪
+	OutboundConfirmations uint64
+	//结束跟踪的广播交易单保留时间，过期后删除
+	OutboundRetention time.Duration
+	//广播前检查的最低中继费率(每KB)
+	MinRelayFeeRate decimal.Decimal
+	//广播前检查的最高费率(每KB)，0则不限制
+	MaxFeeRate decimal.Decimal
+	//广播前检查的最高手续费，0则不限制
+	MaxFee decimal.Decimal
+	//手续费占发送金额的最高比例，0则不限制
+	MaxFeeRatio decimal.Decimal
+	//默认的手续费优先级：fast，normal，economy
+	FeePriority string
+	//各优先级期望确认的区块数
+	FastFeeTarget    int
+	NormalFeeTarget  int
+	EconomyFeeTarget int
+	//补充给持币地址的手续费utxo金额，默认为Omni转账最低成本
+	OmniFuelAmount decimal.Decimal
+	//补充持币地址手续费utxo的间隔时间
+	OmniFuelCycleSeconds time.Duration
 }
 
 func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
@@ -179,6 +243,33 @@ func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
 	c.Decimals = decimals
 	//最低手续费
 	c.MinFees = decimal.Zero
+	//粉尘中继费率
+	c.DustRelayFee = defaultDustRelayFee
+	//合并任务执行间隔时间
+	c.ConsolidateCycleSeconds = defaultConsolidateInterval
+	//输入输出排序方式
+	c.OutputOrder = OutputOrderBIP69
+	//预留utxo的有效期
+	c.UTXOLeaseExpiry = defaultUTXOLeaseExpiry
+	//未确认输入策略
+	c.MinForeignConfirms = defaultMinForeignConfirms
+	c.AllowUnconfirmedChange = true
+	c.MaxUnconfirmedDepth = defaultMaxUnconfirmedDepth
+	//广播交易单跟踪
+	c.OutboundTrackSeconds = defaultOutboundTrackInterval
+	c.OutboundConfirmations = defaultOutboundConfirmations
+	c.OutboundRetention = defaultOutboundRetention
+	//广播前检查的费率和手续费范围
+	c.MinRelayFeeRate = defaultMinRelayFeeRate
+	c.MaxFeeRate = defaultMaxFeeRate
+	c.MaxFee = defaultMaxFee
+	//默认使用fast，与原来预估2个区块确认一致
+	c.FeePriority = FeePriorityFast
+	c.FastFeeTarget = defaultFastFeeTarget
+	c.NormalFeeTarget = defaultNormalFeeTarget
+	c.EconomyFeeTarget = defaultEconomyFeeTarget
+	//补充持币地址手续费utxo的间隔时间
+	c.OmniFuelCycleSeconds = defaultOmniFuelInterval
 	c.MainNetAddressPrefix = SYSMainnetAddressPrefix
 	c.TestNetAddressPrefix = SYSTestnetAddressPrefix
 
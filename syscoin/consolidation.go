@@ -0,0 +1,286 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultConsolidateMinUTXOs = 50
+	defaultConsolidateInterval = 1 * time.Hour
+)
+
+//ConsolidationObserver 合并交易单的观察者，由应用签名并广播
+type ConsolidationObserver interface {
+	ConsolidationTxNotify(rawTx *openwallet.RawTransaction) error
+}
+
+type consolidationAccount struct {
+	wrapper openwallet.WalletDAI
+	account *openwallet.AssetsAccount
+}
+
+//ConsolidationScheduler 碎片utxo合并任务，在低费率时把账户的小额utxo合并到找零地址
+type ConsolidationScheduler struct {
+	MinUTXOs   int             //账户可用utxo数量达到该值才合并
+	MaxFeeRate decimal.Decimal //当前费率(每KB)高于该值不合并，0则不限制
+	Interval   time.Duration   //定时执行的间隔
+
+	wm        *WalletManager
+	decoder   *TransactionDecoder
+	mu        sync.Mutex
+	accounts  map[string]*consolidationAccount
+	observers map[ConsolidationObserver]bool
+	subs      *SubscriptionManager
+}
+
+//NewConsolidationScheduler 创建碎片utxo合并任务
+func NewConsolidationScheduler(wm *WalletManager) *ConsolidationScheduler {
+	return &ConsolidationScheduler{
+		MinUTXOs:  defaultConsolidateMinUTXOs,
+		Interval:  defaultConsolidateInterval,
+		wm:        wm,
+		decoder:   NewTransactionDecoder(wm),
+		accounts:  make(map[string]*consolidationAccount),
+		observers: make(map[ConsolidationObserver]bool),
+		subs:      NewSubscriptionManager(wm.Log),
+	}
+}
+
+//AddAccount 添加需要合并utxo的账户
+func (s *ConsolidationScheduler) AddAccount(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[account.AccountID] = &consolidationAccount{wrapper: wrapper, account: account}
+}
+
+//RemoveAccount 移除账户
+func (s *ConsolidationScheduler) RemoveAccount(accountID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accounts, accountID)
+}
+
+//AddObserver 添加观察者
+func (s *ConsolidationScheduler) AddObserver(obj ConsolidationObserver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj == nil {
+		return
+	}
+	s.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (s *ConsolidationScheduler) RemoveObserver(obj ConsolidationObserver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.observers, obj)
+}
+
+//Start 启动定时合并
+func (s *ConsolidationScheduler) Start() {
+	if s.subs.Running() {
+		return
+	}
+	s.subs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Run()
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+//Stop 停止定时合并
+func (s *ConsolidationScheduler) Stop() {
+	s.subs.Stop()
+}
+
+//Run 执行一次合并，费率过高时跳过，返回创建的交易单
+func (s *ConsolidationScheduler) Run() []*openwallet.RawTransactionWithError {
+
+	rawTxArray := make([]*openwallet.RawTransactionWithError, 0)
+
+	s.mu.Lock()
+	accounts := make([]*consolidationAccount, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	observers := make([]ConsolidationObserver, 0, len(s.observers))
+	for o := range s.observers {
+		observers = append(observers, o)
+	}
+	s.mu.Unlock()
+
+	if len(accounts) == 0 {
+		return rawTxArray
+	}
+
+	//合并不急于确认，使用economy优先级
+	feeRate, err := s.wm.EstimateFeeRateByPriority(FeePriorityEconomy)
+	if err != nil {
+		s.wm.Log.Errorf("consolidation estimate fee rate failed unexpected error: %v", err)
+		return rawTxArray
+	}
+
+	if s.MaxFeeRate.GreaterThan(decimal.Zero) && feeRate.GreaterThan(s.MaxFeeRate) {
+		s.wm.Log.Infof("consolidation skipped, fee rate: %s is higher than: %s", feeRate.String(), s.MaxFeeRate.String())
+		return rawTxArray
+	}
+
+	for _, a := range accounts {
+		rawTx, createErr := s.decoder.CreateConsolidationRawTransaction(a.wrapper, a.account, feeRate, s.MinUTXOs)
+		if createErr == nil && rawTx == nil {
+			continue
+		}
+		if rawTx == nil {
+			rawTx = &openwallet.RawTransaction{Account: a.account}
+		}
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+			RawTx: rawTx,
+			Error: openwallet.ConvertError(createErr),
+		})
+		if createErr != nil {
+			s.wm.Log.Errorf("account: %s create consolidation transaction failed unexpected error: %v", a.account.AccountID, createErr)
+			continue
+		}
+		for _, o := range observers {
+			if notifyErr := o.ConsolidationTxNotify(rawTx); notifyErr != nil {
+				s.wm.Log.Errorf("consolidation notify failed unexpected error: %v", notifyErr)
+			}
+		}
+	}
+
+	return rawTxArray
+}
+
+//consolidationUTXO 可合并的utxo：已确认、可花费、花费划算，按金额从小到大排序，最多取MaxTxInputs个
+func (decoder *TransactionDecoder) consolidationUTXO(unspents []*Unspent, feeRate decimal.Decimal) []*Unspent {
+
+	candidates := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		if !u.Spendable || u.Confirmations == 0 {
+			continue
+		}
+		candidates = append(candidates, u)
+	}
+
+	candidates = decoder.filterEconomicalUTXO(candidates, feeRate)
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, _ := decimal.NewFromString(candidates[i].Amount)
+		b, _ := decimal.NewFromString(candidates[j].Amount)
+		return a.LessThan(b)
+	})
+
+	if len(candidates) > decoder.wm.Config.MaxTxInputs {
+		candidates = candidates[:decoder.wm.Config.MaxTxInputs]
+	}
+
+	return candidates
+}
+
+//CreateConsolidationRawTransaction 创建账户的utxo合并交易单，可合并的utxo不足minUTXOs时返回nil
+func (decoder *TransactionDecoder) CreateConsolidationRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, feeRate decimal.Decimal, minUTXOs int) (*openwallet.RawTransaction, error) {
+
+	//同一账户串行构建
+	defer lockAccountBuild(account.AccountID)()
+
+	unspents, findErr := decoder.getAssetsAccountUnspents(wrapper, account)
+	if findErr != nil {
+		return nil, findErr
+	}
+
+	//保留1个omni的最低转账成本的utxo 用于omni转账
+	unspents = decoder.keepOmniCostUTXONotToUse(unspents)
+
+	usedUTXO := decoder.consolidationUTXO(unspents, feeRate)
+	if len(usedUTXO) < minUTXOs || len(usedUTXO) < 2 {
+		return nil, nil
+	}
+
+	total := decimal.Zero
+	for _, u := range usedUTXO {
+		amount, _ := decimal.NewFromString(u.Amount)
+		total = total.Add(amount)
+	}
+
+	fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), 1, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := decoder.selectChangeAddress(wrapper, account, usedUTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := total.Sub(fees)
+	if amount.LessThanOrEqual(decimal.Zero) || decoder.wm.IsDust(change.Address, amount) {
+		return nil, fmt.Errorf("consolidation amount: %s is not enough to pay fees: %s", total.String(), fees.String())
+	}
+
+	//手续费安全上限
+	if err = decoder.wm.CheckFees(fees, amount); err != nil {
+		return nil, err
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Consolidate Account: %s", account.AccountID)
+	decoder.wm.Log.Std.Notice("Inputs: %d", len(usedUTXO))
+	decoder.wm.Log.Std.Notice("Use: %v", total.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Fees: %v", fees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("To Address: %v", change.Address)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	outputs := []*TxOutput{{Address: change.Address, Amount: amount, IsChange: true}}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:     openwallet.Coin{Symbol: account.Symbol},
+		Account:  account,
+		FeeRate:  feeRate.StringFixed(decoder.wm.Decimal()),
+		To:       map[string]string{change.Address: amount.StringFixed(decoder.wm.Decimal())},
+		Fees:     fees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+		Change:   change,
+	}
+
+	err = decoder.createBTCRawTransaction(wrapper, rawTx, usedUTXO, outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = decoder.commitChangeAddress(wrapper, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawTx, nil
+}
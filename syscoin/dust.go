@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/hex"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	scriptTypeP2PKH   = "p2pkh"
+	scriptTypeP2SH    = "p2sh"
+	scriptTypeP2WPKH  = "p2wpkh"
+	scriptTypeP2WSH   = "p2wsh"
+	scriptTypeUnknown = "unknown"
+)
+
+//defaultDustRelayFee 节点默认的粉尘中继费率，每KB 3000聪
+var defaultDustRelayFee = decimal.New(3000, -8)
+
+//scriptType 输出脚本的类型
+func scriptType(script []byte) string {
+	switch {
+	case len(script) == 25 && script[0] == 0x76 && script[1] == 0xa9 && script[2] == 0x14 && script[23] == 0x88 && script[24] == 0xac:
+		return scriptTypeP2PKH
+	case len(script) == 23 && script[0] == 0xa9 && script[1] == 0x14 && script[22] == 0x87:
+		return scriptTypeP2SH
+	case len(script) == 22 && script[0] == 0x00 && script[1] == 0x14:
+		return scriptTypeP2WPKH
+	case len(script) == 34 && script[0] == 0x00 && script[1] == 0x20:
+		return scriptTypeP2WSH
+	}
+	return scriptTypeUnknown
+}
+
+//spendInputSize 花费该类型输出的输入大小(vbytes)，P2SH按隔离见证嵌套P2WPKH计算
+func spendInputSize(scriptType string) int64 {
+	switch scriptType {
+	case scriptTypeP2SH:
+		return 91
+	case scriptTypeP2WPKH:
+		return 68
+	case scriptTypeP2WSH:
+		return 105
+	}
+	return 148
+}
+
+//dustThresholdOfScript 输出的粉尘阈值，与节点的GetDustThreshold一致：
+//(输出大小 + 花费输入大小) * 粉尘中继费率，隔离见证输入按67字节计算
+func (wm *WalletManager) dustThresholdOfScript(script []byte) decimal.Decimal {
+	size := int64(8 + 1 + len(script))
+	switch scriptType(script) {
+	case scriptTypeP2WPKH, scriptTypeP2WSH:
+		size += 67
+	default:
+		size += 148
+	}
+
+	relayFee := wm.Config.DustRelayFee
+	if relayFee.LessThanOrEqual(decimal.Zero) {
+		relayFee = defaultDustRelayFee
+	}
+
+	return decimal.New(size, 0).Mul(relayFee).Div(decimal.New(1000, 0)).Round(wm.Decimal())
+}
+
+//DustThreshold 地址的粉尘阈值，输出金额低于该值节点不会中继
+func (wm *WalletManager) DustThreshold(address string) (decimal.Decimal, error) {
+	script, err := wm.addressScriptPubKey(address)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return wm.dustThresholdOfScript(script), nil
+}
+
+//IsDust 输出金额是否低于地址的粉尘阈值，地址无法解析时按P2PKH计算
+func (wm *WalletManager) IsDust(address string, amount decimal.Decimal) bool {
+	threshold, err := wm.DustThreshold(address)
+	if err != nil {
+		threshold = wm.dustThresholdOfScript(make([]byte, 25))
+	}
+	return amount.LessThan(threshold)
+}
+
+//unspentScript utxo的锁定脚本，没有则通过地址计算
+func (wm *WalletManager) unspentScript(u *Unspent) []byte {
+	if script, err := hex.DecodeString(u.ScriptPubKey); err == nil && len(script) > 0 {
+		return script
+	}
+	script, _ := wm.addressScriptPubKey(u.Address)
+	return script
+}
+
+//SpendCost 按费率(每KB)花费该utxo需要的手续费
+func (wm *WalletManager) SpendCost(u *Unspent, feeRate decimal.Decimal) decimal.Decimal {
+	size := spendInputSize(scriptType(wm.unspentScript(u)))
+	return decimal.New(size, 0).Mul(feeRate).Div(decimal.New(1000, 0)).Round(wm.Decimal())
+}
+
+//IsUneconomicalUTXO utxo的金额不足以支付花费它的手续费
+func (wm *WalletManager) IsUneconomicalUTXO(u *Unspent, feeRate decimal.Decimal) bool {
+	amount, _ := decimal.NewFromString(u.Amount)
+	return amount.LessThanOrEqual(wm.SpendCost(u, feeRate))
+}
+
+//filterEconomicalUTXO 过滤掉按当前费率不划算花费的utxo
+func (decoder *TransactionDecoder) filterEconomicalUTXO(unspents []*Unspent, feeRate decimal.Decimal) []*Unspent {
+	result := make([]*Unspent, 0, len(unspents))
+	skipped := 0
+	for _, u := range unspents {
+		if decoder.wm.IsUneconomicalUTXO(u, feeRate) {
+			skipped++
+			continue
+		}
+		result = append(result, u)
+	}
+	if skipped > 0 {
+		decoder.wm.Log.Infof("skip %d uneconomical utxo at fee rate: %s", skipped, feeRate.String())
+	}
+	return result
+}
+
+//foldDustChange 找零低于粉尘阈值时不输出，并入手续费
+func (decoder *TransactionDecoder) foldDustChange(changeAddress string, changeAmount, fees decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	if changeAmount.GreaterThan(decimal.Zero) && decoder.wm.IsDust(changeAddress, changeAmount) {
+		decoder.wm.Log.Infof("change: %s to address: %s is dust, fold into fees", changeAmount.String(), changeAddress)
+		return decimal.Zero, fees.Add(changeAmount)
+	}
+	return changeAmount, fees
+}
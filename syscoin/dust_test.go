@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/shopspring/decimal"
+)
+
+func TestWalletManager_DustThreshold(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{Decimals: Decimals}}
+
+	tests := map[string]string{
+		"sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc": "0.00000294",
+		"SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB":          "0.00000546",
+	}
+
+	for address, want := range tests {
+		threshold, err := wm.DustThreshold(address)
+		if err != nil {
+			t.Errorf("address: %s unexpected error: %v", address, err)
+			continue
+		}
+		if threshold.StringFixed(Decimals) != want {
+			t.Errorf("address: %s dust threshold: %s, want: %s", address, threshold.String(), want)
+		}
+	}
+}
+
+func TestTransactionDecoder_DustAndUneconomical(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{Decimals: Decimals}}
+	wm.Log = log.NewOWLogger(Symbol)
+	decoder := NewTransactionDecoder(wm)
+
+	//10聪/字节，P2PKH输入148字节花费0.0000148
+	feeRate := decimal.RequireFromString("0.0001")
+	unspents := []*Unspent{
+		{TxID: "a", Address: "SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB", Amount: "0.0000148"},
+		{TxID: "b", Address: "SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB", Amount: "0.0000149"},
+		{TxID: "c", ScriptPubKey: "00140dfc8bafc8419853b34d5e072ad37d1a5159f584", Amount: "0.0000069"},
+	}
+
+	result := decoder.filterEconomicalUTXO(unspents, feeRate)
+	if len(result) != 2 || result[0].TxID != "b" || result[1].TxID != "c" {
+		t.Errorf("unexpected economical utxo: %+v", result)
+	}
+
+	change, fees := decoder.foldDustChange("SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB", decimal.RequireFromString("0.000005"), decimal.RequireFromString("0.0001"))
+	if !change.IsZero() || fees.String() != "0.000105" {
+		t.Errorf("dust change should be folded into fees, change: %s fees: %s", change.String(), fees.String())
+	}
+
+	change, fees = decoder.foldDustChange("sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc", decimal.RequireFromString("0.000005"), decimal.RequireFromString("0.0001"))
+	if change.String() != "0.000005" || fees.String() != "0.0001" {
+		t.Errorf("change above dust should be kept, change: %s fees: %s", change.String(), fees.String())
+	}
+}
@@ -96,8 +96,9 @@ func (b *Explorer) isError(resp *req.Resp) error {
 		return errors.New("Response is empty! ")
 	}
 
+	//与节点RPC的错误格式一致：[状态码]错误信息
 	if resp.Response().StatusCode != http.StatusOK {
-		return fmt.Errorf("%s", resp.String())
+		return fmt.Errorf("[%d]%s", resp.Response().StatusCode, resp.String())
 	}
 
 	return nil
@@ -462,18 +463,18 @@ func (wm *WalletManager) getMultiAddrTransactionsByExplorer(offset, limit int, a
 }
 
 //estimateFeeRateByExplorer 通过浏览器获取费率
-func (wm *WalletManager) estimateFeeRateByExplorer() (decimal.Decimal, error) {
+func (wm *WalletManager) estimateFeeRateByExplorer(target int) (decimal.Decimal, error) {
 
 	defaultRate, _ := decimal.NewFromString("0.00001")
 
-	path := fmt.Sprintf("utils/estimatefee?nbBlocks=%d", 2)
+	path := fmt.Sprintf("utils/estimatefee?nbBlocks=%d", target)
 
 	result, err := wm.ExplorerClient.Call(path, nil, "GET")
 	if err != nil {
 		return decimal.New(0, 0), err
 	}
 
-	feeRate, _ := decimal.NewFromString(result.Get("2").String())
+	feeRate, _ := decimal.NewFromString(result.Get(fmt.Sprintf("%d", target)).String())
 
 	if feeRate.LessThan(defaultRate) {
 		feeRate = defaultRate
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	relayFeeCacheExpiry = 10 * time.Minute //节点最低中继费率的缓存时间
+)
+
+//relayFeeCache 节点最低中继费率缓存
+type relayFeeCache struct {
+	mu      sync.Mutex
+	value   decimal.Decimal
+	updated time.Time
+}
+
+//nodeRelayFee 通过getnetworkinfo获取节点的最低中继费率(每KB)，使用浏览器时返回0
+func (wm *WalletManager) nodeRelayFee() decimal.Decimal {
+
+	if wm.Config.RPCServerType == RPCServerExplorer || wm.WalletClient == nil {
+		return decimal.Zero
+	}
+
+	wm.relayFee.mu.Lock()
+	defer wm.relayFee.mu.Unlock()
+
+	if time.Since(wm.relayFee.updated) < relayFeeCacheExpiry {
+		return wm.relayFee.value
+	}
+
+	result, err := wm.WalletClient.Call("getnetworkinfo", nil)
+	if err != nil {
+		wm.Log.Warningf("get node relay fee failed, %v", err)
+		return wm.relayFee.value
+	}
+
+	relayFee, err := decimal.NewFromString(result.Get("relayfee").String())
+	if err != nil {
+		return wm.relayFee.value
+	}
+
+	wm.relayFee.value = relayFee
+	wm.relayFee.updated = time.Now()
+	return relayFee
+}
+
+//MinFeeRate 最低费率(每KB)，取配置的最低中继费率和节点minrelaytxfee的较大者
+func (wm *WalletManager) MinFeeRate() decimal.Decimal {
+	minFeeRate := wm.Config.MinRelayFeeRate
+	if minFeeRate.LessThanOrEqual(decimal.Zero) {
+		minFeeRate = defaultMinRelayFeeRate
+	}
+	if relayFee := wm.nodeRelayFee(); relayFee.GreaterThan(minFeeRate) {
+		minFeeRate = relayFee
+	}
+	return minFeeRate
+}
+
+//ResolveFeeRate 确定建单使用的费率(每KB)，feeRate为空时按优先级预估
+//调用方指定的费率超出范围时返回错误，预估的费率低于下限时提高到下限，高于上限时降低到上限
+func (wm *WalletManager) ResolveFeeRate(feeRate, priority string) (decimal.Decimal, error) {
+
+	var (
+		minFeeRate = wm.MinFeeRate()
+		maxFeeRate = wm.Config.MaxFeeRate
+	)
+
+	if len(feeRate) > 0 {
+		rate, err := decimal.NewFromString(feeRate)
+		if err != nil {
+			return decimal.Zero, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid fee rate: %s", feeRate)
+		}
+		if rate.LessThan(minFeeRate) {
+			return decimal.Zero, openwallet.Errorf(openwallet.ErrInsufficientFees, "fee rate: %s is lower than min fee rate: %s", feeRate, minFeeRate.String())
+		}
+		if maxFeeRate.GreaterThan(decimal.Zero) && rate.GreaterThan(maxFeeRate) {
+			return decimal.Zero, openwallet.Errorf(ErrTxPolicyFeeTooHigh, "fee rate: %s is higher than max fee rate: %s", feeRate, maxFeeRate.String())
+		}
+		return rate, nil
+	}
+
+	rate, err := wm.EstimateFeeRateByPriority(priority)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	if rate.LessThan(minFeeRate) {
+		wm.Log.Debugf("estimated fee rate: %s is lower than min fee rate: %s", rate.String(), minFeeRate.String())
+		rate = minFeeRate
+	}
+	if maxFeeRate.GreaterThan(decimal.Zero) && rate.GreaterThan(maxFeeRate) {
+		wm.Log.Warningf("estimated fee rate: %s is capped to max fee rate: %s", rate.String(), maxFeeRate.String())
+		rate = maxFeeRate
+	}
+
+	return rate, nil
+}
+
+//CheckFees 检查手续费是否超过绝对上限和相对发送金额的比例上限，amount为0时不检查比例
+func (wm *WalletManager) CheckFees(fees, amount decimal.Decimal) error {
+
+	if wm.Config.MaxFee.GreaterThan(decimal.Zero) && fees.GreaterThan(wm.Config.MaxFee) {
+		return openwallet.Errorf(ErrTxPolicyFeeTooHigh, "fees: %s is higher than max fee: %s", fees.String(), wm.Config.MaxFee.String())
+	}
+
+	if wm.Config.MaxFeeRatio.GreaterThan(decimal.Zero) && amount.GreaterThan(decimal.Zero) {
+		if ratio := fees.Div(amount); ratio.GreaterThan(wm.Config.MaxFeeRatio) {
+			return openwallet.Errorf(ErrTxPolicyFeeTooHigh, "fees: %s is over %s of amount: %s", fees.String(), wm.Config.MaxFeeRatio.String(), amount.String())
+		}
+	}
+
+	return nil
+}
+
+//rawTxSendAmount 交易单的发送总额，不包括找零
+func rawTxSendAmount(rawTx *openwallet.RawTransaction) decimal.Decimal {
+	total := decimal.Zero
+	for _, amount := range rawTx.To {
+		a, _ := decimal.NewFromString(amount)
+		total = total.Add(a)
+	}
+	return total
+}
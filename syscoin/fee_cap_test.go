@@ -0,0 +1,71 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestWalletManager_FeeCaps(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{
+		Decimals:        Decimals,
+		RPCServerType:   RPCServerExplorer,
+		MinRelayFeeRate: defaultMinRelayFeeRate,
+		MaxFeeRate:      defaultMaxFeeRate,
+		MaxFee:          decimal.RequireFromString("0.01"),
+		MaxFeeRatio:     decimal.RequireFromString("0.1"),
+	}}
+	wm.Log = log.NewOWLogger(Symbol)
+
+	rates := map[string]uint64{
+		"0.0001":   0,
+		"0.000001": openwallet.ErrInsufficientFees,
+		"0.1":      ErrTxPolicyFeeTooHigh,
+		"abc":      openwallet.ErrCreateRawTransactionFailed,
+	}
+	for rate, code := range rates {
+		_, err := wm.ResolveFeeRate(rate, "")
+		if code == 0 {
+			if err != nil {
+				t.Errorf("fee rate: %s unexpected error: %v", rate, err)
+			}
+			continue
+		}
+		if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != code {
+			t.Errorf("fee rate: %s error: %v, want code: %d", rate, err, code)
+		}
+	}
+
+	fees := []struct {
+		fees, amount string
+		ok           bool
+	}{
+		{"0.001", "1", true},
+		{"0.02", "1", false},
+		{"0.001", "0.005", false},
+		{"0.001", "0", true},
+	}
+	for _, f := range fees {
+		err := wm.CheckFees(decimal.RequireFromString(f.fees), decimal.RequireFromString(f.amount))
+		if (err == nil) != f.ok {
+			t.Errorf("fees: %s amount: %s error: %v", f.fees, f.amount, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+//手续费优先级
+const (
+	FeePriorityFast    = "fast"
+	FeePriorityNormal  = "normal"
+	FeePriorityEconomy = "economy"
+)
+
+//estimatesmartfee的预估模式
+const (
+	FeeModeConservative = "CONSERVATIVE"
+	FeeModeEconomical   = "ECONOMICAL"
+)
+
+const (
+	defaultFastFeeTarget    = 2
+	defaultNormalFeeTarget  = 6
+	defaultEconomyFeeTarget = 24
+
+	blockMaxVSize = 1000000 //区块的最大虚拟大小
+)
+
+//FeeRateTier 手续费优先级对应的确认目标和预估模式
+type FeeRateTier struct {
+	Priority string `json:"priority"`
+	Target   int    `json:"target"` //期望确认的区块数
+	Mode     string `json:"mode"`
+	FeeRate  string `json:"feeRate"` //每KB的费率
+}
+
+//feeTier 优先级对应的预估参数，未知优先级使用配置的默认优先级
+func (wm *WalletManager) feeTier(priority string) *FeeRateTier {
+	switch priority {
+	case FeePriorityFast:
+		return &FeeRateTier{Priority: priority, Target: wm.Config.FastFeeTarget, Mode: FeeModeConservative}
+	case FeePriorityNormal:
+		return &FeeRateTier{Priority: priority, Target: wm.Config.NormalFeeTarget, Mode: FeeModeEconomical}
+	case FeePriorityEconomy:
+		return &FeeRateTier{Priority: priority, Target: wm.Config.EconomyFeeTarget, Mode: FeeModeEconomical}
+	default:
+		if priority != wm.Config.FeePriority && len(wm.Config.FeePriority) > 0 {
+			return wm.feeTier(wm.Config.FeePriority)
+		}
+		return &FeeRateTier{Priority: FeePriorityFast, Target: defaultFastFeeTarget, Mode: FeeModeConservative}
+	}
+}
+
+//EstimateFeeRates 预估所有优先级的每KB手续费率
+func (wm *WalletManager) EstimateFeeRates() ([]*FeeRateTier, error) {
+	tiers := make([]*FeeRateTier, 0)
+	for _, priority := range []string{FeePriorityFast, FeePriorityNormal, FeePriorityEconomy} {
+		rate, err := wm.EstimateFeeRateByPriority(priority)
+		if err != nil {
+			return nil, err
+		}
+		tier := wm.feeTier(priority)
+		tier.FeeRate = rate.StringFixed(wm.Decimal())
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+//mempoolFeeEntry 内存池交易的大小和手续费
+type mempoolFeeEntry struct {
+	VSize int64
+	Fees  decimal.Decimal
+}
+
+//feeRateFromHistogram 按费率从高到低累计内存池交易大小，返回target个区块能容纳的最低费率(每KB)
+//内存池不足target个区块时返回0，由调用方使用最低费率
+func feeRateFromHistogram(entries []*mempoolFeeEntry, target int) decimal.Decimal {
+
+	type bucket struct {
+		rate  decimal.Decimal
+		vsize int64
+	}
+
+	buckets := make([]bucket, 0, len(entries))
+	for _, e := range entries {
+		if e.VSize <= 0 {
+			continue
+		}
+		rate := e.Fees.Mul(decimal.New(1000, 0)).Div(decimal.New(e.VSize, 0))
+		buckets = append(buckets, bucket{rate: rate, vsize: e.VSize})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].rate.GreaterThan(buckets[j].rate)
+	})
+
+	capacity := int64(target) * blockMaxVSize
+	var total int64
+	for _, b := range buckets {
+		total += b.vsize
+		if total >= capacity {
+			return b.rate
+		}
+	}
+
+	return decimal.Zero
+}
+
+//estimateFeeRateByMempool 通过内存池的费率分布预估每KB手续费率
+func (wm *WalletManager) estimateFeeRateByMempool(target int) (decimal.Decimal, error) {
+
+	result, err := wm.WalletClient.Call("getrawmempool", []interface{}{true})
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	entries := make([]*mempoolFeeEntry, 0)
+	for _, tx := range result.Map() {
+		fees := tx.Get("fees.base")
+		if !fees.Exists() {
+			fees = tx.Get("fee")
+		}
+		amount, _ := decimal.NewFromString(fees.String())
+		vsize := tx.Get("vsize").Int()
+		if vsize == 0 {
+			vsize = tx.Get("size").Int()
+		}
+		entries = append(entries, &mempoolFeeEntry{VSize: vsize, Fees: amount})
+	}
+
+	feeRate := feeRateFromHistogram(entries, target)
+	if minFeeRate := wm.MinFeeRate(); feeRate.LessThan(minFeeRate) {
+		feeRate = minFeeRate
+	}
+
+	return feeRate, nil
+}
+
+//feeRateUnit 费率单位附带所有优先级的费率，格式：K?fast=0.0002&normal=0.0001&economy=0.00001
+//openwallet获取费率的接口没有参数，应用按"?"前的部分作为单位，后面是各优先级的每KB费率
+func feeRateUnit(tiers []*FeeRateTier) string {
+	params := make([]string, 0, len(tiers))
+	for _, tier := range tiers {
+		params = append(params, tier.Priority+"="+tier.FeeRate)
+	}
+	if len(params) == 0 {
+		return "K"
+	}
+	return "K?" + strings.Join(params, "&")
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+func TestFeeRateFromHistogram(t *testing.T) {
+	entries := []*mempoolFeeEntry{
+		{VSize: 600000, Fees: decimal.RequireFromString("0.06")},  //0.0001/KB
+		{VSize: 600000, Fees: decimal.RequireFromString("0.3")},   //0.0005/KB
+		{VSize: 600000, Fees: decimal.RequireFromString("0.012")}, //0.00002/KB
+		{VSize: 0, Fees: decimal.RequireFromString("1")},
+	}
+
+	tests := map[int]string{
+		1: "0.0001",
+		2: "0",
+	}
+	for target, want := range tests {
+		rate := feeRateFromHistogram(entries, target)
+		if !rate.Equal(decimal.RequireFromString(want)) {
+			t.Errorf("target: %d fee rate: %s, want: %s", target, rate.String(), want)
+		}
+	}
+}
+
+func TestWalletManager_FeeTier(t *testing.T) {
+	wm := &WalletManager{Config: NewConfig(Symbol, CurveType, Decimals)}
+	wm.Config.FeePriority = FeePriorityNormal
+
+	tests := map[string]FeeRateTier{
+		FeePriorityFast:    {Priority: FeePriorityFast, Target: defaultFastFeeTarget, Mode: FeeModeConservative},
+		FeePriorityEconomy: {Priority: FeePriorityEconomy, Target: defaultEconomyFeeTarget, Mode: FeeModeEconomical},
+		"":                 {Priority: FeePriorityNormal, Target: defaultNormalFeeTarget, Mode: FeeModeEconomical},
+	}
+	for priority, want := range tests {
+		if tier := wm.feeTier(priority); *tier != want {
+			t.Errorf("priority: %s tier: %+v, want: %+v", priority, *tier, want)
+		}
+	}
+}
+
+func TestTransactionDecoder_GetRawTransactionFeeRate(t *testing.T) {
+	rates := map[int64]string{
+		defaultFastFeeTarget:    "0.0002",
+		defaultNormalFeeTarget:  "0.0001",
+		defaultEconomyFeeTarget: "0.00002",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		target := gjson.GetBytes(body, "params.0").Int()
+		fmt.Fprintf(w, `{"result":{"feerate":%s,"blocks":%d},"error":null,"id":"1"}`, rates[target], target)
+	}))
+	defer server.Close()
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerCore
+	wm.Config.FeePriority = FeePriorityNormal
+	wm.WalletClient = NewClient(server.URL, "", false)
+
+	feeRate, unit, err := NewTransactionDecoder(wm).GetRawTransactionFeeRate()
+	if err != nil {
+		t.Fatalf("GetRawTransactionFeeRate failed unexpected error: %v", err)
+	}
+	if feeRate != "0.00010000" {
+		t.Errorf("fee rate: %s, want default priority rate", feeRate)
+	}
+	want := "K?fast=0.00020000&normal=0.00010000&economy=0.00002000"
+	if unit != want {
+		t.Errorf("unit: %s, want: %s", unit, want)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/v2/common/file"
+)
+
+var (
+	localDBMu sync.Mutex
+	localDBs  = make(map[string]*storm.DB)
+)
+
+//OpenLocalDB 打开适配器本地数据库，保存扫描器和交易构建的运行状态
+//数据库打开后保持连接，多次调用返回同一个实例，避免重复打开时文件锁阻塞
+func (wm *WalletManager) OpenLocalDB() (*storm.DB, error) {
+
+	localDBMu.Lock()
+	defer localDBMu.Unlock()
+
+	dbFile := filepath.Join(wm.Config.DBPath, strings.ToLower(wm.Symbol())+"_adapter.db")
+
+	if db, ok := localDBs[dbFile]; ok {
+		return db, nil
+	}
+
+	file.MkdirAll(wm.Config.DBPath)
+
+	db, err := storm.Open(dbFile)
+	if err != nil {
+		return nil, err
+	}
+
+	localDBs[dbFile] = db
+
+	return db, nil
+}
+
+//CloseLocalDB 关闭适配器本地数据库
+func (wm *WalletManager) CloseLocalDB() error {
+
+	localDBMu.Lock()
+	defer localDBMu.Unlock()
+
+	dbFile := filepath.Join(wm.Config.DBPath, strings.ToLower(wm.Symbol())+"_adapter.db")
+
+	db, ok := localDBs[dbFile]
+	if !ok {
+		return nil
+	}
+
+	delete(localDBs, dbFile)
+
+	return db.Close()
+}
@@ -50,6 +50,7 @@ type WalletManager struct {
 	WalletClient    *Client                       // 节点客户端
 	OnmiClient      *Client                       // Omni代币节点客户端
 	ExplorerClient  *Explorer                     // 浏览器API客户端
+	AuditClient     *Client                       //一致性检查的节点客户端，浏览器模式下为auditServerAPI
 	Config          *WalletConfig                 //钱包管理配置
 	WalletsInSum    map[string]*openwallet.Wallet //参与汇总的钱包
 	Blockscanner    *BTCBlockScanner              //区块扫描器
@@ -57,6 +58,13 @@ type WalletManager struct {
 	TxDecoder       openwallet.TransactionDecoder //交易单编码器
 	Log             *log.OWLogger                 //日志工具
 	ContractDecoder *ContractDecoder              //智能合约解析器
+	P2P             *P2PBackend                   //P2P节点后端
+	Consolidation   *ConsolidationScheduler       //碎片utxo合并任务
+	Outbound        *OutboundTracker              //广播交易单跟踪
+	OmniFuel        *OmniFuelManager              //持币地址的手续费utxo管理
+
+	relayFee       relayFeeCache     //节点最低中继费率缓存
+	omniProperties omniPropertyCache //Omni资产信息缓存
 }
 
 func NewWalletManager() *WalletManager {
@@ -73,6 +81,10 @@ func NewWalletManager() *WalletManager {
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.ContractDecoder = NewContractDecoder(&wm)
 	wm.Blockscanner.IsScanMemPool = false
+	wm.Consolidation = NewConsolidationScheduler(&wm)
+	wm.Outbound = NewOutboundTracker(&wm)
+	wm.OmniFuel = NewOmniFuelManager(&wm)
+	wm.Blockscanner.Mempool.AddObserver(wm.Outbound)
 	return &wm
 }
 
@@ -776,8 +788,17 @@ func (wm *WalletManager) GetBlockChainInfo() (*BlockchainInfo, error) {
 
 }
 
-//ListUnspent 获取未花记录
+//ListUnspent 获取未花记录，不包括交易单构建时预留的utxo
 func (wm *WalletManager) ListUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
+	utxos, err := wm.listUnspent(min, addresses...)
+	if err != nil {
+		return nil, err
+	}
+	return wm.filterLeasedUTXO(utxos), nil
+}
+
+//listUnspent 获取数据源的所有未花记录
+func (wm *WalletManager) listUnspent(min uint64, addresses ...string) ([]*Unspent, error) {
 
 	//:分页限制
 
@@ -809,6 +830,11 @@ func (wm *WalletManager) ListUnspent(min uint64, addresses ...string) ([]*Unspen
 			if err != nil {
 				return nil, err
 			}
+		} else if wm.Config.RPCServerType == RPCServerCFilter {
+			pice, err = wm.listUnspentByLocal(min, searchAddrs...)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			pice, err = wm.getListUnspentByCore(min, searchAddrs...)
 			if err != nil {
@@ -874,7 +900,7 @@ func (wm *WalletManager) RebuildWalletUnspent(walletID string) error {
 	}
 
 	//查找核心钱包确认数大于1的
-	utxos, err := wm.ListUnspent(0)
+	utxos, err := wm.listUnspent(0)
 	if err != nil {
 		return err
 	}
@@ -1070,11 +1096,28 @@ func (wm *WalletManager) SignRawTransactionInCoreWallet(txHex, walletID string,
 //SendRawTransaction 广播交易
 func (wm *WalletManager) SendRawTransaction(txHex string) (string, error) {
 
+	var (
+		txid string
+		err  error
+	)
+
 	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.sendRawTransactionByExplorer(txHex)
+		txid, err = wm.sendRawTransactionByExplorer(txHex)
 	} else {
-		return wm.sendRawTransactionByCore(txHex)
+		txid, err = wm.sendRawTransactionByCore(txHex)
+	}
+	if err != nil {
+		return "", err
 	}
+
+	//同时通过P2P节点转发，加快交易传播
+	if wm.P2P != nil {
+		if _, p2pErr := wm.P2P.SendRawTransaction(txHex); p2pErr != nil {
+			wm.Log.Warningf("transaction: %s relay by p2p peer failed, %v", txid, p2pErr)
+		}
+	}
+
+	return txid, nil
 }
 
 //sendRawTransactionByCore 广播交易
@@ -1118,39 +1161,57 @@ func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Deci
 	return trx_fee, nil
 }
 
-//EstimateFeeRate 预估的没KB手续费率
+//EstimateFeeRate 预估的没KB手续费率，使用配置的默认优先级
 func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
+	return wm.EstimateFeeRateByPriority(wm.Config.FeePriority)
+}
+
+//EstimateFeeRateByPriority 按优先级预估的每KB手续费率
+func (wm *WalletManager) EstimateFeeRateByPriority(priority string) (decimal.Decimal, error) {
+
+	tier := wm.feeTier(priority)
 
 	if wm.Config.RPCServerType == RPCServerExplorer {
-		return wm.estimateFeeRateByExplorer()
+		return wm.estimateFeeRateByExplorer(tier.Target)
 	} else {
-		return wm.estimateFeeRateByCore()
+		return wm.estimateFeeRateByCore(tier.Target, tier.Mode)
 	}
 }
 
 //estimateFeeRateByCore 预估的没KB手续费率
-func (wm *WalletManager) estimateFeeRateByCore() (decimal.Decimal, error) {
+func (wm *WalletManager) estimateFeeRateByCore(target int, mode string) (decimal.Decimal, error) {
 
 	feeRate := decimal.Zero
 
 	//估算交易大小 手续费
 	request := []interface{}{
-		2,
+		target,
+		mode,
 	}
 
 	estimatesmartfee, err := wm.WalletClient.Call("estimatesmartfee", request)
-	if err != nil {
+	if err == nil {
+		feeRate, _ = decimal.NewFromString(estimatesmartfee.Get("feerate").String())
+	}
 
-		estimatefee, err2 := wm.WalletClient.Call("estimatefee", request)
-		if err2 != nil {
-			return decimal.New(0, 0), err2
-		}
+	//节点数据不足时没有feerate
+	if feeRate.GreaterThan(decimal.Zero) {
+		return feeRate, nil
+	}
+
+	estimatefee, err := wm.WalletClient.Call("estimatefee", []interface{}{target})
+	if err == nil {
 		feeRate, _ = decimal.NewFromString(estimatefee.String())
-	} else {
-		feeRate, _ = decimal.NewFromString(estimatesmartfee.Get("feerate").String())
 	}
 
-	return feeRate, nil
+	//estimatefee返回-1表示无法预估
+	if feeRate.GreaterThan(decimal.Zero) {
+		return feeRate, nil
+	}
+
+	wm.Log.Debugf("estimate fee rate of target: %d failed, use mempool histogram", target)
+
+	return wm.estimateFeeRateByMempool(target)
 }
 
 //AddWalletInSummary 添加汇总钱包账户
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//未确认交易的跟踪状态
+const (
+	MempoolTxStatusPending    = "pending"    //在内存池中等待确认
+	MempoolTxStatusConfirmed  = "confirmed"  //已被打包
+	MempoolTxStatusEvicted    = "evicted"    //被内存池移除
+	MempoolTxStatusReplaced   = "replaced"   //被内存池中的新交易替换（RBF）
+	MempoolTxStatusConflicted = "conflicted" //输入被已确认的交易双花
+)
+
+//MempoolTx 跟踪中的未确认交易
+type MempoolTx struct {
+	TxID       string   `storm:"id"`
+	Inputs     []string //花费的输出，格式：txid:vout
+	SourceKeys []string //相关的账户
+	Status     string
+	FirstSeen  int64
+	LastSeen   int64
+}
+
+//MempoolTxEvent 未确认交易的状态变化
+type MempoolTxEvent struct {
+	TxID        string
+	Status      string
+	ReplacedBy  string //替换或双花的交易id
+	BlockHash   string
+	BlockHeight uint64
+	SourceKeys  []string
+}
+
+//MempoolTxObserver 未确认交易状态变化的观察者
+type MempoolTxObserver interface {
+
+	//MempoolTxStatusNotify 未确认交易状态变化通知
+	MempoolTxStatusNotify(event *MempoolTxEvent) error
+}
+
+//MempoolWatcher 内存池交易跟踪，记录与本地地址相关的未确认交易，检查被移除、替换或双花
+type MempoolWatcher struct {
+	wm        *WalletManager
+	mu        sync.Mutex
+	loaded    bool
+	txs       map[string]*MempoolTx
+	spent     map[string]string //输出被哪个跟踪交易花费
+	observers map[MempoolTxObserver]bool
+}
+
+//NewMempoolWatcher 创建内存池交易跟踪
+func NewMempoolWatcher(wm *WalletManager) *MempoolWatcher {
+	return &MempoolWatcher{
+		wm:        wm,
+		txs:       make(map[string]*MempoolTx),
+		spent:     make(map[string]string),
+		observers: make(map[MempoolTxObserver]bool),
+	}
+}
+
+//AddObserver 添加观察者
+func (mw *MempoolWatcher) AddObserver(obj MempoolTxObserver) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (mw *MempoolWatcher) RemoveObserver(obj MempoolTxObserver) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	delete(mw.observers, obj)
+}
+
+//Pending 跟踪中的未确认交易
+func (mw *MempoolWatcher) Pending() []*MempoolTx {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	mw.load()
+
+	list := make([]*MempoolTx, 0, len(mw.txs))
+	for _, tx := range mw.txs {
+		list = append(list, tx)
+	}
+	return list
+}
+
+//Track 记录与本地地址相关的未确认交易
+func (mw *MempoolWatcher) Track(trx *Transaction, sourceKeys []string) {
+
+	if trx == nil || len(trx.BlockHash) > 0 {
+		return
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	mw.load()
+
+	now := time.Now().Unix()
+
+	if tx, ok := mw.txs[trx.TxID]; ok {
+		tx.LastSeen = now
+		return
+	}
+
+	tx := &MempoolTx{
+		TxID:       trx.TxID,
+		Inputs:     txOutpoints(trx),
+		SourceKeys: sourceKeys,
+		Status:     MempoolTxStatusPending,
+		FirstSeen:  now,
+		LastSeen:   now,
+	}
+
+	mw.txs[tx.TxID] = tx
+	for _, op := range tx.Inputs {
+		mw.spent[op] = tx.TxID
+	}
+
+	mw.save(tx)
+}
+
+//Process 检查新提取的交易，是否确认了跟踪中的交易，或者花费了跟踪交易的输入
+func (mw *MempoolWatcher) Process(trx *Transaction) {
+
+	if trx == nil {
+		return
+	}
+
+	events := make([]*MempoolTxEvent, 0)
+
+	mw.mu.Lock()
+
+	mw.load()
+
+	if len(mw.txs) == 0 {
+		mw.mu.Unlock()
+		return
+	}
+
+	confirmed := len(trx.BlockHash) > 0
+
+	if tx, ok := mw.txs[trx.TxID]; ok && confirmed {
+		events = append(events, mw.finish(tx, MempoolTxStatusConfirmed, "", trx))
+	}
+
+	for _, op := range txOutpoints(trx) {
+		txid, ok := mw.spent[op]
+		if !ok || txid == trx.TxID {
+			continue
+		}
+		tx := mw.txs[txid]
+		if tx == nil {
+			continue
+		}
+		status := MempoolTxStatusReplaced
+		if confirmed {
+			status = MempoolTxStatusConflicted
+		}
+		events = append(events, mw.finish(tx, status, trx.TxID, trx))
+	}
+
+	mw.mu.Unlock()
+
+	mw.notify(events)
+}
+
+//Reconcile 对比节点的内存池，检查不在内存池中的跟踪交易
+func (mw *MempoolWatcher) Reconcile(txIDsInMemPool []string) {
+
+	inMemPool := make(map[string]bool, len(txIDsInMemPool))
+	for _, txid := range txIDsInMemPool {
+		inMemPool[txid] = true
+	}
+
+	missing := make([]string, 0)
+
+	mw.mu.Lock()
+	mw.load()
+	now := time.Now().Unix()
+	for txid, tx := range mw.txs {
+		if inMemPool[txid] {
+			tx.LastSeen = now
+		} else {
+			missing = append(missing, txid)
+		}
+	}
+	mw.mu.Unlock()
+
+	mw.Recheck(missing)
+}
+
+//Recheck 向节点查询跟踪交易的最新状态，已确认或查询不到（被移除）时结束跟踪
+func (mw *MempoolWatcher) Recheck(txids []string) {
+
+	events := make([]*MempoolTxEvent, 0)
+
+	for _, txid := range txids {
+
+		mw.mu.Lock()
+		_, tracked := mw.txs[txid]
+		mw.mu.Unlock()
+
+		if !tracked {
+			continue
+		}
+
+		trx, err := mw.wm.GetTransaction(txid)
+
+		mw.mu.Lock()
+		tx, ok := mw.txs[txid]
+		if ok {
+			if err != nil {
+				if isTxNotFoundError(err) {
+					mw.wm.Log.Debugf("mempool tx: %s can not be found: %v", txid, err)
+					events = append(events, mw.finish(tx, MempoolTxStatusEvicted, "", nil))
+				} else {
+					//查询失败（超时、连接失败等）不能确定交易已被移除，继续跟踪
+					mw.wm.Log.Warningf("mempool tx: %s recheck failed: %v", txid, err)
+				}
+			} else if len(trx.BlockHash) > 0 {
+				events = append(events, mw.finish(tx, MempoolTxStatusConfirmed, "", trx))
+			}
+			//浏览器返回的内存池列表可能不完整，仍可查询到的未确认交易继续跟踪
+		}
+		mw.mu.Unlock()
+	}
+
+	mw.notify(events)
+}
+
+//isTxNotFoundError 节点(RPC错误码-5)或浏览器(HTTP 404)明确返回交易不存在
+func isTxNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "[-5]") || strings.HasPrefix(msg, "[404]")
+}
+
+//finish 结束跟踪，返回状态变化事件，调用前需要加锁
+func (mw *MempoolWatcher) finish(tx *MempoolTx, status, replacedBy string, trx *Transaction) *MempoolTxEvent {
+
+	delete(mw.txs, tx.TxID)
+	for _, op := range tx.Inputs {
+		if mw.spent[op] == tx.TxID {
+			delete(mw.spent, op)
+		}
+	}
+
+	mw.delete(tx)
+
+	event := &MempoolTxEvent{
+		TxID:       tx.TxID,
+		Status:     status,
+		ReplacedBy: replacedBy,
+		SourceKeys: tx.SourceKeys,
+	}
+
+	if trx != nil {
+		event.BlockHash = trx.BlockHash
+		event.BlockHeight = trx.BlockHeight
+	}
+
+	return event
+}
+
+//notify 通知观察者
+func (mw *MempoolWatcher) notify(events []*MempoolTxEvent) {
+
+	if len(events) == 0 {
+		return
+	}
+
+	mw.mu.Lock()
+	observers := make([]MempoolTxObserver, 0, len(mw.observers))
+	for o := range mw.observers {
+		observers = append(observers, o)
+	}
+	mw.mu.Unlock()
+
+	for _, event := range events {
+		mw.wm.Log.Infof("mempool tx: %s status changed to %s %s", event.TxID, event.Status, event.ReplacedBy)
+		for _, o := range observers {
+			err := o.MempoolTxStatusNotify(event)
+			if err != nil {
+				mw.wm.Log.Error("MempoolTxStatusNotify unexpected error:", err)
+			}
+		}
+	}
+}
+
+//load 从本地数据库加载跟踪中的交易，调用前需要加锁
+func (mw *MempoolWatcher) load() {
+
+	if mw.loaded {
+		return
+	}
+	mw.loaded = true
+
+	db, err := mw.wm.OpenLocalDB()
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher open local db failed unexpected error: %v", err)
+		return
+	}
+
+	var list []*MempoolTx
+	err = db.All(&list)
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher load txs failed unexpected error: %v", err)
+		return
+	}
+
+	for _, tx := range list {
+		mw.txs[tx.TxID] = tx
+		for _, op := range tx.Inputs {
+			mw.spent[op] = tx.TxID
+		}
+	}
+}
+
+func (mw *MempoolWatcher) save(tx *MempoolTx) {
+	db, err := mw.wm.OpenLocalDB()
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher open local db failed unexpected error: %v", err)
+		return
+	}
+	err = db.Save(tx)
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher save tx: %s failed unexpected error: %v", tx.TxID, err)
+	}
+}
+
+func (mw *MempoolWatcher) delete(tx *MempoolTx) {
+	db, err := mw.wm.OpenLocalDB()
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher open local db failed unexpected error: %v", err)
+		return
+	}
+	err = db.DeleteStruct(tx)
+	if err != nil {
+		mw.wm.Log.Errorf("mempool watcher delete tx: %s failed unexpected error: %v", tx.TxID, err)
+	}
+}
+
+//txOutpoints 交易花费的输出
+func txOutpoints(trx *Transaction) []string {
+	outpoints := make([]string, 0, len(trx.Vins))
+	for _, vin := range trx.Vins {
+		if len(vin.Coinbase) > 0 || len(vin.TxID) == 0 {
+			continue
+		}
+		outpoints = append(outpoints, fmt.Sprintf("%s:%d", vin.TxID, vin.Vout))
+	}
+	return outpoints
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testMempoolObserver struct {
+	events []*MempoolTxEvent
+}
+
+func (o *testMempoolObserver) MempoolTxStatusNotify(event *MempoolTxEvent) error {
+	o.events = append(o.events, event)
+	return nil
+}
+
+func TestMempoolWatcher_Replaced(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	obs := &testMempoolObserver{}
+	mw := NewMempoolWatcher(wm)
+	mw.AddObserver(obs)
+
+	mw.Track(&Transaction{TxID: "a", Vins: []*Vin{{TxID: "prev", Vout: 1}}}, []string{"account"})
+
+	//重启后从数据库恢复跟踪
+	mw = NewMempoolWatcher(wm)
+	mw.AddObserver(obs)
+	if n := len(mw.Pending()); n != 1 {
+		t.Fatalf("unexpected pending txs: %d", n)
+	}
+
+	mw.Process(&Transaction{TxID: "b", Vins: []*Vin{{TxID: "prev", Vout: 1}}})
+
+	if len(obs.events) != 1 {
+		t.Fatalf("unexpected events: %d", len(obs.events))
+	}
+	e := obs.events[0]
+	if e.TxID != "a" || e.Status != MempoolTxStatusReplaced || e.ReplacedBy != "b" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if n := len(mw.Pending()); n != 0 {
+		t.Errorf("unexpected pending txs: %d", n)
+	}
+}
+
+func TestMempoolWatcher_Conflicted(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	obs := &testMempoolObserver{}
+	mw := NewMempoolWatcher(wm)
+	mw.AddObserver(obs)
+
+	mw.Track(&Transaction{TxID: "a", Vins: []*Vin{{TxID: "prev", Vout: 0}}}, nil)
+	mw.Process(&Transaction{TxID: "c", BlockHash: "hash", BlockHeight: 10, Vins: []*Vin{{TxID: "prev", Vout: 0}}})
+
+	if len(obs.events) != 1 || obs.events[0].Status != MempoolTxStatusConflicted || obs.events[0].ReplacedBy != "c" || obs.events[0].BlockHeight != 10 {
+		t.Errorf("unexpected events: %+v", obs.events)
+	}
+}
+
+func TestMempoolWatcher_RecheckError(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	code := -28
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"result":null,"error":{"code":%d,"message":"rpc error"},"id":"1"}`, code)
+	}))
+	defer server.Close()
+
+	wm.Config.RPCServerType = RPCServerCore
+	wm.WalletClient = NewClient(server.URL, "", false)
+
+	obs := &testMempoolObserver{}
+	mw := NewMempoolWatcher(wm)
+	mw.AddObserver(obs)
+	mw.Track(&Transaction{TxID: "a", Vins: []*Vin{{TxID: "prev", Vout: 1}}}, []string{"account"})
+
+	//节点暂时不可用，继续跟踪
+	mw.Recheck([]string{"a"})
+	if len(obs.events) != 0 || len(mw.Pending()) != 1 {
+		t.Fatalf("tx should be tracked when recheck failed, events: %d", len(obs.events))
+	}
+
+	//节点确认交易不存在
+	code = -5
+	mw.Recheck([]string{"a"})
+	if len(obs.events) != 1 || obs.events[0].Status != MempoolTxStatusEvicted {
+		t.Fatalf("tx should be evicted when not found")
+	}
+}
+
+func TestIsTxNotFoundError(t *testing.T) {
+	tests := map[string]bool{
+		"[-5]No such mempool or blockchain transaction": true,
+		"[404]Not found":                                       true,
+		"[-28]Loading block index...":                          false,
+		"dial tcp 127.0.0.1:8332: connect: connection refused": false,
+	}
+	for msg, want := range tests {
+		if got := isTxNotFoundError(errors.New(msg)); got != want {
+			t.Errorf("%s not found: %v, want: %v", msg, got, want)
+		}
+	}
+}
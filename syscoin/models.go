@@ -160,7 +160,10 @@ type Block struct {
 	Height            uint64 `storm:"id"`
 	Version           uint64
 	Time              uint64
+	Bits              string
+	Nonce             uint64
 	Fork              bool
+	AuxPow            *AuxPow `json:"-"` //合并挖矿证明，解析原始区块头后才有
 	txDetails         []*Transaction
 	isVerbose         bool
 }
@@ -175,6 +178,8 @@ func (wm *WalletManager) NewBlock(json *gjson.Result) *Block {
 	obj.Previousblockhash = gjson.Get(json.Raw, "previousblockhash").String()
 	obj.Version = gjson.Get(json.Raw, "version").Uint()
 	obj.Time = gjson.Get(json.Raw, "time").Uint()
+	obj.Bits = gjson.Get(json.Raw, "bits").String()
+	obj.Nonce = gjson.Get(json.Raw, "nonce").Uint()
 
 	txs := make([]string, 0)
 	txDetails := make([]*Transaction, 0)
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultNotifyDedupWindow = 1 * time.Hour
+)
+
+//notifyDedup 推送去重记录，按txid记录已通知交易的确认状态。
+//内存池中已通知的交易被打包后不再重复通知，确认由MempoolWatcher的confirmed事件通知
+type notifyDedup struct {
+	mu        sync.Mutex
+	seen      map[string]*notifyDedupEntry
+	lastPrune time.Time
+}
+
+//notifyDedupEntry 已通知交易的确认状态
+type notifyDedupEntry struct {
+	height     uint64 //确认的区块高度，未确认为0
+	notifyTime time.Time
+}
+
+func newNotifyDedup() *notifyDedup {
+	return &notifyDedup{
+		seen:      make(map[string]*notifyDedupEntry),
+		lastPrune: time.Now(),
+	}
+}
+
+//Seen 交易是否在时间窗口内已通知，height为当前的确认高度，
+//已通知的未确认交易被打包时记录确认高度，重扫该高度时可清除
+func (d *notifyDedup) Seen(txid string, height uint64, window time.Duration) bool {
+	if window <= 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.seen[txid]
+	if !ok {
+		return false
+	}
+	if time.Since(entry.notifyTime) > window {
+		delete(d.seen, txid)
+		return false
+	}
+	if height > 0 && entry.height == 0 {
+		entry.height = height
+	}
+	return true
+}
+
+//Mark 记录已通知，并清理过期记录
+func (d *notifyDedup) Mark(txid string, height uint64, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.seen[txid] = &notifyDedupEntry{height: height, notifyTime: now}
+
+	if now.Sub(d.lastPrune) < time.Minute {
+		return
+	}
+	for k, entry := range d.seen {
+		if now.Sub(entry.notifyTime) > window {
+			delete(d.seen, k)
+		}
+	}
+	d.lastPrune = now
+}
+
+//ClearHeights 清除确认高度在[from, to]的记录，用于重扫区块和分叉回滚
+func (d *notifyDedup) ClearHeights(from, to uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, entry := range d.seen {
+		if entry.height > 0 && entry.height >= from && entry.height <= to {
+			delete(d.seen, k)
+		}
+	}
+}
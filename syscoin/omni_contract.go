@@ -16,6 +16,8 @@
 package syscoin
 
 import (
+	"strings"
+
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
@@ -54,6 +56,30 @@ func (wm *WalletManager) GetOmniTransaction(txid string) (*OmniTransaction, erro
 	return NewOmniTx(result), nil
 }
 
+//GetOmniSTORecipients 获取Send To Owners交易的全部接收地址
+func (wm *WalletManager) GetOmniSTORecipients(txid string) ([]*OmniReceiver, error) {
+	request := []interface{}{
+		txid,
+		"*",
+	}
+
+	result, err := wm.OnmiClient.Call("omni_getsto", request)
+	if err != nil {
+		return nil, err
+	}
+
+	receivers := make([]*OmniReceiver, 0)
+	for i, r := range result.Get("recipients").Array() {
+		receivers = append(receivers, &OmniReceiver{
+			Output:  uint64(i),
+			Address: r.Get("address").String(),
+			Amount:  r.Get("amount").String(),
+		})
+	}
+
+	return receivers, nil
+}
+
 //GetOmniInfo
 func (wm *WalletManager) GetOmniInfo() (*gjson.Result, error) {
 
@@ -81,19 +107,29 @@ func (wm *WalletManager) GetOmniProperty(propertyId uint64) (*gjson.Result, erro
 
 //IsHaveOmniAssets 是否拥有Omni资产
 func (wm *WalletManager) IsHaveOmniAssets(address string) bool {
+	has, _ := wm.getOmniAssetsOfAddress(address)
+	return has
+}
+
+//getOmniAssetsOfAddress 通过节点查询地址是否拥有Omni资产
+func (wm *WalletManager) getOmniAssetsOfAddress(address string) (bool, error) {
 	request := []interface{}{
 		address,
 	}
 
 	result, err := wm.OnmiClient.Call("omni_getallbalancesforaddress", request)
 	if err != nil {
-		return false
+		//节点没有该地址的余额记录时返回错误
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
 	}
 
 	if result.IsArray() && len(result.Array()) > 0 {
-		return true
+		return true, nil
 	} else {
-		return false
+		return false, nil
 	}
 }
 
@@ -146,10 +182,15 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 
 	var tokenBalanceList []*openwallet.TokenBalance
 
+	//节点返回的余额已按资产精度换算，不可分割资产没有小数
+	propertyID := common.NewString(contract.Address).UInt64()
+	decimals := decoder.wm.omniPropertyDecimals(&contract)
+	if decimals != int32(contract.Decimals) {
+		decoder.wm.Log.Warningf("contract[%s] decimals: %d is not match omni property decimals: %d", contract.Address, contract.Decimals, decimals)
+	}
+
 	for i := 0; i < len(address); i++ {
-		propertyID := common.NewString(contract.Address).UInt64()
 		balance, err := decoder.wm.GetOmniBalance(propertyID, address[i])
-		balance = balance.Shift(decoder.wm.Decimal()).Shift(-int32(contract.Decimals))
 		if err != nil {
 			decoder.wm.Log.Errorf("get address[%v] omni token balance failed, err: %v", address[i], err)
 		}
@@ -159,8 +200,8 @@ func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.Sma
 			Balance: &openwallet.Balance{
 				Address:          address[i],
 				Symbol:           contract.Symbol,
-				Balance:          balance.String(),
-				ConfirmBalance:   balance.String(),
+				Balance:          balance.StringFixed(decimals),
+				ConfirmBalance:   balance.StringFixed(decimals),
 				UnconfirmBalance: "0",
 			},
 		}
@@ -0,0 +1,327 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultOmniHolderExpiry   = 1 * time.Hour
+	defaultOmniFuelInterval   = 10 * time.Minute
+	defaultOmniFuelPendingAge = 1 * time.Hour
+)
+
+//OmniHolder 地址是否持有Omni代币的缓存，扫块时更新，避免建单时逐个地址查询节点
+type OmniHolder struct {
+	Address    string `storm:"id"`
+	HasAssets  bool   `storm:"index"`
+	UpdateTime int64
+	Expiry     int64 //过期后重新查询节点，0则不过期
+}
+
+//SetOmniHolder 记录地址是否持有Omni代币，expiry为0则不过期
+func (wm *WalletManager) SetOmniHolder(address string, hasAssets bool, expiry time.Duration) error {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	holder := &OmniHolder{
+		Address:    address,
+		HasAssets:  hasAssets,
+		UpdateTime: now.Unix(),
+	}
+	if expiry > 0 {
+		holder.Expiry = now.Add(expiry).Unix()
+	}
+
+	return db.Save(holder)
+}
+
+//RemoveOmniHolder 删除地址的持有记录，下次使用时重新查询节点
+func (wm *WalletManager) RemoveOmniHolder(address string) error {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.DeleteStruct(&OmniHolder{Address: address})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//IsOmniHolder 地址是否持有Omni代币，优先使用缓存，缓存不存在或过期时查询节点，
+//查询失败视为持有，避免花费持币地址的utxo，且不缓存结果
+func (wm *WalletManager) IsOmniHolder(address string) bool {
+
+	db, err := wm.OpenLocalDB()
+	if err == nil {
+		var holder OmniHolder
+		err = db.One("Address", address, &holder)
+		if err == nil && (holder.Expiry == 0 || holder.Expiry > time.Now().Unix()) {
+			return holder.HasAssets
+		}
+	}
+
+	hasAssets, err := wm.getOmniAssetsOfAddress(address)
+	if err != nil {
+		wm.Log.Warningf("get omni assets of address: %s failed, treat as holder, %v", address, err)
+		return true
+	}
+
+	if err = wm.SetOmniHolder(address, hasAssets, defaultOmniHolderExpiry); err != nil {
+		wm.Log.Warningf("save omni holder: %s failed, %v", address, err)
+	}
+
+	return hasAssets
+}
+
+//GetOmniHolders 查询持有Omni代币的地址
+func (wm *WalletManager) GetOmniHolders() ([]*OmniHolder, error) {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var holders []*OmniHolder
+	err = db.Select(q.Eq("HasAssets", true)).Find(&holders)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return holders, nil
+}
+
+//OmniFuelObserver 补充手续费utxo交易单的观察者，由应用签名并广播
+type OmniFuelObserver interface {
+	OmniFuelTxNotify(rawTx *openwallet.RawTransaction) error
+}
+
+//OmniFuelManager 持币地址的手续费utxo管理，定时从热钱包账户给没有utxo的持币地址补充一个
+type OmniFuelManager struct {
+	FuelAmount decimal.Decimal //补充给持币地址的utxo金额
+	Interval   time.Duration   //定时执行的间隔
+
+	wm        *WalletManager
+	decoder   *TransactionDecoder
+	mu        sync.Mutex
+	wrapper   openwallet.WalletDAI
+	account   *openwallet.AssetsAccount
+	pending   map[string]time.Time //已创建补充交易单的地址，等待广播后出现utxo
+	observers map[OmniFuelObserver]bool
+	subs      *SubscriptionManager
+}
+
+//NewOmniFuelManager 创建持币地址的手续费utxo管理
+func NewOmniFuelManager(wm *WalletManager) *OmniFuelManager {
+	return &OmniFuelManager{
+		Interval:  defaultOmniFuelInterval,
+		wm:        wm,
+		decoder:   NewTransactionDecoder(wm),
+		pending:   make(map[string]time.Time),
+		observers: make(map[OmniFuelObserver]bool),
+		subs:      NewSubscriptionManager(wm.Log),
+	}
+}
+
+//SetFuelAccount 设置支付手续费utxo的热钱包账户
+func (m *OmniFuelManager) SetFuelAccount(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wrapper = wrapper
+	m.account = account
+}
+
+//AddObserver 添加观察者
+func (m *OmniFuelManager) AddObserver(obj OmniFuelObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if obj == nil {
+		return
+	}
+	m.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (m *OmniFuelManager) RemoveObserver(obj OmniFuelObserver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.observers, obj)
+}
+
+//Start 启动定时补充
+func (m *OmniFuelManager) Start() {
+	if m.subs.Running() {
+		return
+	}
+	m.subs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Run()
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+//Stop 停止定时补充
+func (m *OmniFuelManager) Stop() {
+	m.subs.Stop()
+}
+
+//fuelAmount 补充的utxo金额，默认为Omni转账最低成本
+func (m *OmniFuelManager) fuelAmount() decimal.Decimal {
+	if m.FuelAmount.GreaterThan(decimal.Zero) {
+		return m.FuelAmount
+	}
+	transferCost, _ := decimal.NewFromString(m.wm.Config.OmniTransferCost)
+	return transferCost
+}
+
+//NeedFuelAddresses 查询没有可用utxo的持币地址，已创建补充交易单的地址不重复返回
+func (m *OmniFuelManager) NeedFuelAddresses() ([]string, error) {
+
+	holders, err := m.wm.GetOmniHolders()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holders) == 0 {
+		return nil, nil
+	}
+
+	addresses := make([]string, 0, len(holders))
+	for _, h := range holders {
+		addresses = append(addresses, h.Address)
+	}
+
+	//一次查询所有持币地址的utxo，包括未确认的补充
+	unspents, err := m.wm.ListUnspent(0, addresses...)
+	if err != nil {
+		return nil, err
+	}
+
+	fueled := make(map[string]bool)
+	for _, u := range unspents {
+		fueled[u.Address] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	need := make([]string, 0)
+	for _, address := range addresses {
+		if fueled[address] {
+			delete(m.pending, address)
+			continue
+		}
+		if t, ok := m.pending[address]; ok && now.Sub(t) < defaultOmniFuelPendingAge {
+			continue
+		}
+		need = append(need, address)
+	}
+
+	return need, nil
+}
+
+//Run 执行一次补充，返回创建的交易单
+func (m *OmniFuelManager) Run() []*openwallet.RawTransactionWithError {
+
+	m.mu.Lock()
+	wrapper, account := m.wrapper, m.account
+	observers := make([]OmniFuelObserver, 0, len(m.observers))
+	for o := range m.observers {
+		observers = append(observers, o)
+	}
+	m.mu.Unlock()
+
+	if wrapper == nil || account == nil {
+		return nil
+	}
+
+	need, err := m.NeedFuelAddresses()
+	if err != nil {
+		m.wm.Log.Errorf("omni fuel find addresses failed unexpected error: %v", err)
+		return nil
+	}
+
+	if len(need) == 0 {
+		return nil
+	}
+
+	amount := m.fuelAmount()
+	if m.wm.IsDust(need[0], amount) {
+		m.wm.Log.Errorf("omni fuel amount: %s is dust", amount.String())
+		return nil
+	}
+
+	payouts := make([]*BatchPayout, 0, len(need))
+	for _, address := range need {
+		payouts = append(payouts, &BatchPayout{Address: address, Amount: amount.String()})
+	}
+
+	//补充不急于确认，使用economy优先级
+	rawTxArray, err := m.decoder.CreateBatchRawTransaction(wrapper, &BatchRawTransaction{
+		Coin:     openwallet.Coin{Symbol: m.wm.Symbol()},
+		Account:  account,
+		Payouts:  payouts,
+		Priority: FeePriorityEconomy,
+	})
+	if err != nil {
+		m.wm.Log.Errorf("omni fuel create transaction failed unexpected error: %v", err)
+		return nil
+	}
+
+	now := time.Now()
+	for _, rawTx := range rawTxArray {
+		if rawTx.Error != nil {
+			m.wm.Log.Warningf("omni fuel transaction failed: %s", rawTx.Error.Error())
+			continue
+		}
+
+		m.mu.Lock()
+		for address := range rawTx.RawTx.To {
+			m.pending[address] = now
+		}
+		m.mu.Unlock()
+
+		for _, o := range observers {
+			if notifyErr := o.OmniFuelTxNotify(rawTx.RawTx); notifyErr != nil {
+				m.wm.Log.Errorf("omni fuel notify failed unexpected error: %v", notifyErr)
+			}
+		}
+	}
+
+	return rawTxArray
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+func TestWalletManager_OmniHolder(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	wm.SetOmniHolder("holder", true, 0)
+	wm.SetOmniHolder("empty", false, time.Hour)
+	wm.SetOmniHolder("other", true, time.Hour)
+
+	if !wm.IsOmniHolder("holder") || wm.IsOmniHolder("empty") {
+		t.Errorf("cached omni holder is not used")
+	}
+
+	holders, err := wm.GetOmniHolders()
+	if err != nil {
+		t.Fatalf("get omni holders failed unexpected error: %v", err)
+	}
+	if len(holders) != 2 {
+		t.Errorf("omni holders: %d, want: 2", len(holders))
+	}
+
+	wm.RemoveOmniHolder("other")
+	holders, _ = wm.GetOmniHolders()
+	if len(holders) != 1 || holders[0].Address != "holder" {
+		t.Errorf("unexpected omni holders after remove: %+v", holders)
+	}
+}
+
+func TestWalletManager_OmniHolderRPCFailed(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":null,"error":{"code":-28,"message":"Loading block index..."},"id":"1"}`)
+	}))
+	defer server.Close()
+	wm.OnmiClient = NewClient(server.URL, "", false)
+
+	//查询失败视为持有
+	if !wm.IsOmniHolder("unknown") {
+		t.Errorf("address should be holder when rpc failed")
+	}
+
+	//失败结果不缓存
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		t.Fatalf("open local db failed unexpected error: %v", err)
+	}
+	var holder OmniHolder
+	if err = db.One("Address", "unknown", &holder); err != storm.ErrNotFound {
+		t.Errorf("failed result should not be cached, holder: %+v, err: %v", holder, err)
+	}
+}
@@ -58,6 +58,45 @@ type OmniTransaction struct {
 	BlockHash        string `json:"blockhash"`
 	Block            uint64 `json:"block"`
 	Confirmations    uint64 `json:"confirmations"`
+
+	SubSends  []*OmniSubSend  `json:"subsends"`  //Send All发送的各个资产
+	Receivers []*OmniReceiver `json:"receivers"` //Send To Many的接收地址，Send To Owners通过omni_getsto查询后填充
+	Purchases []*OmniPurchase `json:"purchases"` //DEx Purchase购买的资产
+
+	Action                     string `json:"action"`            //DEx Sell Offer的操作：new，update，cancel
+	TotalStoFee                string `json:"totalstofee"`       //Send To Owners的手续费
+	PropertyIdForSale          uint64 `json:"propertyidforsale"` //MetaDEx挂单出售的资产
+	PropertyIdForSaleDivisible bool   `json:"propertyidforsaleisdivisible"`
+	AmountForSale              string `json:"amountforsale"`
+	PurchasedPropertyId        uint64 `json:"purchasedpropertyid"` //Crowdsale Purchase获得的资产
+	PurchasedPropertyDivisible bool   `json:"purchasedpropertydivisible"`
+	PurchasedTokens            string `json:"purchasedtokens"` //参与者获得的数量
+	IssuerTokens               string `json:"issuertokens"`    //发行者获得的数量
+}
+
+//OmniPurchase DEx Purchase中一个卖单的成交
+type OmniPurchase struct {
+	Vout             uint64 `json:"vout"`
+	AmountPaid       string `json:"amountpaid"`
+	ReferenceAddress string `json:"referenceaddress"` //卖单地址
+	PropertyId       uint64 `json:"propertyid"`
+	AmountBought     string `json:"amountbought"`
+	Valid            bool   `json:"valid"`
+	Divisible        bool   `json:"-"` //节点不返回，由资产信息填充
+}
+
+//OmniSubSend Send All中一个资产的发送
+type OmniSubSend struct {
+	PropertyId uint64 `json:"propertyid"`
+	Divisible  bool   `json:"divisible"`
+	Amount     string `json:"amount"`
+}
+
+//OmniReceiver Send To Many的一个接收
+type OmniReceiver struct {
+	Output  uint64 `json:"output"`
+	Address string `json:"address"`
+	Amount  string `json:"amount"`
 }
 
 func NewOmniTx(json *gjson.Result) *OmniTransaction {
@@ -79,5 +118,43 @@ func NewOmniTx(json *gjson.Result) *OmniTransaction {
 	obj.BlockHash = gjson.Get(json.Raw, "blockhash").String()
 	obj.Block = gjson.Get(json.Raw, "block").Uint()
 	obj.Confirmations = gjson.Get(json.Raw, "confirmations").Uint()
+
+	for _, sub := range gjson.Get(json.Raw, "subsends").Array() {
+		obj.SubSends = append(obj.SubSends, &OmniSubSend{
+			PropertyId: sub.Get("propertyid").Uint(),
+			Divisible:  sub.Get("divisible").Bool(),
+			Amount:     sub.Get("amount").String(),
+		})
+	}
+
+	for _, r := range gjson.Get(json.Raw, "receivers").Array() {
+		obj.Receivers = append(obj.Receivers, &OmniReceiver{
+			Output:  r.Get("output").Uint(),
+			Address: r.Get("address").String(),
+			Amount:  r.Get("amount").String(),
+		})
+	}
+
+	for _, p := range gjson.Get(json.Raw, "purchases").Array() {
+		obj.Purchases = append(obj.Purchases, &OmniPurchase{
+			Vout:             p.Get("vout").Uint(),
+			AmountPaid:       p.Get("amountpaid").String(),
+			ReferenceAddress: p.Get("referenceaddress").String(),
+			PropertyId:       p.Get("propertyid").Uint(),
+			AmountBought:     p.Get("amountbought").String(),
+			Valid:            p.Get("valid").Bool(),
+		})
+	}
+
+	obj.Action = gjson.Get(json.Raw, "action").String()
+	obj.TotalStoFee = gjson.Get(json.Raw, "totalstofee").String()
+	obj.PropertyIdForSale = gjson.Get(json.Raw, "propertyidforsale").Uint()
+	obj.PropertyIdForSaleDivisible = gjson.Get(json.Raw, "propertyidforsaleisdivisible").Bool()
+	obj.AmountForSale = gjson.Get(json.Raw, "amountforsale").String()
+	obj.PurchasedPropertyId = gjson.Get(json.Raw, "purchasedpropertyid").Uint()
+	obj.PurchasedPropertyDivisible = gjson.Get(json.Raw, "purchasedpropertydivisible").Bool()
+	obj.PurchasedTokens = gjson.Get(json.Raw, "purchasedtokens").String()
+	obj.IssuerTokens = gjson.Get(json.Raw, "issuertokens").String()
+
 	return obj
 }
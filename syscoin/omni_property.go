@@ -0,0 +1,189 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	omniDivisibleDecimals     = 8              //可分割资产的精度
+	defaultOmniPropertyExpiry = 24 * time.Hour //资产信息的缓存时间
+)
+
+//OmniProperty Omni资产信息
+type OmniProperty struct {
+	PropertyID      uint64 `storm:"id"`
+	Name            string
+	Category        string
+	Subcategory     string
+	URL             string
+	Data            string
+	Divisible       bool
+	Issuer          string
+	CreationTxID    string
+	FixedIssuance   bool
+	ManagedIssuance bool
+	FreezingEnabled bool
+	TotalTokens     string
+	UpdateTime      int64
+}
+
+//NewOmniProperty 解析omni_getproperty的结果
+func NewOmniProperty(json *gjson.Result) *OmniProperty {
+	obj := &OmniProperty{}
+	obj.PropertyID = json.Get("propertyid").Uint()
+	obj.Name = json.Get("name").String()
+	obj.Category = json.Get("category").String()
+	obj.Subcategory = json.Get("subcategory").String()
+	obj.URL = json.Get("url").String()
+	obj.Data = json.Get("data").String()
+	obj.Divisible = json.Get("divisible").Bool()
+	obj.Issuer = json.Get("issuer").String()
+	obj.CreationTxID = json.Get("creationtxid").String()
+	obj.FixedIssuance = json.Get("fixedissuance").Bool()
+	obj.ManagedIssuance = json.Get("managedissuance").Bool()
+	obj.FreezingEnabled = json.Get("freezingenabled").Bool()
+	obj.TotalTokens = json.Get("totaltokens").String()
+	return obj
+}
+
+//Decimals 资产精度，可分割为8，不可分割为0
+func (p *OmniProperty) Decimals() int32 {
+	if p.Divisible {
+		return omniDivisibleDecimals
+	}
+	return 0
+}
+
+//FormatAmount 按资产精度格式化数量
+func (p *OmniProperty) FormatAmount(amount decimal.Decimal) string {
+	return amount.StringFixed(p.Decimals())
+}
+
+//ValidateAmount 检查数量是否超出资产精度，不可分割资产不能有小数
+func (p *OmniProperty) ValidateAmount(amount decimal.Decimal) error {
+	if !amount.Equal(amount.Truncate(p.Decimals())) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni property[%d] amount: %s is over decimals: %d", p.PropertyID, amount.String(), p.Decimals())
+	}
+	return nil
+}
+
+//omniPropertyCache 资产信息的内存缓存
+type omniPropertyCache struct {
+	mu         sync.RWMutex
+	properties map[uint64]*OmniProperty
+}
+
+func (c *omniPropertyCache) get(propertyID uint64) *OmniProperty {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.properties[propertyID]
+}
+
+func (c *omniPropertyCache) set(p *OmniProperty) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.properties == nil {
+		c.properties = make(map[uint64]*OmniProperty)
+	}
+	c.properties[p.PropertyID] = p
+}
+
+//isFresh 资产信息是否在缓存有效期内
+func (p *OmniProperty) isFresh() bool {
+	return time.Since(time.Unix(p.UpdateTime, 0)) < defaultOmniPropertyExpiry
+}
+
+//GetOmniPropertyInfo 获取Omni资产信息，依次使用内存缓存、本地数据库和节点
+func (wm *WalletManager) GetOmniPropertyInfo(propertyID uint64) (*OmniProperty, error) {
+
+	if p := wm.omniProperties.get(propertyID); p != nil && p.isFresh() {
+		return p, nil
+	}
+
+	db, dbErr := wm.OpenLocalDB()
+	if dbErr == nil {
+		var p OmniProperty
+		if err := db.One("PropertyID", propertyID, &p); err == nil && p.isFresh() {
+			wm.omniProperties.set(&p)
+			return &p, nil
+		}
+	}
+
+	result, err := wm.GetOmniProperty(propertyID)
+	if err != nil {
+		//节点不可用时使用过期的缓存
+		if p := wm.omniProperties.get(propertyID); p != nil {
+			return p, nil
+		}
+		return nil, err
+	}
+
+	p := NewOmniProperty(result)
+	p.PropertyID = propertyID
+	p.UpdateTime = time.Now().Unix()
+
+	if dbErr == nil {
+		if err = db.Save(p); err != nil {
+			wm.Log.Warningf("save omni property: %d failed, %v", propertyID, err)
+		}
+	}
+	wm.omniProperties.set(p)
+
+	return p, nil
+}
+
+//ValidateOmniContract 按资产信息检查合约定义，返回合约对应的资产
+func (wm *WalletManager) ValidateOmniContract(contract *openwallet.SmartContract) (*OmniProperty, error) {
+
+	if len(contract.Address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract address is empty")
+	}
+
+	propertyID := common.NewString(contract.Address).UInt64()
+	if propertyID == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract address: %s is not omni property id", contract.Address)
+	}
+
+	p, err := wm.GetOmniPropertyInfo(propertyID)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get omni property[%d] failed, %v", propertyID, err)
+	}
+
+	if int32(contract.Decimals) != p.Decimals() {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "contract[%s] decimals: %d is not match omni property decimals: %d", contract.Address, contract.Decimals, p.Decimals())
+	}
+
+	return p, nil
+}
+
+//omniPropertyDecimals 资产的精度，获取资产信息失败时使用合约定义的精度
+func (wm *WalletManager) omniPropertyDecimals(contract *openwallet.SmartContract) int32 {
+	propertyID := common.NewString(contract.Address).UInt64()
+	p, err := wm.GetOmniPropertyInfo(propertyID)
+	if err != nil {
+		wm.Log.Warningf("get omni property[%d] failed, use contract decimals, %v", propertyID, err)
+		return int32(contract.Decimals)
+	}
+	return p.Decimals()
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+func TestNewOmniProperty(t *testing.T) {
+	json := gjson.Parse(`{"propertyid":31,"name":"TetherUS","category":"Financial and insurance activities","divisible":true,"issuer":"32B7n7BoMBRx6BuM6Sd9SuudHbYgVkKvxL","totaltokens":"1000.00000000"}`)
+	p := NewOmniProperty(&json)
+	if p.PropertyID != 31 || p.Name != "TetherUS" || p.Decimals() != 8 || p.Issuer != "32B7n7BoMBRx6BuM6Sd9SuudHbYgVkKvxL" {
+		t.Errorf("unexpected omni property: %+v", p)
+	}
+	if p.FormatAmount(decimal.RequireFromString("1.5")) != "1.50000000" {
+		t.Errorf("divisible amount format is wrong")
+	}
+
+	indivisible := &OmniProperty{PropertyID: 3}
+	if indivisible.FormatAmount(decimal.RequireFromString("5")) != "5" {
+		t.Errorf("indivisible amount format is wrong")
+	}
+	if indivisible.ValidateAmount(decimal.RequireFromString("5")) != nil {
+		t.Errorf("indivisible integer amount should be valid")
+	}
+	if indivisible.ValidateAmount(decimal.RequireFromString("5.1")) == nil {
+		t.Errorf("indivisible fractional amount should be invalid")
+	}
+}
+
+func TestWalletManager_ValidateOmniContract(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		t.Fatalf("open local db failed unexpected error: %v", err)
+	}
+	db.Save(&OmniProperty{PropertyID: 31, Name: "TetherUS", Divisible: true, UpdateTime: time.Now().Unix()})
+	db.Save(&OmniProperty{PropertyID: 3, Name: "MaidSafeCoin", UpdateTime: time.Now().Unix()})
+
+	tests := []struct {
+		contract openwallet.SmartContract
+		ok       bool
+	}{
+		{openwallet.SmartContract{Address: "31", Decimals: 8}, true},
+		{openwallet.SmartContract{Address: "31", Decimals: 0}, false},
+		{openwallet.SmartContract{Address: "3", Decimals: 0}, true},
+		{openwallet.SmartContract{Address: "3", Decimals: 8}, false},
+		{openwallet.SmartContract{Address: ""}, false},
+	}
+
+	for _, test := range tests {
+		_, err := wm.ValidateOmniContract(&test.contract)
+		if (err == nil) != test.ok {
+			t.Errorf("contract: %s decimals: %d error: %v", test.contract.Address, test.contract.Decimals, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/blocktree/go-owcdrivers/omniTransaction"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+)
+
+//Omni生态
+const (
+	OmniEcosystemMain byte = 1
+	OmniEcosystemTest byte = 2
+
+	omniTestEcosystemStart = 2147483651 //测试生态资产编号的起始值
+)
+
+const (
+	//Send To Many的最大接收数量，OP_RETURN最多80字节：前缀4+头部9+每个接收9
+	maxOmniSendToManyReceivers = 7
+)
+
+//omniEcosystem 资产所属的生态，1和3~2147483650为主生态
+func omniEcosystem(propertyID uint64) byte {
+	if propertyID == 2 || propertyID >= omniTestEcosystemStart {
+		return OmniEcosystemTest
+	}
+	return OmniEcosystemMain
+}
+
+//OmniSendAllProperty Send All会发送的一种资产，记录在交易单扩展参数omniSendAllProperties
+type OmniSendAllProperty struct {
+	PropertyID uint64 `json:"propertyId"`
+	Balance    string `json:"balance"`
+}
+
+//getOmniSendAllProperties 查询Send All会发送的资产，即地址在资产所属生态持有的全部资产
+func (wm *WalletManager) getOmniSendAllProperties(address string, propertyID uint64) ([]*OmniSendAllProperty, error) {
+
+	result, err := wm.OnmiClient.Call("omni_getallbalancesforaddress", []interface{}{address})
+	if err != nil {
+		return nil, err
+	}
+
+	ecosystem := omniEcosystem(propertyID)
+	properties := make([]*OmniSendAllProperty, 0)
+	for _, b := range result.Array() {
+		id := b.Get("propertyid").Uint()
+		if omniEcosystem(id) != ecosystem {
+			continue
+		}
+		balance, _ := decimal.NewFromString(b.Get("balance").String())
+		if !balance.GreaterThan(decimal.Zero) {
+			continue
+		}
+		properties = append(properties, &OmniSendAllProperty{PropertyID: id, Balance: b.Get("balance").String()})
+	}
+
+	sort.Slice(properties, func(i, j int) bool {
+		return properties[i].PropertyID < properties[j].PropertyID
+	})
+
+	return properties, nil
+}
+
+//omniSendToManyOutput Send To Many的一个接收，Output为接收地址在交易中的输出序号
+type omniSendToManyOutput struct {
+	Output uint8
+	Amount uint64
+}
+
+//createPayloadSendToMany 创建Send To Many的OP_RETURN脚本
+func createPayloadSendToMany(propertyID uint32, outputs []omniSendToManyOutput) ([]byte, error) {
+
+	if len(outputs) == 0 || len(outputs) > maxOmniSendToManyReceivers {
+		return nil, fmt.Errorf("omni send to many receivers: %d is out of range [1, %d]", len(outputs), maxOmniSendToManyReceivers)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(omniTransaction.OmniPrefix[:])
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	binary.Write(&buf, binary.BigEndian, uint16(OmniTxTypeSendToMany))
+	binary.Write(&buf, binary.BigEndian, propertyID)
+	buf.WriteByte(uint8(len(outputs)))
+	for _, o := range outputs {
+		buf.WriteByte(o.Output)
+		binary.Write(&buf, binary.BigEndian, o.Amount)
+	}
+
+	payload := buf.Bytes()
+	return append([]byte{omniTransaction.OpReturn, byte(len(payload))}, payload...), nil
+}
+
+//replaceOmniPayload 替换空交易单中OP_RETURN输出的脚本，用于依赖库不支持的Omni交易类型
+func replaceOmniPayload(emptyTrans string, script []byte) (string, error) {
+
+	raw, err := hex.DecodeString(emptyTrans)
+	if err != nil {
+		return "", err
+	}
+
+	var msgTx wire.MsgTx
+	if err = msgTx.DeserializeNoWitness(bytes.NewReader(raw)); err != nil {
+		return "", err
+	}
+
+	replaced := false
+	for _, out := range msgTx.TxOut {
+		if len(out.PkScript) > 0 && out.PkScript[0] == omniTransaction.OpReturn {
+			out.PkScript = script
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		return "", fmt.Errorf("omni payload output not found")
+	}
+
+	var buf bytes.Buffer
+	if err = msgTx.SerializeNoWitness(&buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+//sortedOmniReceivers 接收地址按字母排序，保证Send To Many的输出序号确定
+func sortedOmniReceivers(omniTo map[string]string) []string {
+	receivers := make([]string, 0, len(omniTo))
+	for addr := range omniTo {
+		receivers = append(receivers, addr)
+	}
+	sort.Strings(receivers)
+	return receivers
+}
+
+//omniSendToManyOutputs 按接收地址的输出序号和数量创建Send To Many的接收列表
+func omniSendToManyOutputs(receivers []string, firstOutput int, omniTo map[string]string, decimals int32) ([]omniSendToManyOutput, error) {
+	outputs := make([]omniSendToManyOutput, 0, len(receivers))
+	for i, addr := range receivers {
+		amount, err := decimal.NewFromString(omniTo[addr])
+		if err != nil {
+			return nil, fmt.Errorf("invalid omni amount: %s of receiver: %s", omniTo[addr], addr)
+		}
+		outputs = append(outputs, omniSendToManyOutput{
+			Output: uint8(firstOutput + i),
+			Amount: uint64(amount.Shift(decimals).IntPart()),
+		})
+	}
+	return outputs, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/tidwall/gjson"
+)
+
+func TestCreatePayloadSendToMany(t *testing.T) {
+	payload, err := createPayloadSendToMany(31, []omniSendToManyOutput{{Output: 1, Amount: 100000000}, {Output: 2, Amount: 5}})
+	if err != nil {
+		t.Fatalf("create payload failed unexpected error: %v", err)
+	}
+	want := "6a1f" + "6f6d6e69" + "0000" + "0007" + "0000001f" + "02" +
+		"01" + "0000000005f5e100" + "02" + "0000000000000005"
+	if hex.EncodeToString(payload) != want {
+		t.Errorf("payload: %x, want: %s", payload, want)
+	}
+
+	outputs := make([]omniSendToManyOutput, maxOmniSendToManyReceivers+1)
+	if _, err = createPayloadSendToMany(31, outputs); err == nil {
+		t.Errorf("receivers over limit should be rejected")
+	}
+}
+
+func TestReplaceOmniPayload(t *testing.T) {
+	msgTx := wire.NewMsgTx(1)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(546, []byte{0x76, 0xa9}))
+	msgTx.AddTxOut(wire.NewTxOut(0, []byte{0x6a, 0x01, 0x00}))
+	var buf bytes.Buffer
+	msgTx.SerializeNoWitness(&buf)
+
+	replaced, err := replaceOmniPayload(hex.EncodeToString(buf.Bytes()), []byte{0x6a, 0x02, 0x01, 0x02})
+	if err != nil {
+		t.Fatalf("replace payload failed unexpected error: %v", err)
+	}
+
+	raw, _ := hex.DecodeString(replaced)
+	var result wire.MsgTx
+	result.DeserializeNoWitness(bytes.NewReader(raw))
+	if !bytes.Equal(result.TxOut[1].PkScript, []byte{0x6a, 0x02, 0x01, 0x02}) || !bytes.Equal(result.TxOut[0].PkScript, []byte{0x76, 0xa9}) {
+		t.Errorf("unexpected outputs after replace: %x, %x", result.TxOut[0].PkScript, result.TxOut[1].PkScript)
+	}
+}
+
+func TestNewOmniTransfers(t *testing.T) {
+	sendAll := gjson.Parse(`{"txid":"a","sendingaddress":"from","referenceaddress":"to","type_int":4,"type":"Send All","valid":true,
+		"subsends":[{"propertyid":31,"divisible":true,"amount":"1.50000000"},{"propertyid":3,"divisible":false,"amount":"7"}]}`)
+	transfers := newOmniTransfers(NewOmniTx(&sendAll))
+	if len(transfers) != 2 || transfers[1].PropertyID != 3 || transfers[1].Divisible || transfers[1].Receivers[0].Address != "to" {
+		t.Errorf("unexpected send all transfers")
+	}
+
+	sendToMany := gjson.Parse(`{"txid":"b","sendingaddress":"from","type_int":7,"type":"Send To Many","propertyid":31,"divisible":true,"valid":true,
+		"receivers":[{"output":1,"address":"r1","amount":"1.00000000"},{"output":2,"address":"r2","amount":"2.50000000"}]}`)
+	transfers = newOmniTransfers(NewOmniTx(&sendToMany))
+	if len(transfers) != 1 || transfers[0].Amount != "3.5" || len(transfers[0].Receivers) != 2 || transfers[0].Receivers[1].Output != 1 {
+		t.Errorf("unexpected send to many transfers: %+v", transfers[0])
+	}
+
+	simpleSend := gjson.Parse(`{"txid":"c","sendingaddress":"from","referenceaddress":"to","type_int":0,"propertyid":31,"divisible":true,"amount":"1.00000000"}`)
+	transfers = newOmniTransfers(NewOmniTx(&simpleSend))
+	if len(transfers) != 1 || transfers[0].Receivers[0].Address != "to" || transfers[0].Amount != "1.00000000" {
+		t.Errorf("unexpected simple send transfers")
+	}
+}
+
+func TestOmniEcosystem(t *testing.T) {
+	tests := map[uint64]byte{
+		1:          OmniEcosystemMain,
+		2:          OmniEcosystemTest,
+		31:         OmniEcosystemMain,
+		2147483651: OmniEcosystemTest,
+	}
+	for propertyID, want := range tests {
+		if got := omniEcosystem(propertyID); got != want {
+			t.Errorf("property: %d ecosystem: %d, want: %d", propertyID, got, want)
+		}
+	}
+}
+
+func TestWalletManager_GetOmniSendAllProperties(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"result":[
+			{"propertyid":31,"balance":"10.5","reserved":"0"},
+			{"propertyid":3,"balance":"2","reserved":"0"},
+			{"propertyid":5,"balance":"0","reserved":"1"},
+			{"propertyid":2147483651,"balance":"7","reserved":"0"}
+		],"error":null,"id":"1"}`)
+	}))
+	defer server.Close()
+
+	wm := NewWalletManager()
+	wm.OnmiClient = NewClient(server.URL, "", false)
+
+	//同一生态有余额的资产都会被发送，其它生态不受影响
+	properties, err := wm.getOmniSendAllProperties("address", 31)
+	if err != nil {
+		t.Fatalf("getOmniSendAllProperties failed unexpected error: %v", err)
+	}
+	if len(properties) != 2 || properties[0].PropertyID != 3 || properties[1].PropertyID != 31 || properties[1].Balance != "10.5" {
+		t.Errorf("unexpected send all properties: %+v", properties)
+	}
+}
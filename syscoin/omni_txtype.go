@@ -0,0 +1,248 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+//Omni交易类型，与节点返回的type_int一致
+const (
+	OmniTxTypeSimpleSend             = 0
+	OmniTxTypeSendToOwners           = 3
+	OmniTxTypeSendAll                = 4
+	OmniTxTypeSendToMany             = 7
+	OmniTxTypeDExSellOffer           = 20
+	OmniTxTypeDExAccept              = 22
+	OmniTxTypeMetaDExTrade           = 25
+	OmniTxTypeMetaDExCancelPrice     = 26
+	OmniTxTypeMetaDExCancelPair      = 27
+	OmniTxTypeMetaDExCancelEcosystem = 28
+	OmniTxTypeCreatePropertyFixed    = 50
+	OmniTxTypeCreatePropertyVariable = 51
+	OmniTxTypeCloseCrowdsale         = 53
+	OmniTxTypeCreatePropertyManual   = 54
+	OmniTxTypeGrant                  = 55
+	OmniTxTypeRevoke                 = 56
+	OmniTxTypeChangeIssuer           = 70
+	OmniTxTypeEnableFreezing         = 71
+	OmniTxTypeDisableFreezing        = 72
+	OmniTxTypeFreeze                 = 185
+	OmniTxTypeUnfreeze               = 186
+
+	//节点没有类型编号的交易，按类型名称识别，使用超出协议范围(uint16)的编号
+	OmniTxTypeDExPurchase       = 65536
+	OmniTxTypeCrowdsalePurchase = 65537
+)
+
+const (
+	//OmniTxTypeBase 非转账类Omni交易通知的TxType为OmniTxTypeBase加Omni交易类型
+	OmniTxTypeBase = 1000
+)
+
+//omniTxTypeOf 识别Omni交易类型
+func omniTxTypeOf(trx *OmniTransaction) int {
+	switch trx.TypeStr {
+	case "DEx Purchase":
+		return OmniTxTypeDExPurchase
+	case "Crowdsale Purchase":
+		return OmniTxTypeCrowdsalePurchase
+	}
+	return int(trx.TypeInt)
+}
+
+//omniNotifyTxType 交易通知的TxType，资产转账为0，其他类型为自定义类型，类型名称记录在TxAction
+func omniNotifyTxType(omniType int) uint64 {
+	switch omniType {
+	case OmniTxTypeSimpleSend, OmniTxTypeSendToOwners, OmniTxTypeSendAll, OmniTxTypeSendToMany:
+		return 0
+	}
+	return uint64(OmniTxTypeBase + omniType)
+}
+
+//omniTransfer Omni交易中一个资产的余额变化
+type omniTransfer struct {
+	TxType     int //Omni交易类型
+	PropertyID uint64
+	Divisible  bool
+	From       string          //减少可用余额的地址，为空则没有
+	Amount     string          //From减少的数量
+	Receivers  []*OmniReceiver //增加可用余额的地址，Output为通知的输出序号
+	Parties    []string        //可用余额不变的相关地址，例如冻结的地址、新的发行者
+}
+
+//isPositiveAmount 数量是否大于0
+func isPositiveAmount(amount string) bool {
+	value, err := decimal.NewFromString(amount)
+	return err == nil && value.GreaterThan(decimal.Zero)
+}
+
+//newOmniTransfers 按交易类型拆分Omni交易的余额变化，
+//挂单(DEx Sell Offer，MetaDEx)冻结的资产不计入可用余额，所以挂单时减少，撤单时增加
+func newOmniTransfers(trx *OmniTransaction) []*omniTransfer {
+
+	var (
+		txType    = omniTxTypeOf(trx)
+		transfers = make([]*omniTransfer, 0)
+	)
+
+	//当前资产的余额变化
+	transferOf := func(from, amount string, receivers []*OmniReceiver, parties ...string) *omniTransfer {
+		return &omniTransfer{
+			TxType:     txType,
+			PropertyID: trx.PropertyId,
+			Divisible:  trx.Divisible,
+			From:       from,
+			Amount:     amount,
+			Receivers:  receivers,
+			Parties:    parties,
+		}
+	}
+
+	switch txType {
+	case OmniTxTypeSendToOwners:
+		//发送地址支付持有者的分配总量和OMNI手续费
+		receivers := make([]*OmniReceiver, 0, len(trx.Receivers))
+		for i, r := range trx.Receivers {
+			receivers = append(receivers, &OmniReceiver{Output: uint64(i), Address: r.Address, Amount: r.Amount})
+		}
+		transfer := transferOf(trx.SendingAddress, trx.Amount, receivers)
+		transfers = append(transfers, transfer)
+		if isPositiveAmount(trx.TotalStoFee) {
+			feeProperty := uint64(omniEcosystem(trx.PropertyId))
+			if feeProperty == trx.PropertyId {
+				amount, _ := decimal.NewFromString(trx.Amount)
+				fee, _ := decimal.NewFromString(trx.TotalStoFee)
+				transfer.Amount = amount.Add(fee).String()
+			} else {
+				transfers = append(transfers, &omniTransfer{
+					TxType:     txType,
+					PropertyID: feeProperty,
+					Divisible:  true,
+					From:       trx.SendingAddress,
+					Amount:     trx.TotalStoFee,
+				})
+			}
+		}
+	case OmniTxTypeSendAll:
+		//Send All的各个资产都发送到参考地址
+		for _, sub := range trx.SubSends {
+			transfers = append(transfers, &omniTransfer{
+				TxType:     txType,
+				PropertyID: sub.PropertyId,
+				Divisible:  sub.Divisible,
+				From:       trx.SendingAddress,
+				Amount:     sub.Amount,
+				Receivers:  []*OmniReceiver{{Address: trx.ReferenceAddress, Amount: sub.Amount}},
+			})
+		}
+	case OmniTxTypeSendToMany:
+		total := decimal.Zero
+		receivers := make([]*OmniReceiver, 0, len(trx.Receivers))
+		for i, r := range trx.Receivers {
+			amount, _ := decimal.NewFromString(r.Amount)
+			total = total.Add(amount)
+			receivers = append(receivers, &OmniReceiver{Output: uint64(i), Address: r.Address, Amount: r.Amount})
+		}
+		transfers = append(transfers, transferOf(trx.SendingAddress, total.String(), receivers))
+	case OmniTxTypeDExSellOffer:
+		switch trx.Action {
+		case "new":
+			transfers = append(transfers, transferOf(trx.SendingAddress, trx.Amount, nil))
+		case "cancel":
+			transfers = append(transfers, transferOf("", "", []*OmniReceiver{{Address: trx.SendingAddress, Amount: trx.Amount}}))
+		default:
+			//更新挂单的余额变化取决于原挂单数量，节点不返回
+			transfers = append(transfers, transferOf("", "", nil, trx.SendingAddress))
+		}
+	case OmniTxTypeDExAccept:
+		//接受挂单只锁定卖单，付款后才转移资产
+		transfers = append(transfers, transferOf("", "", nil, trx.SendingAddress, trx.ReferenceAddress))
+	case OmniTxTypeDExPurchase:
+		//卖单冻结的资产转给付款地址，按资产合并
+		byProperty := make(map[uint64]*omniTransfer)
+		for _, p := range trx.Purchases {
+			if !p.Valid {
+				continue
+			}
+			transfer := byProperty[p.PropertyId]
+			if transfer == nil {
+				transfer = &omniTransfer{
+					TxType:     txType,
+					PropertyID: p.PropertyId,
+					Divisible:  p.Divisible,
+				}
+				byProperty[p.PropertyId] = transfer
+				transfers = append(transfers, transfer)
+			}
+			transfer.Receivers = append(transfer.Receivers, &OmniReceiver{Output: p.Vout, Address: trx.SendingAddress, Amount: p.AmountBought})
+			transfer.Parties = append(transfer.Parties, p.ReferenceAddress)
+		}
+	case OmniTxTypeCrowdsalePurchase:
+		//参与者支付给发行者，并获得众筹资产，发行者也可能获得一部分众筹资产
+		transfers = append(transfers, transferOf(trx.SendingAddress, trx.Amount, []*OmniReceiver{{Address: trx.ReferenceAddress, Amount: trx.Amount}}))
+		purchased := []*OmniReceiver{{Output: 0, Address: trx.SendingAddress, Amount: trx.PurchasedTokens}}
+		if isPositiveAmount(trx.IssuerTokens) {
+			purchased = append(purchased, &OmniReceiver{Output: 1, Address: trx.ReferenceAddress, Amount: trx.IssuerTokens})
+		}
+		transfers = append(transfers, &omniTransfer{
+			TxType:     txType,
+			PropertyID: trx.PurchasedPropertyId,
+			Divisible:  trx.PurchasedPropertyDivisible,
+			Receivers:  purchased,
+		})
+	case OmniTxTypeMetaDExTrade:
+		transfers = append(transfers, &omniTransfer{
+			TxType:     txType,
+			PropertyID: trx.PropertyIdForSale,
+			Divisible:  trx.PropertyIdForSaleDivisible,
+			From:       trx.SendingAddress,
+			Amount:     trx.AmountForSale,
+		})
+	case OmniTxTypeMetaDExCancelPrice, OmniTxTypeMetaDExCancelPair:
+		//撤单返还的数量取决于未成交的挂单，节点交易详情不返回
+		if trx.PropertyIdForSale > 0 {
+			transfers = append(transfers, &omniTransfer{
+				TxType:     txType,
+				PropertyID: trx.PropertyIdForSale,
+				Divisible:  trx.PropertyIdForSaleDivisible,
+				Parties:    []string{trx.SendingAddress},
+			})
+		}
+	case OmniTxTypeCreatePropertyFixed:
+		transfers = append(transfers, transferOf("", "", []*OmniReceiver{{Address: trx.SendingAddress, Amount: trx.Amount}}))
+	case OmniTxTypeGrant:
+		//增发到参考地址，没有参考地址则增发给发行者
+		to := trx.ReferenceAddress
+		if len(to) == 0 {
+			to = trx.SendingAddress
+		}
+		transfers = append(transfers, transferOf("", "", []*OmniReceiver{{Address: to, Amount: trx.Amount}}))
+	case OmniTxTypeRevoke:
+		transfers = append(transfers, transferOf(trx.SendingAddress, trx.Amount, nil))
+	case OmniTxTypeCreatePropertyVariable, OmniTxTypeCreatePropertyManual, OmniTxTypeCloseCrowdsale,
+		OmniTxTypeEnableFreezing, OmniTxTypeDisableFreezing:
+		transfers = append(transfers, transferOf("", "", nil, trx.SendingAddress))
+	case OmniTxTypeChangeIssuer, OmniTxTypeFreeze, OmniTxTypeUnfreeze:
+		transfers = append(transfers, transferOf("", "", nil, trx.SendingAddress, trx.ReferenceAddress))
+	case OmniTxTypeMetaDExCancelEcosystem:
+		//撤销整个生态的挂单，无法对应到单个资产
+	default:
+		transfers = append(transfers, transferOf(trx.SendingAddress, trx.Amount, []*OmniReceiver{{Address: trx.ReferenceAddress, Amount: trx.Amount}}))
+	}
+
+	return transfers
+}
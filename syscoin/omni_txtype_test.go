@@ -0,0 +1,126 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func parseOmniTransfers(raw string) []*omniTransfer {
+	json := gjson.Parse(raw)
+	return newOmniTransfers(NewOmniTx(&json))
+}
+
+func TestOmniTxTypeOf(t *testing.T) {
+	tests := map[string]int{
+		`{"type_int":0,"type":"Simple Send"}`:              OmniTxTypeSimpleSend,
+		`{"type_int":0,"type":"Crowdsale Purchase"}`:       OmniTxTypeCrowdsalePurchase,
+		`{"type":"DEx Purchase"}`:                          OmniTxTypeDExPurchase,
+		`{"type_int":185,"type":"Freeze Property Tokens"}`: OmniTxTypeFreeze,
+	}
+	for raw, want := range tests {
+		json := gjson.Parse(raw)
+		if got := omniTxTypeOf(NewOmniTx(&json)); got != want {
+			t.Errorf("%s type: %d, want: %d", raw, got, want)
+		}
+	}
+
+	if omniNotifyTxType(OmniTxTypeSendToMany) != 0 || omniNotifyTxType(OmniTxTypeGrant) != OmniTxTypeBase+55 {
+		t.Errorf("unexpected notify tx type")
+	}
+}
+
+func TestNewOmniTransfersIssuance(t *testing.T) {
+	grant := parseOmniTransfers(`{"sendingaddress":"issuer","referenceaddress":"to","type_int":55,"propertyid":31,"divisible":true,"amount":"10.00000000"}`)
+	if len(grant) != 1 || grant[0].From != "" || grant[0].Receivers[0].Address != "to" || grant[0].TxType != OmniTxTypeGrant {
+		t.Errorf("unexpected grant transfers")
+	}
+
+	grant = parseOmniTransfers(`{"sendingaddress":"issuer","type_int":55,"propertyid":31,"divisible":true,"amount":"10.00000000"}`)
+	if len(grant) != 1 || grant[0].Receivers[0].Address != "issuer" {
+		t.Errorf("grant without reference address should be issued to sender")
+	}
+
+	revoke := parseOmniTransfers(`{"sendingaddress":"issuer","type_int":56,"propertyid":31,"divisible":true,"amount":"3.00000000"}`)
+	if len(revoke) != 1 || revoke[0].From != "issuer" || revoke[0].Amount != "3.00000000" || len(revoke[0].Receivers) != 0 {
+		t.Errorf("unexpected revoke transfers")
+	}
+
+	fixed := parseOmniTransfers(`{"sendingaddress":"issuer","type_int":50,"propertyid":40,"divisible":false,"amount":"1000"}`)
+	if len(fixed) != 1 || fixed[0].From != "" || fixed[0].Receivers[0].Address != "issuer" || fixed[0].Receivers[0].Amount != "1000" {
+		t.Errorf("unexpected create property transfers")
+	}
+
+	freeze := parseOmniTransfers(`{"sendingaddress":"issuer","referenceaddress":"holder","type_int":185,"propertyid":31,"amount":"0"}`)
+	if len(freeze) != 1 || freeze[0].From != "" || len(freeze[0].Receivers) != 0 || len(freeze[0].Parties) != 2 || freeze[0].Parties[1] != "holder" {
+		t.Errorf("freeze should not change balances: %+v", freeze[0])
+	}
+}
+
+func TestNewOmniTransfersDEx(t *testing.T) {
+	offer := parseOmniTransfers(`{"sendingaddress":"seller","type_int":20,"propertyid":1,"divisible":true,"amount":"2.00000000","action":"new"}`)
+	if len(offer) != 1 || offer[0].From != "seller" || offer[0].Amount != "2.00000000" {
+		t.Errorf("new offer should reserve the amount of seller")
+	}
+
+	offer = parseOmniTransfers(`{"sendingaddress":"seller","type_int":20,"propertyid":1,"divisible":true,"amount":"2.00000000","action":"cancel"}`)
+	if len(offer) != 1 || offer[0].From != "" || offer[0].Receivers[0].Address != "seller" {
+		t.Errorf("cancel offer should release the amount of seller")
+	}
+
+	accept := parseOmniTransfers(`{"sendingaddress":"buyer","referenceaddress":"seller","type_int":22,"propertyid":1,"amount":"1.00000000"}`)
+	if len(accept) != 1 || accept[0].From != "" || len(accept[0].Receivers) != 0 || len(accept[0].Parties) != 2 {
+		t.Errorf("accept offer should not change balances")
+	}
+
+	purchase := parseOmniTransfers(`{"txid":"p","sendingaddress":"buyer","type":"DEx Purchase","valid":true,"purchases":[
+		{"vout":1,"amountpaid":"0.1","referenceaddress":"s1","propertyid":1,"amountbought":"1.00000000","valid":true},
+		{"vout":2,"amountpaid":"0.1","referenceaddress":"s2","propertyid":1,"amountbought":"0.50000000","valid":true},
+		{"vout":3,"amountpaid":"0.1","referenceaddress":"s3","propertyid":3,"amountbought":"9","valid":false}]}`)
+	if len(purchase) != 1 || purchase[0].From != "" || len(purchase[0].Receivers) != 2 || purchase[0].Receivers[1].Output != 2 ||
+		purchase[0].Receivers[0].Address != "buyer" || len(purchase[0].Parties) != 2 || purchase[0].TxType != OmniTxTypeDExPurchase {
+		t.Errorf("unexpected dex purchase transfers")
+	}
+
+	trade := parseOmniTransfers(`{"sendingaddress":"trader","type_int":25,"propertyidforsale":31,"propertyidforsaleisdivisible":true,"amountforsale":"5.00000000","propertyiddesired":1}`)
+	if len(trade) != 1 || trade[0].PropertyID != 31 || !trade[0].Divisible || trade[0].From != "trader" || trade[0].Amount != "5.00000000" {
+		t.Errorf("unexpected metadex trade transfers")
+	}
+}
+
+func TestNewOmniTransfersCrowdsaleAndSTO(t *testing.T) {
+	crowdsale := parseOmniTransfers(`{"sendingaddress":"buyer","referenceaddress":"issuer","type_int":0,"type":"Crowdsale Purchase","propertyid":1,"divisible":true,"amount":"1.00000000",
+		"purchasedpropertyid":40,"purchasedpropertydivisible":false,"purchasedtokens":"100","issuertokens":"10"}`)
+	if len(crowdsale) != 2 || crowdsale[0].From != "buyer" || crowdsale[0].Receivers[0].Address != "issuer" {
+		t.Errorf("unexpected crowdsale payment transfer")
+	}
+	if crowdsale[1].PropertyID != 40 || crowdsale[1].Divisible || crowdsale[1].From != "" || len(crowdsale[1].Receivers) != 2 || crowdsale[1].Receivers[1].Amount != "10" {
+		t.Errorf("unexpected crowdsale purchased transfer")
+	}
+
+	sto := parseOmniTransfers(`{"sendingaddress":"from","type_int":3,"propertyid":31,"divisible":true,"amount":"3.00000000","totalstofee":"0.00000002",
+		"receivers":[{"address":"r1","amount":"1.00000000"},{"address":"r2","amount":"2.00000000"}]}`)
+	if len(sto) != 2 || len(sto[0].Receivers) != 2 || sto[0].Receivers[1].Output != 1 || sto[1].PropertyID != 1 || sto[1].From != "from" || sto[1].Amount != "0.00000002" {
+		t.Errorf("unexpected send to owners transfers")
+	}
+
+	sto = parseOmniTransfers(`{"sendingaddress":"from","type_int":3,"propertyid":1,"divisible":true,"amount":"3.00000000","totalstofee":"0.00000002"}`)
+	if len(sto) != 1 || sto[0].Amount != "3.00000002" {
+		t.Errorf("send to owners fee of the same property should be added to the sent amount")
+	}
+}
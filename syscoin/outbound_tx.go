@@ -0,0 +1,524 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//广播交易单的跟踪状态
+const (
+	OutboundTxStatusSubmitted = "submitted" //已广播，等待内存池确认
+	OutboundTxStatusMempool   = "mempool"   //在内存池中
+	OutboundTxStatusConfirmed = "confirmed" //已被打包
+	OutboundTxStatusDropped   = "dropped"   //从内存池消失，等待重新广播
+	OutboundTxStatusReplaced  = "replaced"  //输入被其它交易花费
+	OutboundTxStatusFailed    = "failed"    //多次重新广播失败，停止跟踪
+)
+
+const (
+	defaultOutboundTrackInterval  = 1 * time.Minute
+	defaultOutboundRebroadcast    = 10 * time.Minute
+	defaultOutboundMaxRebroadcast = 10
+	defaultOutboundConfirmations  = 6
+	defaultOutboundRetention      = 7 * 24 * time.Hour
+	outboundPruneInterval         = 1 * time.Hour
+)
+
+//outboundTrackingStatus 需要继续跟踪的状态，已确认的交易单未达到确认数前也要跟踪
+var outboundTrackingStatus = []string{
+	OutboundTxStatusSubmitted,
+	OutboundTxStatusMempool,
+	OutboundTxStatusDropped,
+	OutboundTxStatusConfirmed,
+}
+
+//OutboundTx 适配器广播的交易单记录
+type OutboundTx struct {
+	TxID          string `storm:"id"`
+	AccountID     string `storm:"index"`
+	RawHex        string
+	Inputs        []string //花费的输出，格式：txid:vout
+	Status        string   `storm:"index"`
+	Confirmations uint64
+	BlockHash     string
+	BlockHeight   uint64
+	ReplacedBy    string //替换或双花的交易id，可能未知
+	Rebroadcasts  int    //重新广播的次数
+	LastError     string //最后一次重新广播失败的原因
+	SubmitTime    int64
+	LastBroadcast int64
+	LastChecked   int64
+}
+
+//IsFinal 是否已结束跟踪
+func (tx *OutboundTx) IsFinal(confirmations uint64) bool {
+	switch tx.Status {
+	case OutboundTxStatusReplaced, OutboundTxStatusFailed:
+		return true
+	case OutboundTxStatusConfirmed:
+		return tx.Confirmations >= confirmations
+	}
+	return false
+}
+
+//OutboundTxEvent 广播交易单的状态变化
+type OutboundTxEvent struct {
+	TxID          string
+	AccountID     string
+	Status        string
+	PrevStatus    string
+	Confirmations uint64
+	BlockHash     string
+	BlockHeight   uint64
+	ReplacedBy    string
+	Rebroadcasts  int
+}
+
+//OutboundTxObserver 广播交易单状态变化的观察者
+type OutboundTxObserver interface {
+
+	//OutboundTxStatusNotify 广播交易单状态变化通知
+	OutboundTxStatusNotify(event *OutboundTxEvent) error
+}
+
+//OutboundTracker 广播交易单的跟踪，定时检查内存池接受、确认、消失或被替换，消失的交易自动重新广播
+type OutboundTracker struct {
+	Interval            time.Duration //定时检查的间隔
+	RebroadcastInterval time.Duration //消失的交易重新广播的间隔
+	MaxRebroadcasts     int           //最多重新广播的次数
+	Confirmations       uint64        //达到该确认数后结束跟踪
+	Retention           time.Duration //结束跟踪的交易单保留时间，过期后删除
+
+	wm        *WalletManager
+	mu        sync.Mutex
+	observers map[OutboundTxObserver]bool
+	subs      *SubscriptionManager
+	lastPrune time.Time
+}
+
+//NewOutboundTracker 创建广播交易单跟踪
+func NewOutboundTracker(wm *WalletManager) *OutboundTracker {
+	return &OutboundTracker{
+		Interval:            defaultOutboundTrackInterval,
+		RebroadcastInterval: defaultOutboundRebroadcast,
+		MaxRebroadcasts:     defaultOutboundMaxRebroadcast,
+		Confirmations:       defaultOutboundConfirmations,
+		Retention:           defaultOutboundRetention,
+		wm:                  wm,
+		observers:           make(map[OutboundTxObserver]bool),
+		subs:                NewSubscriptionManager(wm.Log),
+	}
+}
+
+//AddObserver 添加观察者
+func (t *OutboundTracker) AddObserver(obj OutboundTxObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if obj == nil {
+		return
+	}
+	t.observers[obj] = true
+}
+
+//RemoveObserver 移除观察者
+func (t *OutboundTracker) RemoveObserver(obj OutboundTxObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.observers, obj)
+}
+
+//Start 启动定时检查
+func (t *OutboundTracker) Start() {
+	if t.subs.Running() {
+		return
+	}
+	t.subs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.Check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+//Stop 停止定时检查
+func (t *OutboundTracker) Stop() {
+	t.subs.Stop()
+}
+
+//Track 跟踪已广播的交易单
+func (t *OutboundTracker) Track(rawTx *openwallet.RawTransaction) error {
+
+	now := time.Now().Unix()
+	tx := &OutboundTx{
+		TxID:          rawTx.TxID,
+		RawHex:        rawTx.RawHex,
+		Inputs:        rawTxOutpoints(rawTx.RawHex),
+		Status:        OutboundTxStatusSubmitted,
+		SubmitTime:    now,
+		LastBroadcast: now,
+	}
+	if rawTx.Account != nil {
+		tx.AccountID = rawTx.Account.AccountID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.wm.saveOutboundTx(tx)
+}
+
+//Pending 跟踪中的交易单
+func (t *OutboundTracker) Pending() ([]*OutboundTx, error) {
+	db, err := t.wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*OutboundTx, 0)
+	for _, status := range outboundTrackingStatus {
+		var list []*OutboundTx
+		err = db.Find("Status", status, &list)
+		if err != nil {
+			if err == storm.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		for _, tx := range list {
+			if !tx.IsFinal(t.Confirmations) {
+				pending = append(pending, tx)
+			}
+		}
+	}
+	return pending, nil
+}
+
+//Prune 删除结束跟踪超过保留时间的交易单记录，返回删除的数量
+func (t *OutboundTracker) Prune(now time.Time) (int, error) {
+	db, err := t.wm.OpenLocalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := now.Add(-t.Retention).Unix()
+	pruned := 0
+	for _, status := range []string{OutboundTxStatusConfirmed, OutboundTxStatusReplaced, OutboundTxStatusFailed} {
+		var list []*OutboundTx
+		err = db.Find("Status", status, &list)
+		if err != nil {
+			if err == storm.ErrNotFound {
+				continue
+			}
+			return pruned, err
+		}
+		for _, tx := range list {
+			if !tx.IsFinal(t.Confirmations) {
+				continue
+			}
+			//结束跟踪后不再检查，最后检查时间即结束跟踪的时间
+			finalTime := tx.LastChecked
+			if finalTime == 0 {
+				finalTime = tx.SubmitTime
+			}
+			if finalTime > expired {
+				continue
+			}
+			if err = db.DeleteStruct(tx); err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+//Check 检查所有跟踪中交易单的最新状态
+func (t *OutboundTracker) Check() {
+
+	pending, err := t.Pending()
+	if err != nil {
+		t.wm.Log.Errorf("outbound tracker load txs failed unexpected error: %v", err)
+		return
+	}
+
+	for _, tx := range pending {
+		t.check(tx.TxID)
+	}
+
+	now := time.Now()
+	if t.Retention > 0 && now.Sub(t.lastPrune) >= outboundPruneInterval {
+		t.lastPrune = now
+		if _, err := t.Prune(now); err != nil {
+			t.wm.Log.Errorf("outbound tracker prune txs failed unexpected error: %v", err)
+		}
+	}
+}
+
+//check 查询交易单的最新状态，查询不到时重新广播
+func (t *OutboundTracker) check(txid string) {
+
+	trx, findErr := t.wm.GetTransaction(txid)
+
+	t.mu.Lock()
+
+	tx, err := t.wm.GetOutboundTx(txid)
+	if err != nil || tx == nil || tx.IsFinal(t.Confirmations) {
+		t.mu.Unlock()
+		return
+	}
+
+	prev := *tx
+	now := time.Now()
+	tx.LastChecked = now.Unix()
+
+	if findErr == nil && trx != nil {
+		if trx.BlockHeight > 0 || len(trx.BlockHash) > 0 {
+			tx.Status = OutboundTxStatusConfirmed
+			tx.Confirmations = trx.Confirmations
+			tx.BlockHash = trx.BlockHash
+			tx.BlockHeight = trx.BlockHeight
+		} else {
+			tx.Status = OutboundTxStatusMempool
+		}
+	} else if t.shouldRebroadcast(&prev, now) {
+		t.rebroadcast(tx, now)
+	}
+
+	t.save(tx)
+
+	t.mu.Unlock()
+
+	t.notify(&prev, tx)
+}
+
+//shouldRebroadcast 查询不到交易时是否重新广播，已确认的交易查询失败可能是暂时的，不重新广播，
+//刚广播的交易首次查询不到时可能节点还没有索引，等下次检查
+func (t *OutboundTracker) shouldRebroadcast(tx *OutboundTx, now time.Time) bool {
+	switch tx.Status {
+	case OutboundTxStatusSubmitted:
+		return tx.LastChecked > 0
+	case OutboundTxStatusMempool:
+		return true
+	case OutboundTxStatusDropped:
+		return now.Sub(time.Unix(tx.LastBroadcast, 0)) >= t.RebroadcastInterval
+	}
+	return false
+}
+
+//rebroadcast 重新广播查询不到的交易单，调用前需要加锁
+func (t *OutboundTracker) rebroadcast(tx *OutboundTx, now time.Time) {
+
+	if tx.Rebroadcasts >= t.MaxRebroadcasts {
+		tx.Status = OutboundTxStatusFailed
+		return
+	}
+
+	tx.Rebroadcasts++
+	tx.LastBroadcast = now.Unix()
+
+	_, err := t.wm.SendRawTransaction(tx.RawHex)
+	if err == nil {
+		t.wm.Log.Infof("outbound tx: %s has been rebroadcast", tx.TxID)
+		tx.Status = OutboundTxStatusMempool
+		tx.LastError = ""
+		return
+	}
+
+	tx.LastError = err.Error()
+	switch {
+	case isTxAlreadyInChainError(err):
+		//区块信息在下次查询时更新
+		tx.Status = OutboundTxStatusConfirmed
+	case isTxAlreadyKnownError(err):
+		tx.Status = OutboundTxStatusMempool
+	case isTxInputsSpentError(err):
+		tx.Status = OutboundTxStatusReplaced
+	default:
+		t.wm.Log.Warningf("outbound tx: %s rebroadcast failed, %v", tx.TxID, err)
+		tx.Status = OutboundTxStatusDropped
+	}
+}
+
+//MempoolTxStatusNotify 实现MempoolTxObserver，内存池跟踪发现交易被替换、双花或确认
+func (t *OutboundTracker) MempoolTxStatusNotify(event *MempoolTxEvent) error {
+
+	t.mu.Lock()
+
+	tx, err := t.wm.GetOutboundTx(event.TxID)
+	if err != nil || tx == nil || tx.IsFinal(t.Confirmations) {
+		t.mu.Unlock()
+		return err
+	}
+
+	prev := *tx
+
+	switch event.Status {
+	case MempoolTxStatusReplaced, MempoolTxStatusConflicted:
+		tx.Status = OutboundTxStatusReplaced
+		tx.ReplacedBy = event.ReplacedBy
+	case MempoolTxStatusConfirmed:
+		tx.Status = OutboundTxStatusConfirmed
+		tx.BlockHash = event.BlockHash
+		tx.BlockHeight = event.BlockHeight
+		if tx.Confirmations == 0 {
+			tx.Confirmations = 1
+		}
+	case MempoolTxStatusEvicted:
+		tx.Status = OutboundTxStatusDropped
+	}
+
+	t.save(tx)
+
+	t.mu.Unlock()
+
+	t.notify(&prev, tx)
+	return nil
+}
+
+//save 保存交易单状态，调用前需要加锁
+func (t *OutboundTracker) save(tx *OutboundTx) {
+	if err := t.wm.saveOutboundTx(tx); err != nil {
+		t.wm.Log.Errorf("outbound tracker save tx: %s failed unexpected error: %v", tx.TxID, err)
+	}
+}
+
+//notify 状态或确认数变化时通知观察者
+func (t *OutboundTracker) notify(prev, tx *OutboundTx) {
+
+	if prev.Status == tx.Status && prev.Confirmations == tx.Confirmations && prev.Rebroadcasts == tx.Rebroadcasts {
+		return
+	}
+
+	event := &OutboundTxEvent{
+		TxID:          tx.TxID,
+		AccountID:     tx.AccountID,
+		Status:        tx.Status,
+		PrevStatus:    prev.Status,
+		Confirmations: tx.Confirmations,
+		BlockHash:     tx.BlockHash,
+		BlockHeight:   tx.BlockHeight,
+		ReplacedBy:    tx.ReplacedBy,
+		Rebroadcasts:  tx.Rebroadcasts,
+	}
+
+	t.mu.Lock()
+	observers := make([]OutboundTxObserver, 0, len(t.observers))
+	for o := range t.observers {
+		observers = append(observers, o)
+	}
+	t.mu.Unlock()
+
+	t.wm.Log.Infof("outbound tx: %s status changed from %s to %s", event.TxID, event.PrevStatus, event.Status)
+	for _, o := range observers {
+		if err := o.OutboundTxStatusNotify(event); err != nil {
+			t.wm.Log.Error("OutboundTxStatusNotify unexpected error:", err)
+		}
+	}
+}
+
+//isTxAlreadyKnownError 节点内存池已有该交易
+func isTxAlreadyKnownError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "txn-already-known") || strings.Contains(msg, "txn-already-in-mempool")
+}
+
+//isTxAlreadyInChainError 交易已被打包
+func isTxAlreadyInChainError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "already in block chain") || strings.Contains(msg, "already in utxo set") ||
+		strings.HasPrefix(msg, "[-27]")
+}
+
+//isTxInputsSpentError 交易的输入已被花费
+func isTxInputsSpentError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "missingorspent") || strings.Contains(msg, "missing inputs") ||
+		strings.Contains(msg, "txn-mempool-conflict")
+}
+
+//rawTxOutpoints 解析交易花费的输出
+func rawTxOutpoints(rawHex string) []string {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil
+	}
+	var msgTx wire.MsgTx
+	if err = msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil
+	}
+	outpoints := make([]string, 0, len(msgTx.TxIn))
+	for _, in := range msgTx.TxIn {
+		outpoints = append(outpoints, fmt.Sprintf("%s:%d", in.PreviousOutPoint.Hash.String(), in.PreviousOutPoint.Index))
+	}
+	return outpoints
+}
+
+//saveOutboundTx 保存广播的交易单
+func (wm *WalletManager) saveOutboundTx(tx *OutboundTx) error {
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+	return db.Save(tx)
+}
+
+//GetOutboundTx 查询广播的交易单，不存在返回nil
+func (wm *WalletManager) GetOutboundTx(txid string) (*OutboundTx, error) {
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var tx OutboundTx
+	err = db.One("TxID", txid, &tx)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &tx, nil
+}
+
+//isOwnTransaction 是否适配器广播的交易单
+func (wm *WalletManager) isOwnTransaction(txid string) bool {
+	tx, err := wm.GetOutboundTx(txid)
+	if err != nil {
+		wm.Log.Errorf("get outbound tx: %s failed unexpected error: %v", txid, err)
+		return false
+	}
+	return tx != nil
+}
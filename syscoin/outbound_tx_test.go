@@ -0,0 +1,207 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+type testOutboundObserver struct {
+	events []*OutboundTxEvent
+}
+
+func (o *testOutboundObserver) OutboundTxStatusNotify(event *OutboundTxEvent) error {
+	o.events = append(o.events, event)
+	return nil
+}
+
+func TestOutboundTracker_MempoolEvents(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	tracker := NewOutboundTracker(wm)
+	obs := &testOutboundObserver{}
+	tracker.AddObserver(obs)
+
+	for _, txid := range []string{"a", "b"} {
+		err := tracker.Track(&openwallet.RawTransaction{TxID: txid, Account: &openwallet.AssetsAccount{AccountID: "account1"}})
+		if err != nil {
+			t.Fatalf("Track failed unexpected error: %v", err)
+		}
+	}
+
+	if !wm.isOwnTransaction("a") {
+		t.Errorf("tracked tx should be own transaction")
+	}
+
+	tracker.MempoolTxStatusNotify(&MempoolTxEvent{TxID: "a", Status: MempoolTxStatusReplaced, ReplacedBy: "c"})
+	tracker.MempoolTxStatusNotify(&MempoolTxEvent{TxID: "b", Status: MempoolTxStatusConfirmed, BlockHash: "hash", BlockHeight: 10})
+	//未跟踪的交易不通知
+	tracker.MempoolTxStatusNotify(&MempoolTxEvent{TxID: "d", Status: MempoolTxStatusReplaced})
+
+	if len(obs.events) != 2 {
+		t.Fatalf("unexpected events: %d", len(obs.events))
+	}
+	e := obs.events[0]
+	if e.TxID != "a" || e.Status != OutboundTxStatusReplaced || e.PrevStatus != OutboundTxStatusSubmitted || e.ReplacedBy != "c" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	e = obs.events[1]
+	if e.TxID != "b" || e.Status != OutboundTxStatusConfirmed || e.BlockHeight != 10 || e.AccountID != "account1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	//被替换的交易结束跟踪，已确认的交易等待足够确认数
+	pending, err := tracker.Pending()
+	if err != nil || len(pending) != 1 || pending[0].TxID != "b" {
+		t.Errorf("unexpected pending txs: %+v, err: %v", pending, err)
+	}
+}
+
+func TestOutboundTracker_BroadcastErrors(t *testing.T) {
+	if !isTxInputsSpentError(errors.New("[-26]txn-mempool-conflict")) {
+		t.Errorf("mempool conflict should be inputs spent error")
+	}
+	if !isTxInputsSpentError(errors.New("[-25]bad-txns-inputs-missingorspent")) {
+		t.Errorf("missing inputs should be inputs spent error")
+	}
+	if !isTxAlreadyInChainError(errors.New("[-27]transaction already in block chain")) {
+		t.Errorf("already in block chain should be in chain error")
+	}
+	if isTxAlreadyKnownError(errors.New("[-27]transaction already in block chain")) {
+		t.Errorf("already in block chain should not be mempool known error")
+	}
+	if !isTxAlreadyKnownError(errors.New("[-26]txn-already-in-mempool")) {
+		t.Errorf("already in mempool should be known error")
+	}
+	if isTxInputsSpentError(errors.New("[-26]min relay fee not met")) {
+		t.Errorf("min relay fee should not be inputs spent error")
+	}
+	//费率不足的替换被拒绝，原交易仍然有效，继续重新广播
+	if isTxInputsSpentError(errors.New("[-26]insufficient fee, rejecting replacement")) {
+		t.Errorf("insufficient fee should not be inputs spent error")
+	}
+}
+
+func TestOutboundTracker_Prune(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	tracker := NewOutboundTracker(wm)
+	now := time.Now()
+	old := now.Add(-tracker.Retention - time.Hour).Unix()
+	txs := []*OutboundTx{
+		{TxID: "replaced", Status: OutboundTxStatusReplaced, LastChecked: old},
+		{TxID: "failed", Status: OutboundTxStatusFailed, SubmitTime: old},
+		{TxID: "confirmed", Status: OutboundTxStatusConfirmed, Confirmations: tracker.Confirmations, LastChecked: old},
+		{TxID: "recent", Status: OutboundTxStatusReplaced, LastChecked: now.Unix()},
+		{TxID: "confirming", Status: OutboundTxStatusConfirmed, Confirmations: 1, LastChecked: old},
+		{TxID: "dropped", Status: OutboundTxStatusDropped, LastChecked: old},
+	}
+	for _, tx := range txs {
+		if err := wm.saveOutboundTx(tx); err != nil {
+			t.Fatalf("saveOutboundTx failed unexpected error: %v", err)
+		}
+	}
+
+	pending, err := tracker.Pending()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("unexpected pending txs: %+v, err: %v", pending, err)
+	}
+
+	pruned, err := tracker.Prune(now)
+	if err != nil || pruned != 3 {
+		t.Fatalf("unexpected pruned: %d, err: %v", pruned, err)
+	}
+	for _, tx := range txs {
+		saved, _ := wm.GetOutboundTx(tx.TxID)
+		deleted := tx.TxID == "replaced" || tx.TxID == "failed" || tx.TxID == "confirmed"
+		if deleted != (saved == nil) {
+			t.Errorf("tx: %s unexpected prune result, deleted: %v", tx.TxID, saved == nil)
+		}
+	}
+}
+
+func TestOutboundTracker_CheckRebroadcast(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	sendResult := `{"result":null,"error":{"code":-27,"message":"transaction already in block chain"},"id":"1"}`
+	sends := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch gjson.GetBytes(body, "method").String() {
+		case "sendrawtransaction":
+			sends++
+			fmt.Fprint(w, sendResult)
+		default:
+			fmt.Fprint(w, `{"result":null,"error":{"code":-5,"message":"No such mempool or blockchain transaction"},"id":"1"}`)
+		}
+	}))
+	defer server.Close()
+
+	wm.Config.RPCServerType = RPCServerCore
+	wm.WalletClient = NewClient(server.URL, "", false)
+
+	tracker := NewOutboundTracker(wm)
+	obs := &testOutboundObserver{}
+	tracker.AddObserver(obs)
+
+	txs := []*OutboundTx{
+		{TxID: "submitted", Status: OutboundTxStatusSubmitted},
+		{TxID: "confirmed", Status: OutboundTxStatusConfirmed, Confirmations: 1, BlockHeight: 10},
+	}
+	for _, tx := range txs {
+		if err := wm.saveOutboundTx(tx); err != nil {
+			t.Fatalf("saveOutboundTx failed unexpected error: %v", err)
+		}
+	}
+
+	//刚广播的交易首次查询不到不重新广播，已确认的交易不重新广播
+	tracker.Check()
+	if sends != 0 || len(obs.events) != 0 {
+		t.Fatalf("unexpected rebroadcast: %d, events: %d", sends, len(obs.events))
+	}
+
+	//再次查询不到时重新广播，节点返回已打包
+	tracker.Check()
+	if sends != 1 {
+		t.Fatalf("unexpected rebroadcast: %d", sends)
+	}
+	tx, _ := wm.GetOutboundTx("submitted")
+	if tx.Status != OutboundTxStatusConfirmed {
+		t.Errorf("tx already in block chain should be confirmed: %s", tx.Status)
+	}
+	tx, _ = wm.GetOutboundTx("confirmed")
+	if tx.Status != OutboundTxStatusConfirmed || tx.BlockHeight != 10 {
+		t.Errorf("confirmed tx should be unchanged: %+v", tx)
+	}
+	for _, e := range obs.events {
+		if e.Status != OutboundTxStatusConfirmed {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	OutputOrderBIP69  = "bip69"  //输入输出按BIP-69排序
+	OutputOrderCaller = "caller" //保持调用方给出的输出顺序，找零在最后
+)
+
+//TxOutput 交易单的一个主链币输出
+type TxOutput struct {
+	Address  string
+	Amount   decimal.Decimal
+	IsChange bool
+}
+
+//rawTxOutputs 交易单的收款输出。扩展参数outputs按顺序给出收款，允许同一地址多个输出，
+//格式：[{"address":"","amount":""}]；没有则使用To，按地址排序
+func (decoder *TransactionDecoder) rawTxOutputs(rawTx *openwallet.RawTransaction) ([]*TxOutput, error) {
+
+	outputs := make([]*TxOutput, 0)
+
+	ext := rawTx.GetExtParam().Get("outputs")
+	if ext.Exists() {
+		for _, o := range ext.Array() {
+			amount, err := decimal.NewFromString(o.Get("amount").String())
+			if err != nil || amount.LessThanOrEqual(decimal.Zero) {
+				return nil, fmt.Errorf("invalid output amount: %s", o.Get("amount").String())
+			}
+			outputs = append(outputs, &TxOutput{Address: o.Get("address").String(), Amount: amount})
+		}
+		return outputs, nil
+	}
+
+	for addr, amount := range rawTx.To {
+		deamount, err := decimal.NewFromString(amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output amount: %s", amount)
+		}
+		outputs = append(outputs, &TxOutput{Address: addr, Amount: deamount})
+	}
+
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Address < outputs[j].Address
+	})
+
+	return outputs, nil
+}
+
+//outputOrder 交易单的排序方式，扩展参数outputOrder优先，其次是配置，默认BIP-69
+func (decoder *TransactionDecoder) outputOrder(rawTx *openwallet.RawTransaction) string {
+	if order := rawTx.GetExtParam().Get("outputOrder").String(); len(order) > 0 {
+		return order
+	}
+	if len(decoder.wm.Config.OutputOrder) > 0 {
+		return decoder.wm.Config.OutputOrder
+	}
+	return OutputOrderBIP69
+}
+
+//sortBIP69Inputs 输入按txid升序，相同txid按vout升序
+func sortBIP69Inputs(usedUTXO []*Unspent) []*Unspent {
+	sorted := make([]*Unspent, len(usedUTXO))
+	copy(sorted, usedUTXO)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].TxID != sorted[j].TxID {
+			return sorted[i].TxID < sorted[j].TxID
+		}
+		return sorted[i].Vout < sorted[j].Vout
+	})
+	return sorted
+}
+
+//sortBIP69Outputs 输出按金额升序，相同金额按锁定脚本的字节序
+func (decoder *TransactionDecoder) sortBIP69Outputs(outputs []*TxOutput) ([]*TxOutput, error) {
+	scripts := make(map[string][]byte, len(outputs))
+	for _, o := range outputs {
+		if _, ok := scripts[o.Address]; ok {
+			continue
+		}
+		script, err := decoder.wm.addressScriptPubKey(o.Address)
+		if err != nil {
+			return nil, err
+		}
+		scripts[o.Address] = script
+	}
+
+	sorted := make([]*TxOutput, len(outputs))
+	copy(sorted, outputs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a := sorted[i].Amount.Shift(decoder.wm.Decimal()).IntPart()
+		b := sorted[j].Amount.Shift(decoder.wm.Decimal()).IntPart()
+		if a != b {
+			return a < b
+		}
+		return bytes.Compare(scripts[sorted[i].Address], scripts[sorted[j].Address]) < 0
+	})
+	return sorted, nil
+}
+
+//newTxOutputs 按地址合并的输出转换为按地址排序的输出列表
+func newTxOutputs(outputAddrs map[string]decimal.Decimal) []*TxOutput {
+	outputs := make([]*TxOutput, 0, len(outputAddrs))
+	for addr, amount := range outputAddrs {
+		outputs = append(outputs, &TxOutput{Address: addr, Amount: amount})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Address < outputs[j].Address
+	})
+	return outputs
+}
+
+//GetChangeOutputIndex 交易单找零输出的序号，没有找零返回-1
+func GetChangeOutputIndex(rawTx *openwallet.RawTransaction) int {
+	index := rawTx.GetExtParam().Get("changeIndex")
+	if !index.Exists() {
+		return -1
+	}
+	return int(index.Int())
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestTransactionDecoder_BIP69(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{Decimals: Decimals}}
+	decoder := NewTransactionDecoder(wm)
+
+	inputs := sortBIP69Inputs([]*Unspent{
+		{TxID: "b", Vout: 0},
+		{TxID: "a", Vout: 2},
+		{TxID: "a", Vout: 1},
+	})
+	if inputs[0].TxID != "a" || inputs[0].Vout != 1 || inputs[1].Vout != 2 || inputs[2].TxID != "b" {
+		t.Errorf("unexpected inputs order: %+v %+v %+v", inputs[0], inputs[1], inputs[2])
+	}
+
+	//金额相同按锁定脚本排序，0014...小于76a9...
+	p2pkh := "SNZxMWpxUBPo8Szs8Xa8tzK1GFLCC2xXwB"
+	p2wpkh := "sys1qph7ght7ggxv98v6dtcrj45marfg4navyqsexrc"
+	outputs, err := decoder.sortBIP69Outputs([]*TxOutput{
+		{Address: p2pkh, Amount: decimal.RequireFromString("1")},
+		{Address: p2wpkh, Amount: decimal.RequireFromString("2")},
+		{Address: p2wpkh, Amount: decimal.RequireFromString("1")},
+	})
+	if err != nil {
+		t.Fatalf("sortBIP69Outputs failed unexpected error: %v", err)
+	}
+	if outputs[0].Address != p2wpkh || outputs[1].Address != p2pkh || !outputs[2].Amount.Equal(decimal.New(2, 0)) {
+		t.Errorf("unexpected outputs order: %+v %+v %+v", outputs[0], outputs[1], outputs[2])
+	}
+}
+
+func TestTransactionDecoder_RawTxOutputs(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{Decimals: Decimals}}
+	decoder := NewTransactionDecoder(wm)
+
+	rawTx := &openwallet.RawTransaction{}
+	rawTx.SetExtParam("outputs", []map[string]string{
+		{"address": "b", "amount": "1"},
+		{"address": "a", "amount": "2"},
+		{"address": "b", "amount": "1"},
+	})
+	rawTx.SetExtParam("outputOrder", OutputOrderCaller)
+
+	outputs, err := decoder.rawTxOutputs(rawTx)
+	if err != nil {
+		t.Fatalf("rawTxOutputs failed unexpected error: %v", err)
+	}
+	if len(outputs) != 3 || outputs[0].Address != "b" || outputs[1].Address != "a" || outputs[2].Address != "b" {
+		t.Errorf("duplicate outputs should keep caller order: %+v", outputs)
+	}
+	if decoder.outputOrder(rawTx) != OutputOrderCaller {
+		t.Errorf("unexpected output order: %s", decoder.outputOrder(rawTx))
+	}
+
+	if GetChangeOutputIndex(rawTx) != -1 {
+		t.Errorf("change index should be -1 before built")
+	}
+	rawTx.SetExtParam("changeIndex", 2)
+	if GetChangeOutputIndex(rawTx) != 2 {
+		t.Errorf("unexpected change index: %d", GetChangeOutputIndex(rawTx))
+	}
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//P2PBackend P2P节点后端，同步并验证区块头，下载区块，广播交易
+type P2PBackend struct {
+	Addr   string
+	Params *P2PNetParams
+	Chain  *HeaderChain //已验证的区块头链，首次同步时创建
+
+	wm     *WalletManager
+	mu     sync.Mutex
+	syncMu sync.Mutex
+	peer   *P2PPeer
+}
+
+//NewP2PBackend 创建P2P节点后端，连接在首次请求时建立
+func NewP2PBackend(wm *WalletManager, addr string) *P2PBackend {
+	return &P2PBackend{
+		Addr:   addr,
+		Params: wm.P2PNetParams(),
+		wm:     wm,
+	}
+}
+
+//SetCheckpoint 设置区块头同步的可信检查点
+func (b *P2PBackend) SetCheckpoint(height uint64, hash string) error {
+
+	h, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return err
+	}
+
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	b.setHeaderChain(NewHeaderChain(b.Params, height, h))
+
+	return nil
+}
+
+//parseP2PCheckpoint 解析检查点配置，格式为height:hash
+func parseP2PCheckpoint(s string) (uint64, string, error) {
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("p2p checkpoint: %s is invalid", s)
+	}
+
+	height, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("p2p checkpoint: %s is invalid", s)
+	}
+
+	return height, strings.TrimSpace(parts[1]), nil
+}
+
+//Close 断开节点连接
+func (b *P2PBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.peer == nil {
+		return nil
+	}
+	err := b.peer.Close()
+	b.peer = nil
+	return err
+}
+
+//getPeer 获取节点连接，未连接时重新连接并握手
+func (b *P2PBackend) getPeer() (*P2PPeer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.peer != nil {
+		return b.peer, nil
+	}
+
+	peer, err := DialP2PPeer(b.Addr, b.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	b.wm.Log.Infof("p2p peer: %s connected, version: %d, user agent: %s, height: %d",
+		peer.Addr, peer.ProtocolVersion, peer.UserAgent, peer.StartHeight)
+
+	b.peer = peer
+	return peer, nil
+}
+
+//dropPeer 请求失败后断开连接，下次请求重新连接
+func (b *P2PBackend) dropPeer(peer *P2PPeer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.peer != peer {
+		return
+	}
+	peer.Close()
+	b.peer = nil
+}
+
+//SyncHeaders 从节点同步区块头到最新，返回最新的已验证区块头
+//必须先设置可信检查点，不使用节点接口的最新区块代替
+func (b *P2PBackend) SyncHeaders() (*ChainHeader, error) {
+
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+
+	chain := b.headerChain()
+	if chain == nil {
+		return nil, errors.New("p2p checkpoint is not set")
+	}
+
+	peer, err := b.getPeer()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		headers, err := peer.GetHeaders(chain.Locator(), nil)
+		if err != nil {
+			b.dropPeer(peer)
+			return nil, err
+		}
+
+		_, err = chain.Connect(headers)
+		if err != nil {
+			//区块头验证失败，不再信任该连接
+			b.dropPeer(peer)
+			return nil, err
+		}
+
+		if len(headers) < wire.MaxBlockHeadersPerMsg {
+			break
+		}
+	}
+
+	return chain.Tip(), nil
+}
+
+//GetBlock 下载区块，区块必须在已验证的区块头链上
+func (b *P2PBackend) GetBlock(hash string) (*SyscoinBlock, error) {
+
+	h, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := b.chainHeader(h); !ok {
+		return nil, fmt.Errorf("block: %s is not in validated header chain", hash)
+	}
+
+	peer, err := b.getPeer()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := peer.GetBlock(h)
+	if err != nil {
+		b.dropPeer(peer)
+		return nil, err
+	}
+
+	return block, nil
+}
+
+//GetBlockFilter 通过P2P获取区块的basic过滤器，实现BlockFilterSource
+func (b *P2PBackend) GetBlockFilter(blockHash string) ([]byte, error) {
+
+	h, err := chainhash.NewHashFromStr(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var height uint64
+	if header, ok := b.chainHeader(h); ok {
+		height = header.Height
+	} else {
+		block, err := b.wm.GetBlockHeader(blockHash)
+		if err != nil {
+			return nil, err
+		}
+		height = block.Height
+	}
+
+	peer, err := b.getPeer()
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := peer.GetBlockFilter(uint32(height), h)
+	if err != nil {
+		b.dropPeer(peer)
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (b *P2PBackend) chainHeader(hash *chainhash.Hash) (*ChainHeader, bool) {
+	chain := b.headerChain()
+	if chain == nil {
+		return nil, false
+	}
+	return chain.HeaderByHash(hash)
+}
+
+func (b *P2PBackend) headerChain() *HeaderChain {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Chain
+}
+
+func (b *P2PBackend) setHeaderChain(chain *HeaderChain) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Chain = chain
+}
+
+//SendRawTransaction 通过P2P广播已签名的交易
+func (b *P2PBackend) SendRawTransaction(txHex string) (string, error) {
+
+	rawTx, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", errors.New("transaction hex is invalid")
+	}
+
+	peer, err := b.getPeer()
+	if err != nil {
+		return "", err
+	}
+
+	txid, err := peer.SendTransaction(rawTx)
+	if err != nil {
+		b.dropPeer(peer)
+		return "", err
+	}
+
+	return txid, nil
+}
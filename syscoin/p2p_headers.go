@@ -0,0 +1,246 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	//区块时间允许超前本地时间的范围
+	maxHeaderTimeOffset = 2 * time.Hour
+
+	//计算中位时间的区块数量
+	medianTimeBlocks = 11
+)
+
+//ChainHeader 已验证的区块头
+type ChainHeader struct {
+	Hash      chainhash.Hash
+	Height    uint64
+	Header    *SyscoinBlockHeader
+	ChainWork *big.Int //从检查点开始累计的工作量
+}
+
+//HeaderChain 从可信检查点开始同步的区块头链，验证前后链接、工作量证明和合并挖矿证明
+//难度调整依赖完整的历史区块，这里只检查目标值不低于网络的最低难度
+type HeaderChain struct {
+	Params *P2PNetParams
+
+	mu      sync.RWMutex
+	headers []*ChainHeader //按高度排列，第一个为检查点
+	index   map[chainhash.Hash]*ChainHeader
+}
+
+//NewHeaderChain 以指定高度的区块为检查点创建区块头链
+func NewHeaderChain(params *P2PNetParams, height uint64, hash *chainhash.Hash) *HeaderChain {
+
+	checkpoint := &ChainHeader{
+		Hash:      *hash,
+		Height:    height,
+		ChainWork: big.NewInt(0),
+	}
+
+	return &HeaderChain{
+		Params:  params,
+		headers: []*ChainHeader{checkpoint},
+		index:   map[chainhash.Hash]*ChainHeader{*hash: checkpoint},
+	}
+}
+
+//Tip 最新的区块头
+func (c *HeaderChain) Tip() *ChainHeader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.headers[len(c.headers)-1]
+}
+
+//HeaderByHash 根据hash查找主链上的区块头
+func (c *HeaderChain) HeaderByHash(hash *chainhash.Hash) (*ChainHeader, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.index[*hash]
+	return h, ok
+}
+
+//HeaderByHeight 根据高度查找主链上的区块头
+func (c *HeaderChain) HeaderByHeight(height uint64) (*ChainHeader, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	base := c.headers[0].Height
+	if height < base || height-base >= uint64(len(c.headers)) {
+		return nil, false
+	}
+	return c.headers[height-base], true
+}
+
+//Locator 区块定位器，从最新区块开始，间隔指数增长，最后是检查点
+func (c *HeaderChain) Locator() []*chainhash.Hash {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	locator := make([]*chainhash.Hash, 0, 32)
+	step := 1
+	for i := len(c.headers) - 1; i > 0; i -= step {
+		locator = append(locator, &c.headers[i].Hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	locator = append(locator, &c.headers[0].Hash)
+
+	return locator
+}
+
+//Connect 连接节点返回的一组连续区块头，分叉链的累计工作量更大时切换主链
+//返回新增到主链的区块头数量
+func (c *HeaderChain) Connect(headers []*SyscoinBlockHeader) (int, error) {
+
+	if len(headers) == 0 {
+		return 0, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fork, ok := c.index[headers[0].PrevBlock]
+	if !ok {
+		return 0, fmt.Errorf("block: %s previous block: %s not found in header chain",
+			headers[0].BlockHash().String(), headers[0].PrevBlock.String())
+	}
+
+	//验证分支上的区块头
+	branch := make([]*ChainHeader, 0, len(headers))
+	prev := fork
+	for _, header := range headers {
+
+		hash := header.BlockHash()
+
+		if header.PrevBlock != prev.Hash {
+			return 0, fmt.Errorf("block: %s is not linked to previous block: %s", hash.String(), prev.Hash.String())
+		}
+
+		//已在主链上的区块头直接跳过
+		if exist, ok := c.index[hash]; ok && len(branch) == 0 {
+			prev = exist
+			fork = exist
+			continue
+		}
+
+		err := c.checkHeader(header, prev, branch)
+		if err != nil {
+			return 0, err
+		}
+
+		node := &ChainHeader{
+			Hash:      hash,
+			Height:    prev.Height + 1,
+			Header:    header,
+			ChainWork: new(big.Int).Add(prev.ChainWork, blockchain.CalcWork(header.Bits)),
+		}
+		branch = append(branch, node)
+		prev = node
+	}
+
+	if len(branch) == 0 {
+		return 0, nil
+	}
+
+	//分叉链的工作量不超过主链时不切换
+	tip := c.headers[len(c.headers)-1]
+	if fork != tip && prev.ChainWork.Cmp(tip.ChainWork) <= 0 {
+		return 0, nil
+	}
+
+	base := c.headers[0].Height
+	for _, h := range c.headers[fork.Height-base+1:] {
+		delete(c.index, h.Hash)
+	}
+	c.headers = c.headers[:fork.Height-base+1]
+
+	for _, node := range branch {
+		c.headers = append(c.headers, node)
+		c.index[node.Hash] = node
+	}
+
+	return len(branch), nil
+}
+
+//checkHeader 验证区块头的工作量证明、合并挖矿证明和时间戳
+func (c *HeaderChain) checkHeader(header *SyscoinBlockHeader, prev *ChainHeader, branch []*ChainHeader) error {
+
+	hash := header.BlockHash()
+
+	err := header.CheckProofOfWork(c.Params)
+	if err != nil {
+		return err
+	}
+
+	if header.Timestamp.After(time.Now().Add(maxHeaderTimeOffset)) {
+		return fmt.Errorf("block: %s timestamp is too far in the future", hash.String())
+	}
+
+	median, ok := c.medianTime(prev, branch)
+	if ok && !header.Timestamp.After(median) {
+		return fmt.Errorf("block: %s timestamp is not after median time", hash.String())
+	}
+
+	return nil
+}
+
+//medianTime 前11个区块的中位时间，区块头不足时不检查
+func (c *HeaderChain) medianTime(prev *ChainHeader, branch []*ChainHeader) (time.Time, bool) {
+
+	times := make([]time.Time, 0, medianTimeBlocks)
+
+	for i := len(branch) - 1; i >= 0 && len(times) < medianTimeBlocks; i-- {
+		times = append(times, branch[i].Header.Timestamp)
+	}
+
+	height := prev.Height
+	if len(branch) > 0 {
+		height = branch[0].Height - 1
+	}
+
+	base := c.headers[0].Height
+	for len(times) < medianTimeBlocks && height >= base {
+		node := c.headers[height-base]
+		if node.Header == nil {
+			break
+		}
+		times = append(times, node.Header.Timestamp)
+		if height == 0 {
+			break
+		}
+		height--
+	}
+
+	if len(times) < medianTimeBlocks {
+		return time.Time{}, false
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	return times[len(times)/2], true
+}
@@ -0,0 +1,558 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+/******************* 使用P2P协议连接Syscoin节点 *******************/
+
+const (
+	//P2P协议版本
+	SyscoinProtocolVersion = 70015
+
+	//P2P请求的超时时间
+	p2pRequestTimeout = 30 * time.Second
+
+	//P2P消息头长度
+	p2pMessageHeaderSize = 24
+
+	//P2P客户端标识
+	p2pUserAgentName    = "openwallet-syscoin"
+	p2pUserAgentVersion = "1.0.0"
+)
+
+//P2PNetParams P2P网络参数
+type P2PNetParams struct {
+	Name          string
+	Net           wire.BitcoinNet //消息头的网络魔数
+	DefaultPort   string
+	PowLimit      *big.Int //最低难度的目标值
+	AuxPowChainID int32    //合并挖矿的链ID
+}
+
+var (
+	//SyscoinMainNetParams 主网，魔数为ce e2 ca ff
+	SyscoinMainNetParams = &P2PNetParams{
+		Name:          "mainnet",
+		Net:           wire.BitcoinNet(0xffcae2ce),
+		DefaultPort:   "8369",
+		PowLimit:      powLimitFromHex("00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+		AuxPowChainID: 0x1000,
+	}
+
+	//SyscoinTestNetParams 测试网，魔数为ce e2 ca fe
+	SyscoinTestNetParams = &P2PNetParams{
+		Name:          "testnet",
+		Net:           wire.BitcoinNet(0xfecae2ce),
+		DefaultPort:   "18369",
+		PowLimit:      powLimitFromHex("00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+		AuxPowChainID: 0x1000,
+	}
+
+	//SyscoinRegTestParams 回归测试网，魔数和端口需要按节点配置p2pMagic和p2pPort
+	SyscoinRegTestParams = &P2PNetParams{
+		Name:          "regtest",
+		PowLimit:      powLimitFromHex("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+		AuxPowChainID: 0x1000,
+	}
+)
+
+func powLimitFromHex(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	return n
+}
+
+//P2PNetParams 当前网络的P2P参数，魔数和端口可通过配置覆盖
+func (wm *WalletManager) P2PNetParams() *P2PNetParams {
+
+	base := SyscoinMainNetParams
+	switch wm.Config.P2PNetwork {
+	case SyscoinMainNetParams.Name:
+	case SyscoinTestNetParams.Name:
+		base = SyscoinTestNetParams
+	case SyscoinRegTestParams.Name:
+		base = SyscoinRegTestParams
+	default:
+		if wm.Config.IsTestNet {
+			base = SyscoinTestNetParams
+		}
+	}
+
+	if len(wm.Config.P2PMagic) == 0 && len(wm.Config.P2PPort) == 0 {
+		return base
+	}
+
+	params := *base
+	if magic, err := parseP2PMagic(wm.Config.P2PMagic); err == nil {
+		params.Net = magic
+	}
+	if len(wm.Config.P2PPort) > 0 {
+		params.DefaultPort = wm.Config.P2PPort
+	}
+	return &params
+}
+
+//checkP2PNetParams 检查P2P网络配置，魔数和端口没有默认值时必须配置
+func (wm *WalletManager) checkP2PNetParams() error {
+
+	switch wm.Config.P2PNetwork {
+	case "", SyscoinMainNetParams.Name, SyscoinTestNetParams.Name, SyscoinRegTestParams.Name:
+	default:
+		return fmt.Errorf("p2p network: %s is invalid", wm.Config.P2PNetwork)
+	}
+
+	if len(wm.Config.P2PMagic) > 0 {
+		if _, err := parseP2PMagic(wm.Config.P2PMagic); err != nil {
+			return err
+		}
+	}
+
+	params := wm.P2PNetParams()
+	if params.Net == 0 {
+		return fmt.Errorf("p2p network: %s magic is not set", params.Name)
+	}
+	if len(params.DefaultPort) == 0 {
+		return fmt.Errorf("p2p network: %s port is not set", params.Name)
+	}
+
+	return nil
+}
+
+//parseP2PMagic 解析网络魔数，十六进制按消息头的字节顺序，例如：cee2caff
+func parseP2PMagic(s string) (wire.BitcoinNet, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != 4 {
+		return 0, fmt.Errorf("p2p magic: %s is invalid", s)
+	}
+	return wire.BitcoinNet(binary.LittleEndian.Uint32(b)), nil
+}
+
+//P2PPeer 与节点的P2P连接，请求按顺序执行
+type P2PPeer struct {
+	Addr            string
+	Params          *P2PNetParams
+	ProtocolVersion uint32 //协商后的协议版本
+	Services        wire.ServiceFlag
+	UserAgent       string
+	StartHeight     int32
+
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+//DialP2PPeer 连接节点并完成version/verack握手
+func DialP2PPeer(addr string, params *P2PNetParams) (*P2PPeer, error) {
+
+	if len(addr) == 0 {
+		return nil, errors.New("p2p peer address is empty")
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, params.DefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, p2pRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := &P2PPeer{
+		Addr:            addr,
+		Params:          params,
+		ProtocolVersion: SyscoinProtocolVersion,
+		conn:            conn,
+	}
+
+	err = peer.handshake()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("p2p peer: %s handshake failed, %v", addr, err)
+	}
+
+	return peer, nil
+}
+
+//Close 断开连接
+func (p *P2PPeer) Close() error {
+	return p.conn.Close()
+}
+
+//handshake 发送version，等待节点的version和verack
+func (p *P2PPeer) handshake() error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	you, err := p2pNetAddress(p.conn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	me, err := p2pNetAddress(p.conn.LocalAddr())
+	if err != nil {
+		return err
+	}
+
+	version := wire.NewMsgVersion(me, you, rand.Uint64(), 0)
+	version.ProtocolVersion = int32(SyscoinProtocolVersion)
+	version.DisableRelayTx = true
+	version.AddUserAgent(p2pUserAgentName, p2pUserAgentVersion)
+
+	err = p.writeMessage(version)
+	if err != nil {
+		return err
+	}
+
+	var gotVersion, gotVerAck bool
+	deadline := time.Now().Add(p2pRequestTimeout)
+
+	for !gotVersion || !gotVerAck {
+		command, payload, err := p.readMessage(deadline)
+		if err != nil {
+			return err
+		}
+
+		switch command {
+		case wire.CmdVersion:
+			msg := &wire.MsgVersion{}
+			err = msg.BtcDecode(bytes.NewBuffer(payload), SyscoinProtocolVersion, wire.BaseEncoding)
+			if err != nil {
+				return err
+			}
+			if uint32(msg.ProtocolVersion) < p.ProtocolVersion {
+				p.ProtocolVersion = uint32(msg.ProtocolVersion)
+			}
+			p.Services = msg.Services
+			p.UserAgent = msg.UserAgent
+			p.StartHeight = msg.LastBlock
+			gotVersion = true
+
+			err = p.writeMessage(wire.NewMsgVerAck())
+			if err != nil {
+				return err
+			}
+		case wire.CmdVerAck:
+			gotVerAck = true
+		}
+	}
+
+	return nil
+}
+
+//GetHeaders 根据区块定位器请求后续的区块头，每次最多2000个
+func (p *P2PPeer) GetHeaders(locator []*chainhash.Hash, stopHash *chainhash.Hash) ([]*SyscoinBlockHeader, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := wire.NewMsgGetHeaders()
+	msg.ProtocolVersion = p.ProtocolVersion
+	for _, hash := range locator {
+		err := msg.AddBlockLocatorHash(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if stopHash != nil {
+		msg.HashStop = *stopHash
+	}
+
+	err := p.writeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := p.waitMessage(wire.CmdHeaders, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return readHeaders(payload)
+}
+
+//GetBlock 下载完整区块（包含见证数据），并检查默克尔根
+func (p *P2PPeer) GetBlock(hash *chainhash.Hash) (*SyscoinBlock, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := wire.NewMsgGetData()
+	err := msg.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.writeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *SyscoinBlock
+	_, err = p.waitMessage(wire.CmdBlock, func(payload []byte) (bool, error) {
+		b := &SyscoinBlock{}
+		err := b.Deserialize(bytes.NewBuffer(payload))
+		if err != nil {
+			return false, err
+		}
+		if b.Header.BlockHash() != *hash {
+			return false, nil
+		}
+		block = b
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = block.CheckMerkleRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+//GetBlockFilter 请求区块的basic过滤器(BIP157)，节点需要开启peerblockfilters
+func (p *P2PPeer) GetBlockFilter(height uint32, hash *chainhash.Hash) ([]byte, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Services&wire.SFNodeCF == 0 {
+		return nil, fmt.Errorf("p2p peer: %s does not support compact filters", p.Addr)
+	}
+
+	err := p.writeMessage(wire.NewMsgGetCFilters(wire.GCSFilterRegular, height, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	var filter []byte
+	_, err = p.waitMessage(wire.CmdCFilter, func(payload []byte) (bool, error) {
+		msg := &wire.MsgCFilter{}
+		err := msg.BtcDecode(bytes.NewBuffer(payload), p.ProtocolVersion, wire.BaseEncoding)
+		if err != nil {
+			return false, err
+		}
+		if msg.BlockHash != *hash || msg.FilterType != wire.GCSFilterRegular {
+			return false, nil
+		}
+		filter = msg.Data
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+//SendTransaction 向节点广播已签名的交易，通过ping/pong确认节点已处理
+func (p *P2PPeer) SendTransaction(rawTx []byte) (string, error) {
+
+	tx := &wire.MsgTx{}
+	err := tx.Deserialize(bytes.NewReader(rawTx))
+	if err != nil {
+		return "", err
+	}
+	txid := tx.TxHash()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err = p.writeRawMessage(wire.CmdTx, rawTx)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := rand.Uint64()
+	err = p.writeMessage(wire.NewMsgPing(nonce))
+	if err != nil {
+		return "", err
+	}
+
+	var rejected error
+	_, err = p.waitMessage(wire.CmdPong, func(payload []byte) (bool, error) {
+		if len(payload) < 8 {
+			return false, nil
+		}
+		return binary.LittleEndian.Uint64(payload) == nonce, nil
+	}, func(command string, payload []byte) {
+		//旧版本节点会回复reject
+		if command != wire.CmdReject {
+			return
+		}
+		msg := &wire.MsgReject{}
+		if msg.BtcDecode(bytes.NewBuffer(payload), p.ProtocolVersion, wire.BaseEncoding) != nil {
+			return
+		}
+		if msg.Cmd == wire.CmdTx && msg.Hash == txid {
+			rejected = fmt.Errorf("transaction: %s rejected by p2p peer, %s", txid.String(), msg.Reason)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if rejected != nil {
+		return "", rejected
+	}
+
+	return txid.String(), nil
+}
+
+//waitMessage 读取消息直到收到指定命令，match为空时接收第一个该命令的消息
+//等待期间自动回复ping，notfound视为请求失败
+func (p *P2PPeer) waitMessage(command string, match func(payload []byte) (bool, error), others ...func(command string, payload []byte)) ([]byte, error) {
+
+	deadline := time.Now().Add(p2pRequestTimeout)
+
+	for {
+		cmd, payload, err := p.readMessage(deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		switch cmd {
+		case command:
+			if match == nil {
+				return payload, nil
+			}
+			ok, err := match(payload)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return payload, nil
+			}
+		case wire.CmdPing:
+			if len(payload) >= 8 {
+				err = p.writeMessage(wire.NewMsgPong(binary.LittleEndian.Uint64(payload)))
+				if err != nil {
+					return nil, err
+				}
+			}
+		case wire.CmdNotFound:
+			return nil, fmt.Errorf("p2p peer: %s data not found", p.Addr)
+		default:
+			for _, f := range others {
+				f(cmd, payload)
+			}
+		}
+	}
+}
+
+//readMessage 读取一条消息，检查网络魔数和校验和
+func (p *P2PPeer) readMessage(deadline time.Time) (string, []byte, error) {
+
+	err := p.conn.SetReadDeadline(deadline)
+	if err != nil {
+		return "", nil, err
+	}
+
+	header := make([]byte, p2pMessageHeaderSize)
+	_, err = io.ReadFull(p.conn, header)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if wire.BitcoinNet(binary.LittleEndian.Uint32(header[0:4])) != p.Params.Net {
+		return "", nil, fmt.Errorf("p2p peer: %s message magic mismatch", p.Addr)
+	}
+
+	command := strings.TrimRight(string(header[4:16]), "\x00")
+
+	length := binary.LittleEndian.Uint32(header[16:20])
+	if length > wire.MaxMessagePayload {
+		return "", nil, fmt.Errorf("p2p peer: %s message: %s length: %d is too large", p.Addr, command, length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(p.conn, payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !bytes.Equal(chainhash.DoubleHashB(payload)[0:4], header[20:24]) {
+		return "", nil, fmt.Errorf("p2p peer: %s message: %s checksum mismatch", p.Addr, command)
+	}
+
+	return command, payload, nil
+}
+
+//writeMessage 编码并发送消息
+func (p *P2PPeer) writeMessage(msg wire.Message) error {
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, p.ProtocolVersion, wire.WitnessEncoding)
+	if err != nil {
+		return err
+	}
+	return p.writeRawMessage(msg.Command(), buf.Bytes())
+}
+
+//writeRawMessage 发送已编码的消息内容
+func (p *P2PPeer) writeRawMessage(command string, payload []byte) error {
+
+	if len(command) > wire.CommandSize {
+		return fmt.Errorf("p2p message command: %s is too long", command)
+	}
+
+	header := make([]byte, p2pMessageHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(p.Params.Net))
+	copy(header[4:16], command)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(payload)))
+	copy(header[20:24], chainhash.DoubleHashB(payload)[0:4])
+
+	err := p.conn.SetWriteDeadline(time.Now().Add(p2pRequestTimeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = p.conn.Write(append(header, payload...))
+	return err
+}
+
+//p2pNetAddress 连接地址转为P2P协议的网络地址
+func p2pNetAddress(addr net.Addr) (*wire.NetAddress, error) {
+
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return wire.NewNetAddressIPPort(net.ParseIP(host), uint16(port), 0), nil
+}
@@ -0,0 +1,489 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/tidwall/gjson"
+)
+
+const testRegTestBits = 0x207fffff
+
+//testMineHeader 在回归测试网难度下计算满足工作量证明的nonce
+func testMineHeader(header *wire.BlockHeader) {
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+//testAuxPowHeader 创建合并挖矿的区块头，父链coinbase承诺本区块hash，工作量证明在父链区块头上
+func testAuxPowHeader(prev chainhash.Hash, timestamp time.Time) *SyscoinBlockHeader {
+
+	header := &SyscoinBlockHeader{
+		BlockHeader: wire.BlockHeader{
+			Version:   SyscoinRegTestParams.AuxPowChainID<<auxPowChainIDShift | auxPowVersionFlag | 4,
+			PrevBlock: prev,
+			Timestamp: timestamp,
+			Bits:      testRegTestBits,
+		},
+	}
+
+	//合并挖矿标记 + 本区块hash（显示字节序） + 默克尔树大小 + nonce
+	hash := header.BlockHash()
+	script := append([]byte{0x03, 0x01, 0x02, 0x03}, mergedMiningHeader...)
+	for i := range hash {
+		script = append(script, hash[chainhash.HashSize-1-i])
+	}
+	script = append(script, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0xffffffff), script, nil))
+	coinbase.AddTxOut(wire.NewTxOut(0, []byte{0x6a}))
+
+	header.AuxPow = &AuxPow{
+		CoinbaseTx: coinbase,
+		ParentBlock: wire.BlockHeader{
+			Version:    4,
+			MerkleRoot: coinbase.TxHash(),
+			Timestamp:  timestamp,
+			Bits:       testRegTestBits,
+		},
+	}
+	testMineHeader(&header.AuxPow.ParentBlock)
+
+	return header
+}
+
+//testP2PNode 模拟节点，完成握手后回复区块头请求和交易广播的ping
+func testP2PNode(t *testing.T, ln net.Listener, headers []*SyscoinBlockHeader) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept failed unexpected error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	node := &P2PPeer{Params: SyscoinRegTestParams, ProtocolVersion: SyscoinProtocolVersion, conn: conn}
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		command, payload, err := node.readMessage(deadline)
+		if err != nil {
+			return
+		}
+		switch command {
+		case wire.CmdVersion:
+			me, _ := p2pNetAddress(conn.LocalAddr())
+			you, _ := p2pNetAddress(conn.RemoteAddr())
+			version := wire.NewMsgVersion(me, you, 1, 100)
+			version.Services = wire.SFNodeNetwork | wire.SFNodeWitness
+			node.writeMessage(version)
+			node.writeMessage(wire.NewMsgVerAck())
+		case wire.CmdGetHeaders:
+			var buf bytes.Buffer
+			wire.WriteVarInt(&buf, 0, uint64(len(headers)))
+			for _, h := range headers {
+				h.Serialize(&buf)
+				wire.WriteVarInt(&buf, 0, 0)
+			}
+			node.writeRawMessage(wire.CmdHeaders, buf.Bytes())
+		case wire.CmdPing:
+			ping := &wire.MsgPing{}
+			ping.BtcDecode(bytes.NewBuffer(payload), SyscoinProtocolVersion, wire.BaseEncoding)
+			node.writeMessage(wire.NewMsgPong(ping.Nonce))
+		}
+	}
+}
+
+func TestP2PPeer_HeadersAndRelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	checkpoint := chainhash.Hash{0x01}
+	now := time.Unix(time.Now().Unix(), 0)
+
+	header := testAuxPowHeader(checkpoint, now)
+	go testP2PNode(t, ln, []*SyscoinBlockHeader{header})
+
+	peer, err := DialP2PPeer(ln.Addr().String(), SyscoinRegTestParams)
+	if err != nil {
+		t.Fatalf("DialP2PPeer failed unexpected error: %v", err)
+	}
+	defer peer.Close()
+
+	if peer.StartHeight != 100 {
+		t.Errorf("peer start height: %d, want 100", peer.StartHeight)
+	}
+
+	chain := NewHeaderChain(SyscoinRegTestParams, 10, &checkpoint)
+
+	headers, err := peer.GetHeaders(chain.Locator(), nil)
+	if err != nil {
+		t.Fatalf("GetHeaders failed unexpected error: %v", err)
+	}
+	if len(headers) != 1 || headers[0].AuxPow == nil {
+		t.Fatalf("unexpected headers: %+v", headers)
+	}
+
+	n, err := chain.Connect(headers)
+	if err != nil {
+		t.Fatalf("Connect failed unexpected error: %v", err)
+	}
+	tip := chain.Tip()
+	if n != 1 || tip.Height != 11 || tip.Hash != header.BlockHash() {
+		t.Errorf("unexpected tip: %d %s", tip.Height, tip.Hash.String())
+	}
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&checkpoint, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	var raw bytes.Buffer
+	tx.Serialize(&raw)
+
+	txid, err := peer.SendTransaction(raw.Bytes())
+	if err != nil {
+		t.Fatalf("SendTransaction failed unexpected error: %v", err)
+	}
+	if txid != tx.TxHash().String() {
+		t.Errorf("txid: %s, want %s", txid, tx.TxHash().String())
+	}
+}
+
+func TestHeaderChain_Connect(t *testing.T) {
+
+	checkpoint := chainhash.Hash{0x02}
+	now := time.Unix(time.Now().Unix(), 0)
+
+	newHeader := func(prev chainhash.Hash, nonce uint32) *SyscoinBlockHeader {
+		h := &SyscoinBlockHeader{BlockHeader: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: prev,
+			Timestamp: now,
+			Bits:      testRegTestBits,
+			Nonce:     nonce,
+		}}
+		testMineHeader(&h.BlockHeader)
+		return h
+	}
+
+	chain := NewHeaderChain(SyscoinRegTestParams, 0, &checkpoint)
+
+	a1 := newHeader(checkpoint, 0)
+	if _, err := chain.Connect([]*SyscoinBlockHeader{a1}); err != nil {
+		t.Fatalf("Connect failed unexpected error: %v", err)
+	}
+
+	//工作量不足
+	bad := &SyscoinBlockHeader{BlockHeader: a1.BlockHeader}
+	bad.PrevBlock = a1.BlockHash()
+	target := blockchain.CompactToBig(bad.Bits)
+	for {
+		hash := bad.BlockHash()
+		if blockchain.HashToBig(&hash).Cmp(target) > 0 {
+			break
+		}
+		bad.Nonce++
+	}
+	if _, err := chain.Connect([]*SyscoinBlockHeader{bad}); err == nil {
+		t.Errorf("header with invalid proof of work should be rejected")
+	}
+
+	//未链接的区块头
+	if _, err := chain.Connect([]*SyscoinBlockHeader{newHeader(chainhash.Hash{0x03}, 0)}); err == nil {
+		t.Errorf("header not linked to chain should be rejected")
+	}
+
+	//版本号带合并挖矿标记但缺少AuxPow
+	noAux := newHeader(a1.BlockHash(), 0)
+	noAux.Version |= auxPowVersionFlag
+	testMineHeader(&noAux.BlockHeader)
+	if _, err := chain.Connect([]*SyscoinBlockHeader{noAux}); err == nil {
+		t.Errorf("auxpow header without auxpow should be rejected")
+	}
+
+	//工作量更大的分叉链替换主链
+	b1 := newHeader(checkpoint, 1000000)
+	b2 := newHeader(b1.BlockHash(), 0)
+	n, err := chain.Connect([]*SyscoinBlockHeader{b1, b2})
+	if err != nil {
+		t.Fatalf("Connect fork failed unexpected error: %v", err)
+	}
+	if n != 2 || chain.Tip().Hash != b2.BlockHash() {
+		t.Errorf("fork chain should become main chain")
+	}
+	a1Hash := a1.BlockHash()
+	if _, ok := chain.HeaderByHash(&a1Hash); ok {
+		t.Errorf("replaced header should be removed from main chain")
+	}
+}
+
+func TestAuxPow_Check(t *testing.T) {
+
+	header := testAuxPowHeader(chainhash.Hash{0x04}, time.Now())
+
+	var buf bytes.Buffer
+	err := header.Serialize(&buf)
+	if err != nil {
+		t.Fatalf("Serialize failed unexpected error: %v", err)
+	}
+
+	parsed, err := ParseBlockHeader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseBlockHeader failed unexpected error: %v", err)
+	}
+	if parsed.BlockHash() != header.BlockHash() || parsed.AuxPow == nil {
+		t.Fatalf("parsed header mismatch")
+	}
+
+	err = parsed.CheckProofOfWork(SyscoinRegTestParams)
+	if err != nil {
+		t.Fatalf("CheckProofOfWork failed unexpected error: %v", err)
+	}
+
+	//承诺的不是本区块
+	if err = parsed.AuxPow.Check(chainhash.Hash{0x05}, SyscoinRegTestParams.AuxPowChainID); err == nil {
+		t.Errorf("auxpow committed to another block should be rejected")
+	}
+
+	//链ID不匹配
+	params := *SyscoinRegTestParams
+	params.AuxPowChainID = 0x0001
+	if err = parsed.CheckProofOfWork(&params); err == nil {
+		t.Errorf("auxpow with wrong chain id should be rejected")
+	}
+
+	//coinbase不在父链区块中
+	parsed.AuxPow.ParentBlock.MerkleRoot = chainhash.Hash{0x06}
+	if err = parsed.AuxPow.Check(parsed.BlockHash(), SyscoinRegTestParams.AuxPowChainID); err == nil {
+		t.Errorf("auxpow with wrong parent merkle root should be rejected")
+	}
+
+	if _, err = ParseBlockHeader(append(buf.Bytes(), 0x00)); err == nil {
+		t.Errorf("header with trailing bytes should be rejected")
+	}
+}
+
+func TestP2PNetParams(t *testing.T) {
+
+	wm := NewWalletManager()
+
+	wm.Config.IsTestNet = true
+	if wm.P2PNetParams() != SyscoinTestNetParams {
+		t.Errorf("testnet params is not selected")
+	}
+
+	wm.Config.P2PNetwork = "regtest"
+	if err := wm.checkP2PNetParams(); err == nil {
+		t.Errorf("regtest without magic and port should fail")
+	}
+
+	wm.Config.P2PMagic = "fabfb5da"
+	wm.Config.P2PPort = "18444"
+	if err := wm.checkP2PNetParams(); err != nil {
+		t.Fatalf("checkP2PNetParams failed unexpected error: %v", err)
+	}
+	params := wm.P2PNetParams()
+	if params.Net != wire.BitcoinNet(0xdab5bffa) || params.DefaultPort != "18444" || params.PowLimit != SyscoinRegTestParams.PowLimit {
+		t.Errorf("unexpected params: %+v", params)
+	}
+	if SyscoinRegTestParams.Net != 0 {
+		t.Errorf("regtest params should not be changed")
+	}
+
+	wm.Config.P2PMagic = "fabfb5"
+	if err := wm.checkP2PNetParams(); err == nil {
+		t.Errorf("invalid magic should fail")
+	}
+}
+
+func TestP2PBackend_SyncHeadersWithoutCheckpoint(t *testing.T) {
+
+	wm := NewWalletManager()
+	backend := NewP2PBackend(wm, "127.0.0.1:1")
+
+	if _, err := backend.SyncHeaders(); err == nil {
+		t.Errorf("SyncHeaders without checkpoint should fail")
+	}
+	if _, err := backend.GetBlock(chainhash.Hash{0x01}.String()); err == nil {
+		t.Errorf("GetBlock without validated header chain should fail")
+	}
+}
+
+func TestBTCBlockScanner_P2PBlockSource(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.P2PNetwork = "regtest"
+	wm.Config.P2PBlockSource = true
+	wm.P2P = NewP2PBackend(wm, "127.0.0.1:1")
+	bs := &BTCBlockScanner{wm: wm, BlockScannerBase: openwallet.NewBlockScannerBase()}
+
+	if _, err := bs.getScanBlockHash(11); err == nil {
+		t.Errorf("getScanBlockHash without checkpoint should fail")
+	}
+
+	checkpoint := chainhash.Hash{0x03}
+	if err := wm.P2P.SetCheckpoint(10, checkpoint.String()); err != nil {
+		t.Fatalf("SetCheckpoint failed unexpected error: %v", err)
+	}
+
+	header := testAuxPowHeader(checkpoint, time.Unix(time.Now().Unix(), 0))
+	if _, err := wm.P2P.headerChain().Connect([]*SyscoinBlockHeader{header}); err != nil {
+		t.Fatalf("Connect failed unexpected error: %v", err)
+	}
+
+	hash, err := bs.getScanBlockHash(11)
+	if err != nil {
+		t.Fatalf("getScanBlockHash failed unexpected error: %v", err)
+	}
+	if hash != header.BlockHash().String() {
+		t.Errorf("hash: %s, want %s", hash, header.BlockHash().String())
+	}
+	if _, err := bs.getScanBlockHash(12); err == nil {
+		t.Errorf("height above header chain should fail")
+	}
+
+	if _, err := bs.getFullBlock(hash); err == nil {
+		t.Errorf("getFullBlock should download the block from p2p peer")
+	}
+
+	block, err := bs.getP2PBlockHeader(hash)
+	if err != nil {
+		t.Fatalf("getP2PBlockHeader failed unexpected error: %v", err)
+	}
+	if block.Height != 11 || block.Previousblockhash != checkpoint.String() || block.AuxPow == nil || block.Confirmations != 1 {
+		t.Errorf("unexpected block: %+v", block)
+	}
+}
+
+func TestNewTxByWire(t *testing.T) {
+
+	wm := NewWalletManager()
+	bs := &BTCBlockScanner{wm: wm, BlockScannerBase: openwallet.NewBlockScannerBase()}
+
+	p2pkh := append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x11}, 20)...)
+	p2pkh = append(p2pkh, 0x88, 0xac)
+	p2wpkh := append([]byte{0x00, 0x14}, bytes.Repeat([]byte{0x22}, 20)...)
+
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x01, 0x0b}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, p2pkh))
+
+	spend := wire.NewMsgTx(2)
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x04}, 1), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(12345678, p2wpkh))
+	spend.AddTxOut(wire.NewTxOut(0, []byte{0x6a, 0x01, 0x00}))
+
+	tx, err := wm.newTxByWire(coinbase)
+	if err != nil {
+		t.Fatalf("newTxByWire failed unexpected error: %v", err)
+	}
+	if !tx.IsCoinBase || tx.Vins[0].Coinbase != "010b" || tx.Vouts[0].Value != "50" || tx.Vouts[0].Type != "pubkeyhash" {
+		t.Errorf("unexpected coinbase tx: %+v %+v %+v", tx, tx.Vins[0], tx.Vouts[0])
+	}
+	if script, err := wm.addressScriptPubKey(tx.Vouts[0].Addr); err != nil || !bytes.Equal(script, p2pkh) {
+		t.Errorf("p2pkh address: %s does not match script", tx.Vouts[0].Addr)
+	}
+
+	tx, err = wm.newTxByWire(spend)
+	if err != nil {
+		t.Fatalf("newTxByWire failed unexpected error: %v", err)
+	}
+	if tx.TxID != spend.TxHash().String() || tx.IsCoinBase || tx.Vins[0].TxID != (chainhash.Hash{0x04}).String() || tx.Vins[0].Vout != 1 {
+		t.Errorf("unexpected tx: %+v %+v", tx, tx.Vins[0])
+	}
+	if tx.Vouts[0].Value != "0.12345678" || tx.Vouts[0].Type != "witness_v0_keyhash" || tx.Vouts[1].Type != "nulldata" || len(tx.Vouts[1].Addr) > 0 {
+		t.Errorf("unexpected vouts: %+v %+v", tx.Vouts[0], tx.Vouts[1])
+	}
+	if script, err := wm.addressScriptPubKey(tx.Vouts[0].Addr); err != nil || !bytes.Equal(script, p2wpkh) {
+		t.Errorf("bech32 address: %s does not match script", tx.Vouts[0].Addr)
+	}
+
+	//提取期间从区块缓存获取交易，返回副本
+	bs.cacheBlockTxs([]*Transaction{tx})
+	cached, err := bs.getScanTransaction(tx.TxID)
+	if err != nil || cached == tx || cached.Vouts[0] == tx.Vouts[0] || cached.TxID != tx.TxID {
+		t.Errorf("getScanTransaction should return a copy of cached tx")
+	}
+	bs.uncacheBlockTxs([]*Transaction{tx})
+	if len(bs.blockTxs) != 0 {
+		t.Errorf("block txs cache should be empty")
+	}
+}
+
+func TestWalletManager_GetBlockAuxPow(t *testing.T) {
+
+	header := testAuxPowHeader(chainhash.Hash{0x05}, time.Unix(time.Now().Unix(), 0))
+	var raw bytes.Buffer
+	if err := header.Serialize(&raw); err != nil {
+		t.Fatalf("Serialize failed unexpected error: %v", err)
+	}
+	hash := header.BlockHash().String()
+
+	headerCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch gjson.GetBytes(body, "method").String() {
+		case "getblock":
+			fmt.Fprintf(w, `{"result":{"hash":"%s","height":11,"previousblockhash":"%s","tx":["aa"]},"error":null,"id":"1"}`,
+				hash, header.PrevBlock.String())
+		case "getblockheader":
+			headerCalls++
+			fmt.Fprintf(w, `{"result":"%x","error":null,"id":"1"}`, raw.Bytes())
+		}
+	}))
+	defer server.Close()
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerCore
+	wm.WalletClient = NewClient(server.URL, "", false)
+
+	//扫块获取区块不解析原始区块头
+	block, err := wm.GetBlock(hash)
+	if err != nil {
+		t.Fatalf("GetBlock failed unexpected error: %v", err)
+	}
+	if len(block.tx) != 1 || block.Height != 11 || headerCalls != 0 {
+		t.Errorf("unexpected block: %+v, getblockheader calls: %d", block, headerCalls)
+	}
+
+	rawHeader, err := wm.GetRawBlockHeader(hash)
+	if err != nil {
+		t.Fatalf("GetRawBlockHeader failed unexpected error: %v", err)
+	}
+	if rawHeader.AuxPow == nil || rawHeader.AuxPow.ParentBlock.BlockHash() != header.AuxPow.ParentBlock.BlockHash() {
+		t.Errorf("raw header should have auxpow")
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+/******************* 交易包含在区块中的SPV默克尔证明 *******************/
+
+//MerkleBlock gettxoutproof返回的默克尔区块，包含区块头和部分默克尔树
+type MerkleBlock struct {
+	Header       SyscoinBlockHeader
+	Transactions uint32
+	Hashes       []chainhash.Hash
+	Flags        []byte
+}
+
+//ParseMerkleBlock 解析默克尔区块
+func ParseMerkleBlock(raw []byte) (*MerkleBlock, error) {
+
+	r := bytes.NewReader(raw)
+
+	mb := &MerkleBlock{}
+	err := mb.Header.Deserialize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var total [4]byte
+	_, err = io.ReadFull(r, total[:])
+	if err != nil {
+		return nil, err
+	}
+	mb.Transactions = binary.LittleEndian.Uint32(total[:])
+
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(mb.Transactions) {
+		return nil, errors.New("merkle block has more hashes than transactions")
+	}
+
+	mb.Hashes = make([]chainhash.Hash, count)
+	for i := range mb.Hashes {
+		_, err = io.ReadFull(r, mb.Hashes[i][:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mb.Flags, err = wire.ReadVarBytes(r, 0, wire.MaxBlockPayload, "merkle block flags")
+	if err != nil {
+		return nil, err
+	}
+
+	return mb, nil
+}
+
+//ExtractMatches 遍历部分默克尔树，返回默克尔根和证明包含的交易
+func (mb *MerkleBlock) ExtractMatches() (chainhash.Hash, []chainhash.Hash, error) {
+
+	if mb.Transactions == 0 {
+		return chainhash.Hash{}, nil, errors.New("merkle block has no transactions")
+	}
+
+	if len(mb.Hashes) > int(mb.Transactions) {
+		return chainhash.Hash{}, nil, errors.New("merkle block has more hashes than transactions")
+	}
+
+	if len(mb.Flags)*8 < len(mb.Hashes) {
+		return chainhash.Hash{}, nil, errors.New("merkle block has fewer flag bits than hashes")
+	}
+
+	height := uint(0)
+	for mb.treeWidth(height) > 1 {
+		height++
+	}
+
+	t := &merkleTraversal{mb: mb, matches: make([]chainhash.Hash, 0)}
+	root, err := t.traverse(height, 0)
+	if err != nil {
+		return chainhash.Hash{}, nil, err
+	}
+
+	if (t.bitsUsed+7)/8 != len(mb.Flags) {
+		return chainhash.Hash{}, nil, errors.New("merkle block has unused flag bits")
+	}
+
+	if t.hashUsed != len(mb.Hashes) {
+		return chainhash.Hash{}, nil, errors.New("merkle block has unused hashes")
+	}
+
+	return root, t.matches, nil
+}
+
+//treeWidth 默克尔树指定层的节点数量
+func (mb *MerkleBlock) treeWidth(height uint) uint32 {
+	return uint32((uint64(mb.Transactions) + (1 << height) - 1) >> height)
+}
+
+//merkleTraversal 部分默克尔树的深度优先遍历状态
+type merkleTraversal struct {
+	mb       *MerkleBlock
+	bitsUsed int
+	hashUsed int
+	matches  []chainhash.Hash
+}
+
+func (t *merkleTraversal) traverse(height uint, pos uint32) (chainhash.Hash, error) {
+
+	if t.bitsUsed >= len(t.mb.Flags)*8 {
+		return chainhash.Hash{}, errors.New("merkle block flag bits overflow")
+	}
+	bit := t.mb.Flags[t.bitsUsed/8]>>(uint(t.bitsUsed)%8)&1 == 1
+	t.bitsUsed++
+
+	if height == 0 || !bit {
+		if t.hashUsed >= len(t.mb.Hashes) {
+			return chainhash.Hash{}, errors.New("merkle block hashes overflow")
+		}
+		hash := t.mb.Hashes[t.hashUsed]
+		t.hashUsed++
+		if height == 0 && bit {
+			t.matches = append(t.matches, hash)
+		}
+		return hash, nil
+	}
+
+	left, err := t.traverse(height-1, pos*2)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	right := left
+	if pos*2+1 < t.mb.treeWidth(height-1) {
+		right, err = t.traverse(height-1, pos*2+1)
+		if err != nil {
+			return chainhash.Hash{}, err
+		}
+		//左右节点相同时可以伪造交易列表(CVE-2012-2459)
+		if right == left {
+			return chainhash.Hash{}, errors.New("merkle block has duplicate nodes")
+		}
+	}
+
+	return hashMerkleNode(&left, &right), nil
+}
+
+//GetTxOutProof 获取交易在区块中的默克尔证明
+func (wm *WalletManager) GetTxOutProof(txid, blockHash string) ([]byte, error) {
+	return wm.getTxOutProof(wm.WalletClient, txid, blockHash)
+}
+
+func (wm *WalletManager) getTxOutProof(client *Client, txid, blockHash string) ([]byte, error) {
+
+	request := []interface{}{
+		[]string{txid},
+		blockHash,
+	}
+
+	result, err := client.Call("gettxoutproof", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(result.String())
+}
+
+//VerifyTxInclusion 验证交易包含在区块中
+//核心钱包接口使用gettxoutproof，浏览器模式配置了auditServerAPI时使用其gettxoutproof，否则根据区块的交易列表计算默克尔根
+//开启P2P节点时，区块头以P2P同步并验证过的区块头链为准
+//浏览器接口不提供原始区块头，必须有P2P验证过的区块头，否则无法验证
+func (wm *WalletManager) VerifyTxInclusion(txid, blockHash string) error {
+
+	hash, err := chainhash.NewHashFromStr(blockHash)
+	if err != nil {
+		return err
+	}
+
+	var trustedRoot *chainhash.Hash
+	if wm.P2P != nil {
+		if header, ok := wm.P2P.chainHeader(hash); ok && header.Header != nil {
+			trustedRoot = &header.Header.MerkleRoot
+		}
+	}
+
+	var root chainhash.Hash
+	if client := wm.auditCoreClient(); client != nil {
+		root, err = wm.verifyTxOutProof(client, txid, hash)
+	} else {
+		if trustedRoot == nil {
+			return fmt.Errorf("block: %s is not in p2p header chain, no proof-of-work verified header", blockHash)
+		}
+		root, err = wm.verifyTxInBlockList(txid, blockHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if trustedRoot != nil && root != *trustedRoot {
+		return fmt.Errorf("block: %s merkle root does not match p2p header chain", blockHash)
+	}
+
+	return nil
+}
+
+//verifyTxOutProof 使用gettxoutproof验证，返回区块头的默克尔根
+func (wm *WalletManager) verifyTxOutProof(client *Client, txid string, blockHash *chainhash.Hash) (chainhash.Hash, error) {
+
+	raw, err := wm.getTxOutProof(client, txid, blockHash.String())
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	mb, err := ParseMerkleBlock(raw)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	if mb.Header.BlockHash() != *blockHash {
+		return chainhash.Hash{}, fmt.Errorf("merkle proof block: %s is not %s", mb.Header.BlockHash().String(), blockHash.String())
+	}
+
+	err = mb.Header.CheckProofOfWork(wm.P2PNetParams())
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	root, matches, err := mb.ExtractMatches()
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	if root != mb.Header.MerkleRoot {
+		return chainhash.Hash{}, fmt.Errorf("merkle proof root of block: %s mismatch", blockHash.String())
+	}
+
+	for _, m := range matches {
+		if m.String() == txid {
+			return root, nil
+		}
+	}
+
+	return chainhash.Hash{}, fmt.Errorf("merkle proof does not include transaction: %s", txid)
+}
+
+//verifyTxInBlockList 根据区块的交易列表计算默克尔根并检查交易在列表中，返回的默克尔根需要与已验证的区块头比较
+func (wm *WalletManager) verifyTxInBlockList(txid, blockHash string) (chainhash.Hash, error) {
+
+	block, err := wm.GetBlock(blockHash)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+
+	found := false
+	hashes := make([]chainhash.Hash, 0, len(block.tx))
+	for _, id := range block.tx {
+		h, err := chainhash.NewHashFromStr(id)
+		if err != nil {
+			return chainhash.Hash{}, err
+		}
+		hashes = append(hashes, *h)
+		if id == txid {
+			found = true
+		}
+	}
+
+	if !found {
+		return chainhash.Hash{}, fmt.Errorf("block: %s does not include transaction: %s", blockHash, txid)
+	}
+
+	root := calcMerkleRoot(hashes)
+	if root.String() != block.Merkleroot {
+		return chainhash.Hash{}, fmt.Errorf("block: %s transaction list does not match merkle root", blockHash)
+	}
+
+	return root, nil
+}
+
+//verifyTxInclusion 验证充值交易的默克尔证明，失败时标记数据源不可信
+func (bs *BTCBlockScanner) verifyTxInclusion(txid, blockHash string) error {
+
+	err := bs.wm.VerifyTxInclusion(txid, blockHash)
+	if err != nil {
+		bs.trustMu.Lock()
+		bs.untrustedReason = fmt.Sprintf("transaction: %s merkle proof failed, %v", txid, err)
+		bs.trustMu.Unlock()
+		bs.wm.Log.Errorf("backend is untrusted, transaction: %s merkle proof failed, %v", txid, err)
+		return err
+	}
+
+	return nil
+}
+
+//IsBackendTrusted 数据源是否可信，充值交易的默克尔证明验证失败后标记为不可信，不可信期间暂停充值通知
+func (bs *BTCBlockScanner) IsBackendTrusted() (bool, string) {
+	bs.trustMu.Lock()
+	defer bs.trustMu.Unlock()
+	return len(bs.untrustedReason) == 0, bs.untrustedReason
+}
+
+//ResetBackendTrust 人工确认数据源后，清除不可信标记
+func (bs *BTCBlockScanner) ResetBackendTrust() {
+	bs.trustMu.Lock()
+	defer bs.trustMu.Unlock()
+	bs.untrustedReason = ""
+}
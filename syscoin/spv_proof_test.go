@@ -0,0 +1,138 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestMerkleBlock_ExtractMatches(t *testing.T) {
+
+	txs := []chainhash.Hash{{0x01}, {0x02}, {0x03}}
+
+	header := wire.BlockHeader{Version: 4, MerkleRoot: calcMerkleRoot(txs), Bits: testRegTestBits}
+	testMineHeader(&header)
+
+	//3笔交易证明第2笔：根、左子树展开，右子树只给出hash
+	right := hashMerkleNode(&txs[2], &txs[2])
+	hashes := []chainhash.Hash{txs[0], txs[1], right}
+
+	build := func(hashes []chainhash.Hash, flags []byte) []byte {
+		var buf bytes.Buffer
+		header.Serialize(&buf)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(txs)))
+		wire.WriteVarInt(&buf, 0, uint64(len(hashes)))
+		for i := range hashes {
+			buf.Write(hashes[i][:])
+		}
+		wire.WriteVarBytes(&buf, 0, flags)
+		return buf.Bytes()
+	}
+
+	mb, err := ParseMerkleBlock(build(hashes, []byte{0x0b}))
+	if err != nil {
+		t.Fatalf("ParseMerkleBlock failed unexpected error: %v", err)
+	}
+
+	root, matches, err := mb.ExtractMatches()
+	if err != nil {
+		t.Fatalf("ExtractMatches failed unexpected error: %v", err)
+	}
+	if root != header.MerkleRoot {
+		t.Errorf("merkle root: %s, want %s", root.String(), header.MerkleRoot.String())
+	}
+	if len(matches) != 1 || matches[0] != txs[1] {
+		t.Errorf("unexpected matches: %v", matches)
+	}
+
+	//多余的标记位
+	mb, _ = ParseMerkleBlock(build(hashes, []byte{0x0b, 0x00}))
+	if _, _, err = mb.ExtractMatches(); err == nil {
+		t.Errorf("merkle block with unused flag bits should be rejected")
+	}
+
+	//hash数量多于交易数量
+	if _, err = ParseMerkleBlock(build(append(hashes[:3:3], txs[0]), []byte{0x0b})); err == nil {
+		t.Errorf("merkle block with more hashes than transactions should be rejected")
+	}
+
+	//被篡改的交易不再匹配默克尔根
+	mb, _ = ParseMerkleBlock(build([]chainhash.Hash{txs[0], {0x09}, right}, []byte{0x0b}))
+	root, _, err = mb.ExtractMatches()
+	if err != nil {
+		t.Fatalf("ExtractMatches failed unexpected error: %v", err)
+	}
+	if root == header.MerkleRoot {
+		t.Errorf("tampered merkle block should not match merkle root")
+	}
+}
+
+func TestVerifyTxInclusion_ExplorerRequiresVerifiedHeader(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.RPCServerType = RPCServerExplorer
+
+	//没有P2P验证过的区块头，不能只信任浏览器返回的默克尔根
+	err := wm.VerifyTxInclusion(chainhash.Hash{0x01}.String(), chainhash.Hash{0x02}.String())
+	if err == nil {
+		t.Errorf("explorer mode without verified header should fail")
+	}
+}
+
+func TestExtractTransaction_HoldWhenUntrusted(t *testing.T) {
+
+	wm := NewWalletManager()
+	bs := &BTCBlockScanner{wm: wm, BlockScannerBase: openwallet.NewBlockScannerBase()}
+
+	script := append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{0x11}, 20)...)
+	script = append(script, 0x88, 0xac)
+	msgTx := wire.NewMsgTx(1)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x01}, nil))
+	msgTx.AddTxOut(wire.NewTxOut(100000000, script))
+
+	tx, err := wm.newTxByWire(msgTx)
+	if err != nil {
+		t.Fatalf("newTxByWire failed unexpected error: %v", err)
+	}
+	bs.cacheBlockTxs([]*Transaction{tx})
+
+	scanFunc := func(target openwallet.ScanTargetParam) openwallet.ScanTargetResult {
+		return openwallet.ScanTargetResult{SourceKey: "account", Exist: target.ScanTarget == tx.Vouts[0].Addr}
+	}
+
+	result := bs.ExtractTransaction(10, "hash", tx.TxID, scanFunc)
+	if !result.Success || len(result.extractData) == 0 {
+		t.Fatalf("deposit should be extracted, reason: %s", result.reason)
+	}
+
+	bs.untrustedReason = "merkle proof failed"
+	result = bs.ExtractTransaction(10, "hash", tx.TxID, scanFunc)
+	if result.Success {
+		t.Errorf("deposit should be held when backend is untrusted")
+	}
+
+	bs.ResetBackendTrust()
+	result = bs.ExtractTransaction(10, "hash", tx.TxID, scanFunc)
+	if !result.Success {
+		t.Errorf("deposit should be extracted after trust reset, reason: %s", result.reason)
+	}
+}
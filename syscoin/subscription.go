@@ -0,0 +1,151 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	defaultSubscribeMinBackoff = 1 * time.Second
+	defaultSubscribeMaxBackoff = 2 * time.Minute
+)
+
+//SubscribeFunc 订阅连接的执行过程，连接成功后调用connected，阻塞直到断线或ctx被取消
+type SubscribeFunc func(ctx context.Context, connected func()) error
+
+//SubscriptionManager 推送订阅管理，负责订阅线程的启动、断线重连和停止
+type SubscriptionManager struct {
+	MinBackoff time.Duration //重连的最小等待时间
+	MaxBackoff time.Duration //重连的最大等待时间
+
+	mu     sync.Mutex
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	log    *log.OWLogger
+}
+
+//NewSubscriptionManager 创建推送订阅管理
+func NewSubscriptionManager(logger *log.OWLogger) *SubscriptionManager {
+	return &SubscriptionManager{
+		MinBackoff: defaultSubscribeMinBackoff,
+		MaxBackoff: defaultSubscribeMaxBackoff,
+		log:        logger,
+	}
+}
+
+//context 获取当前运行的上下文，未运行时创建新的上下文
+func (m *SubscriptionManager) context() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.ctx == nil {
+		m.ctx, m.cancel = context.WithCancel(context.Background())
+	}
+	return m.ctx
+}
+
+//Running 是否有订阅运行中
+func (m *SubscriptionManager) Running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ctx != nil
+}
+
+//Subscribe 启动一个订阅线程，断线后按指数退避自动重连
+func (m *SubscriptionManager) Subscribe(name string, fn SubscribeFunc) {
+
+	ctx := m.context()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		var (
+			backoff      = m.MinBackoff
+			hasConnected int32
+		)
+
+		connected := func() {
+			atomic.StoreInt32(&hasConnected, 1)
+		}
+
+		for {
+			err := fn(ctx, connected)
+
+			if ctx.Err() != nil {
+				m.log.Infof("%s subscription has been stopped", name)
+				return
+			}
+
+			//连接成功过，重置等待时间
+			if atomic.SwapInt32(&hasConnected, 0) == 1 {
+				backoff = m.MinBackoff
+			}
+
+			if err != nil {
+				m.log.Errorf("%s subscription disconnected, unexpected error: %v", name, err)
+			}
+
+			//重新连接，前等待
+			m.log.Info("Auto reconnect", name, "after", backoff, "...")
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				m.log.Infof("%s subscription has been stopped", name)
+				return
+			}
+
+			backoff = backoff * 2
+			if backoff > m.MaxBackoff {
+				backoff = m.MaxBackoff
+			}
+		}
+	}()
+}
+
+//Go 启动一个随订阅生命周期运行的辅助线程
+func (m *SubscriptionManager) Go(fn func(ctx context.Context)) {
+	ctx := m.context()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn(ctx)
+	}()
+}
+
+//Stop 取消所有订阅，并等待线程退出；没有运行的订阅时直接返回
+func (m *SubscriptionManager) Stop() {
+	m.mu.Lock()
+	cancel := m.cancel
+	m.ctx = nil
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	m.wg.Wait()
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+func TestSubscriptionManager_StopWithoutSubscribe(t *testing.T) {
+	m := NewSubscriptionManager(log.NewOWLogger("SYS"))
+
+	done := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Stop blocked without running subscriptions")
+	}
+}
+
+func TestSubscriptionManager_Reconnect(t *testing.T) {
+	m := NewSubscriptionManager(log.NewOWLogger("SYS"))
+	m.MinBackoff = 10 * time.Millisecond
+	m.MaxBackoff = 20 * time.Millisecond
+
+	var attempts int32
+	m.Subscribe("test", func(ctx context.Context, connected func()) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("connect failed")
+		}
+		connected()
+		<-ctx.Done()
+		return nil
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	m.Stop()
+
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("unexpected attempts: %d", n)
+	}
+	if m.Running() {
+		t.Errorf("subscription manager still running after stop")
+	}
+}
+
+func TestNotifyDedup(t *testing.T) {
+	d := newNotifyDedup()
+	window := time.Hour
+
+	d.Mark("txid", 0, window)
+	if !d.Seen("txid", 0, window) {
+		t.Errorf("mempool tx should be seen")
+	}
+	if !d.Seen("txid", 100, window) {
+		t.Errorf("mempool tx should not be notified again when confirmed")
+	}
+	if d.Seen("txid", 0, 0) {
+		t.Errorf("dedup should be disabled when window is 0")
+	}
+
+	//重扫确认高度后重新通知
+	d.ClearHeights(101, math.MaxUint64)
+	if !d.Seen("txid", 100, window) {
+		t.Errorf("tx confirmed below the rescan height should be seen")
+	}
+	d.ClearHeights(100, 100)
+	if d.Seen("txid", 100, window) {
+		t.Errorf("tx of the rescanned height should be notified again")
+	}
+
+	d.Mark("other", 0, window)
+	d.ClearHeights(0, math.MaxUint64)
+	if !d.Seen("other", 0, window) {
+		t.Errorf("unconfirmed tx should not be cleared by rescan")
+	}
+}
@@ -16,6 +16,9 @@
 package syscoin
 
 import (
+	"errors"
+	"time"
+
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
@@ -104,6 +107,77 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.MinFees, _ = decimal.NewFromString(c.String("minFees"))
 	wm.Config.MinFees = wm.Config.MinFees.Round(wm.Decimal())
 	wm.Config.DataDir = c.String("dataDir")
+	wm.Config.ZMQAPI = c.String("zmqAPI")
+	wm.Config.P2PAPI = c.String("p2pAPI")
+	wm.Config.P2PCheckpoint = c.String("p2pCheckpoint")
+	wm.Config.P2PNetwork = c.String("p2pNetwork")
+	wm.Config.P2PMagic = c.String("p2pMagic")
+	wm.Config.P2PPort = c.String("p2pPort")
+	wm.Config.P2PBlockSource, _ = c.Bool("p2pBlockSource")
+	wm.Config.VerifyMerkleProof, _ = c.Bool("verifyMerkleProof")
+	wm.Config.AuditServerAPI = c.String("auditServerAPI")
+	wm.Config.ChangeAddressPolicy = c.String("changeAddressPolicy")
+	wm.Config.ChangeAddress = c.String("changeAddress")
+	if dustRelayFee, err := decimal.NewFromString(c.String("dustRelayFee")); err == nil {
+		wm.Config.DustRelayFee = dustRelayFee
+	}
+	if outputOrder := c.String("outputOrder"); len(outputOrder) > 0 {
+		wm.Config.OutputOrder = outputOrder
+	}
+	if leaseSeconds, err := c.Int64("utxoLeaseSeconds"); err == nil && leaseSeconds > 0 {
+		wm.Config.UTXOLeaseExpiry = time.Duration(leaseSeconds) * time.Second
+	}
+	if minConfirms, err := c.Int64("minForeignConfirms"); err == nil && minConfirms >= 0 {
+		wm.Config.MinForeignConfirms = uint64(minConfirms)
+	}
+	if allowChange, err := c.Bool("allowUnconfirmedChange"); err == nil {
+		wm.Config.AllowUnconfirmedChange = allowChange
+	}
+	if maxDepth, err := c.Int("maxUnconfirmedDepth"); err == nil && maxDepth > 0 {
+		wm.Config.MaxUnconfirmedDepth = maxDepth
+	}
+	if trackSeconds, err := c.Int64("outboundTrackSeconds"); err == nil && trackSeconds > 0 {
+		wm.Config.OutboundTrackSeconds = time.Duration(trackSeconds) * time.Second
+	}
+	if confirmations, err := c.Int64("outboundConfirmations"); err == nil && confirmations > 0 {
+		wm.Config.OutboundConfirmations = uint64(confirmations)
+	}
+	if retentionHours, err := c.Int64("outboundRetentionHours"); err == nil && retentionHours > 0 {
+		wm.Config.OutboundRetention = time.Duration(retentionHours) * time.Hour
+	}
+	if minRelayFeeRate, err := decimal.NewFromString(c.String("minRelayFeeRate")); err == nil {
+		wm.Config.MinRelayFeeRate = minRelayFeeRate
+	}
+	if maxFeeRate, err := decimal.NewFromString(c.String("maxFeeRate")); err == nil {
+		wm.Config.MaxFeeRate = maxFeeRate
+	}
+	if maxFee, err := decimal.NewFromString(c.String("maxFee")); err == nil {
+		wm.Config.MaxFee = maxFee
+	}
+	if maxFeeRatio, err := decimal.NewFromString(c.String("maxFeeRatio")); err == nil {
+		wm.Config.MaxFeeRatio = maxFeeRatio
+	}
+	if feePriority := c.String("feePriority"); len(feePriority) > 0 {
+		wm.Config.FeePriority = feePriority
+	}
+	if target, err := c.Int("fastFeeTarget"); err == nil && target > 0 {
+		wm.Config.FastFeeTarget = target
+	}
+	if target, err := c.Int("normalFeeTarget"); err == nil && target > 0 {
+		wm.Config.NormalFeeTarget = target
+	}
+	if target, err := c.Int("economyFeeTarget"); err == nil && target > 0 {
+		wm.Config.EconomyFeeTarget = target
+	}
+	wm.Config.OmniFuelAmount, _ = decimal.NewFromString(c.String("omniFuelAmount"))
+	if cycleSeconds, err := c.Int64("omniFuelCycleSeconds"); err == nil && cycleSeconds > 0 {
+		wm.Config.OmniFuelCycleSeconds = time.Duration(cycleSeconds) * time.Second
+	}
+	wm.Config.ConsolidateMinUTXOs, _ = c.Int("consolidateMinUTXOs")
+	wm.Config.ConsolidateMaxFeeRate, _ = decimal.NewFromString(c.String("consolidateMaxFeeRate"))
+	if cycleSeconds, err := c.Int64("consolidateCycleSeconds"); err == nil && cycleSeconds > 0 {
+		wm.Config.ConsolidateCycleSeconds = time.Duration(cycleSeconds) * time.Second
+	}
 
 	//数据文件夹
 	wm.Config.makeDataDir()
@@ -111,14 +185,67 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	token := BasicAuth(wm.Config.RpcUser, wm.Config.RpcPassword)
 	omniToken := BasicAuth(wm.Config.OmniRPCUser, wm.Config.OmniRPCPassword)
 
-	if wm.Config.RPCServerType == RPCServerCore {
+	if wm.Config.RPCServerType == RPCServerCore || wm.Config.RPCServerType == RPCServerCFilter {
 		wm.WalletClient = NewClient(wm.Config.ServerAPI, token, false)
 	} else {
 		wm.ExplorerClient = NewExplorer(wm.Config.ServerAPI, false)
 	}
 
+	//一致性检查的另一个数据源
+	if len(wm.Config.AuditServerAPI) > 0 {
+		if wm.Config.RPCServerType == RPCServerExplorer {
+			wm.AuditClient = NewClient(wm.Config.AuditServerAPI, token, false)
+		} else {
+			wm.ExplorerClient = NewExplorer(wm.Config.AuditServerAPI, false)
+		}
+	}
+
 	wm.OnmiClient = NewClient(wm.Config.OmniCoreAPI, omniToken, false)
 
+	if len(wm.Config.P2PAPI) > 0 {
+		err := wm.checkP2PNetParams()
+		if err != nil {
+			return err
+		}
+		wm.P2P = NewP2PBackend(wm, wm.Config.P2PAPI)
+		if len(wm.Config.P2PCheckpoint) > 0 {
+			height, hash, err := parseP2PCheckpoint(wm.Config.P2PCheckpoint)
+			if err != nil {
+				return err
+			}
+			err = wm.P2P.SetCheckpoint(height, hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	//P2P区块来源只信任检查点之后已验证的区块头
+	if wm.Config.P2PBlockSource && (wm.P2P == nil || len(wm.Config.P2PCheckpoint) == 0) {
+		return errors.New("p2pBlockSource requires p2pAPI and p2pCheckpoint")
+	}
+
+	//广播交易单跟踪
+	wm.Outbound.Interval = wm.Config.OutboundTrackSeconds
+	wm.Outbound.Confirmations = wm.Config.OutboundConfirmations
+	wm.Outbound.Retention = wm.Config.OutboundRetention
+	wm.Outbound.Start()
+
+	//持币地址的手续费utxo补充，需要应用设置热钱包账户
+	if wm.Config.OmniSupport {
+		wm.OmniFuel.FuelAmount = wm.Config.OmniFuelAmount
+		wm.OmniFuel.Interval = wm.Config.OmniFuelCycleSeconds
+		wm.OmniFuel.Start()
+	}
+
+	//碎片utxo合并任务
+	if wm.Config.ConsolidateMinUTXOs > 0 {
+		wm.Consolidation.MinUTXOs = wm.Config.ConsolidateMinUTXOs
+		wm.Consolidation.MaxFeeRate = wm.Config.ConsolidateMaxFeeRate
+		wm.Consolidation.Interval = wm.Config.ConsolidateCycleSeconds
+		wm.Consolidation.Start()
+	}
+
 	return nil
 }
 
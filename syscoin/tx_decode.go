@@ -106,16 +106,31 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 	txid, err := decoder.wm.SendRawTransaction(rawTx.RawHex)
 	if err != nil {
 		decoder.wm.Log.Warningf("[Sid: %s] submit raw hex: %s", rawTx.Sid, rawTx.RawHex)
-		return nil, err
+		//广播失败，释放预留的utxo
+		decoder.releaseRawTxUTXO(rawTx)
+		return nil, broadcastError(err)
+	}
+
+	if leaseID := rawTx.GetExtParam().Get("leaseID").String(); len(leaseID) > 0 {
+		if leaseErr := decoder.wm.SetUTXOLeaseTxID(leaseID, txid); leaseErr != nil {
+			decoder.wm.Log.Errorf("update utxo lease: %s failed unexpected error: %v", leaseID, leaseErr)
+		}
 	}
 
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
+	//跟踪广播的交易单，未确认的找零可以继续使用
+	if decoder.wm.Outbound != nil {
+		if trackErr := decoder.wm.Outbound.Track(rawTx); trackErr != nil {
+			decoder.wm.Log.Errorf("track outbound tx: %s failed unexpected error: %v", txid, trackErr)
+		}
+	}
+
 	decimals := int32(0)
 	fees := "0"
 	if rawTx.Coin.IsContract {
-		decimals = int32(rawTx.Coin.Contract.Decimals)
+		decimals = decoder.wm.omniPropertyDecimals(&rawTx.Coin.Contract)
 		fees = "0"
 	} else {
 		decimals = int32(decoder.wm.Decimal())
@@ -145,9 +160,12 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 //CreateRawTransaction 创建交易单
 func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
+	//同一账户串行构建
+	defer lockAccountBuild(rawTx.Account.AccountID)()
+
 	var (
 		usedUTXO     []*Unspent
-		outputAddrs  = make(map[string]decimal.Decimal)
+		outputs      []*TxOutput
 		balance      = decimal.New(0, 0)
 		totalSend    = decimal.New(0, 0)
 		actualFees   = decimal.New(0, 0)
@@ -179,19 +197,39 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 		return err
 	}
 
+	//未确认输入策略
+	unspents = decoder.filterUnconfirmedUTXO(unspents, 0)
+
 	if len(unspents) == 0 {
 		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] balance is not enough", accountID)
 	}
 
-	if len(rawTx.To) == 0 {
+	//收款输出，同一地址可以有多个输出
+	outputs, err = decoder.rawTxOutputs(rawTx)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, err.Error())
+	}
+
+	if len(outputs) == 0 {
 		return errors.New("Receiver addresses is empty!")
 	}
 
+	//扩展参数给出的收款，汇总后记录到To
+	if len(rawTx.To) == 0 {
+		to := make(map[string]decimal.Decimal)
+		for _, out := range outputs {
+			to = appendOutput(to, out.Address, out.Amount)
+		}
+		rawTx.To = make(map[string]string)
+		for addr, amount := range to {
+			rawTx.To[addr] = amount.StringFixed(decoder.wm.Decimal())
+		}
+	}
+
 	//计算总发送金额
-	for addr, amount := range rawTx.To {
-		deamount, _ := decimal.NewFromString(amount)
-		totalSend = totalSend.Add(deamount)
-		destinations = append(destinations, addr)
+	for _, out := range outputs {
+		totalSend = totalSend.Add(out.Amount)
+		destinations = append(destinations, out.Address)
 		//计算账户的实际转账amount
 		//addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", addr)
 		//if findErr != nil || len(addresses) == 0 {
@@ -211,13 +249,15 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 		}
 	}})
 
-	if len(rawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	feesRate, err = decoder.wm.ResolveFeeRate(rawTx.FeeRate, rawTx.GetExtParam().Get("feePriority").String())
+	if err != nil {
+		return err
+	}
+
+	//花费手续费高于金额的utxo不使用
+	unspents = decoder.filterEconomicalUTXO(unspents, feesRate)
+	if len(unspents) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] balance is not enough", accountID)
 	}
 
 	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
@@ -265,16 +305,27 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 
 	}
 
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
+	//UTXO如果大于设定限制，需要通过CreateBatchRawTransaction分拆成多笔交易单发送
 	if len(usedUTXO) > decoder.wm.Config.MaxTxInputs {
 		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.MaxTxInputs)
 		return errors.New(errStr)
 	}
 
-	//取账户最后一个地址
-	changeAddress := usedUTXO[0].Address
+	//根据账户的策略选择找零地址
+	change, err := decoder.selectChangeAddress(wrapper, rawTx.Account, usedUTXO)
+	if err != nil {
+		return err
+	}
+	changeAddress := change.Address
 
 	changeAmount := balance.Sub(computeTotalSend).Sub(actualFees)
+	changeAmount, actualFees = decoder.foldDustChange(changeAddress, changeAmount, actualFees)
+
+	//手续费安全上限
+	if err = decoder.wm.CheckFees(actualFees, totalSend); err != nil {
+		return err
+	}
+
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = actualFees.StringFixed(decoder.wm.Decimal())
 
@@ -288,25 +339,19 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
 	decoder.wm.Log.Std.Notice("-----------------------------------------------")
 
-	//装配输出
-	for to, amount := range rawTx.To {
-		decamount, _ := decimal.NewFromString(amount)
-		outputAddrs = appendOutput(outputAddrs, to, decamount)
-		//outputAddrs[to] = amount
-	}
-
 	//changeAmount := balance.Sub(totalSend).Sub(actualFees)
 	if changeAmount.GreaterThan(decimal.New(0, 0)) {
-		outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+		outputs = append(outputs, &TxOutput{Address: changeAddress, Amount: changeAmount, IsChange: true})
+		rawTx.Change = change
 		//outputAddrs[changeAddress] = changeAmount.StringFixed(decoder.wm.Decimal())
 	}
 
-	err = decoder.createBTCRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+	err = decoder.createBTCRawTransaction(wrapper, rawTx, usedUTXO, outputs)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return decoder.commitChangeAddress(wrapper, rawTx)
 }
 
 //SignRawTransaction 签名交易单
@@ -439,6 +484,7 @@ func (decoder *TransactionDecoder) VerifyBTCRawTransaction(wrapper openwallet.Wa
 		return errors.New("Invalid transaction data! ")
 	}
 
+	totalInput := decimal.Zero
 	for _, vin := range trx.Vins {
 
 		utxo, err := decoder.wm.GetTxOut(vin.GetTxID(), uint64(vin.GetVout()))
@@ -453,6 +499,8 @@ func (decoder *TransactionDecoder) VerifyBTCRawTransaction(wrapper openwallet.Wa
 		}
 		txUnlocks = append(txUnlocks, txUnlock)
 
+		inputAmount, _ := decimal.NewFromString(utxo.Value)
+		totalInput = totalInput.Add(inputAmount)
 	}
 
 	//decoder.wm.Log.Debug(emptyTrans)
@@ -479,6 +527,14 @@ func (decoder *TransactionDecoder) VerifyBTCRawTransaction(wrapper openwallet.Wa
 	pass := btcTransaction.VerifyRawTransaction(signedTrans, txUnlocks, decoder.wm.Config.SupportSegWit, addressPrefix)
 	if pass {
 		decoder.wm.Log.Debug("transaction verify passed")
+
+		//广播前检查是否符合节点的中继策略
+		if policyErr := decoder.validateTxPolicy(signedTrans, totalInput, rawTxSendAmount(rawTx)); policyErr != nil {
+			decoder.wm.Log.Errorf("transaction policy check failed: %s", policyErr.Error())
+			rawTx.IsCompleted = false
+			return policyErr
+		}
+
 		rawTx.IsCompleted = true
 		rawTx.RawHex = signedTrans
 	} else {
@@ -489,14 +545,21 @@ func (decoder *TransactionDecoder) VerifyBTCRawTransaction(wrapper openwallet.Wa
 	return nil
 }
 
-//GetRawTransactionFeeRate 获取交易单的费率
+//GetRawTransactionFeeRate 获取交易单的费率，返回默认优先级的费率，单位附带所有优先级的费率
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	rate, err := decoder.wm.EstimateFeeRate()
+	tiers, err := decoder.wm.EstimateFeeRates()
 	if err != nil {
 		return "", "", err
 	}
 
-	return rate.StringFixed(decoder.wm.Decimal()), "K", nil
+	priority := decoder.wm.feeTier(decoder.wm.Config.FeePriority).Priority
+	for _, tier := range tiers {
+		if tier.Priority == priority {
+			feeRate = tier.FeeRate
+		}
+	}
+
+	return feeRate, feeRateUnit(tiers), nil
 }
 
 ////////////////////////// omnicore implement //////////////////////////
@@ -504,6 +567,9 @@ func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, u
 //CreateOmniRawTransaction 创建Omni交易单
 func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
+	//同一账户串行构建
+	defer lockAccountBuild(rawTx.Account.AccountID)()
+
 	var (
 		//vins      = make([]omniTransaction.Vin, 0)
 		//vouts     = make([]omniTransaction.Vout, 0)
@@ -535,14 +601,16 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 		return fmt.Errorf("%s is not support omnicore transfer", decoder.wm.Symbol())
 	}
 
-	if len(rawTx.Coin.Contract.Address) == 0 {
-		return fmt.Errorf("contract address is empty")
+	//按资产信息检查合约定义
+	property, err := decoder.wm.ValidateOmniContract(&rawTx.Coin.Contract)
+	if err != nil {
+		return err
 	}
 
 	//Omni代币编号
-	propertyID := common.NewString(rawTx.Coin.Contract.Address).UInt64()
+	propertyID := property.PropertyID
 	tokenCoin := rawTx.Coin.Contract.Token
-	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
+	tokenDecimals := property.Decimals()
 	//转账最低成本
 	transferCost, _ := decimal.NewFromString(decoder.wm.Config.OmniTransferCost)
 
@@ -559,23 +627,35 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 		return errors.New("Receiver addresses is empty!")
 	}
 
-	//omni限制只能发送目标一个
-	if len(rawTx.To) > 1 {
-		return fmt.Errorf("ommni transfer not support multiple receiver address")
+	//Send All发送地址的全部资产，只能有一个接收地址
+	sendAll := rawTx.GetExtParam().Get("omniSendAll").Bool()
+	if sendAll && len(rawTx.To) > 1 {
+		return fmt.Errorf("omni send all not support multiple receiver address")
 	}
 
-	//选择一个输出地址
-	for to, amount := range rawTx.To {
-		toAddress = to
-		toAmount, _ = decimal.NewFromString(amount)
+	//多个接收地址使用Send To Many
+	if len(rawTx.To) > maxOmniSendToManyReceivers {
+		return fmt.Errorf("omni transfer receiver address is over: %d", maxOmniSendToManyReceivers)
+	}
 
-		//计算账户的实际转账amount
-		//addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", to)
-		//if findErr != nil || len(addresses) == 0 {
-		//	accountTotalSent = accountTotalSent.Add(toAmount)
-		//}
+	receivers := sortedOmniReceivers(rawTx.To)
+	toAddress = strings.Join(receivers, ", ")
+
+	//合计发送数量
+	for _, to := range receivers {
+		amount, _ := decimal.NewFromString(rawTx.To[to])
+
+		//不可分割资产的数量不能有小数
+		if err = property.ValidateAmount(amount); err != nil {
+			return err
+		}
+
+		toAmount = toAmount.Add(amount)
 	}
 
+	//每个接收地址需要一个最低转账成本的输出
+	receiverCost := transferCost.Mul(decimal.New(int64(len(receivers)), 0))
+
 	/*
 
 		1. 遍历所有地址，获取token余额。
@@ -623,6 +703,7 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 			if tokenErr != nil {
 				return tokenErr
 			}
+			unspents = decoder.filterUnconfirmedUTXO(unspents, 0)
 
 			//取最大余额
 			//if tokenBalance.GreaterThanOrEqual(toAmount) {
@@ -683,22 +764,19 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 		if err != nil {
 			return err
 		}
+		missTokenUnspents = decoder.filterUnconfirmedUTXO(missTokenUnspents, 0)
 
 		availableUTXO = append(availableUTXO, missTokenUnspents...)
 	}
 
 	//获取手续费率
-	if len(rawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	feesRate, err = decoder.wm.ResolveFeeRate(rawTx.FeeRate, rawTx.GetExtParam().Get("feePriority").String())
+	if err != nil {
+		return err
 	}
 
 	decoder.wm.Log.Info("Calculating wallet unspent record to build transaction...")
-	computeTotalSend := transferCost
+	computeTotalSend := receiverCost
 	//循环的计算余额是否足够支付发送数额+手续费
 	for {
 
@@ -722,19 +800,19 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 			return openwallet.Errorf(openwallet.ErrInsufficientFees, "The [%s] available utxo balance: %s is not enough! ", decoder.wm.Symbol(), balance.StringFixed(decoder.wm.Decimal()))
 		}
 
-		//计算手续费，输出地址有2个，一个是发送，一个是找零，一个是op_reture
-		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(3), feesRate)
+		//计算手续费，输出地址有接收地址，一个是找零，一个是op_reture
+		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(len(receivers)+2), feesRate)
 		if err != nil {
 			return err
 		}
 
 		//如果要手续费有发送支付，得计算加入手续费后，计算余额是否足够
 		//总共要发送的
-		computeTotalSend = transferCost.Add(fees)
+		computeTotalSend = receiverCost.Add(fees)
 		if computeTotalSend.GreaterThan(balance) {
 			continue
 		}
-		computeTotalSend = transferCost
+		computeTotalSend = receiverCost
 
 		actualFees = fees
 
@@ -748,10 +826,21 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 		return errors.New(errStr)
 	}
 
-	//取账户最后一个地址
-	changeAddress := usedUTXO[0].Address
+	//根据账户的策略选择找零地址
+	change, err := decoder.selectChangeAddress(wrapper, rawTx.Account, usedUTXO)
+	if err != nil {
+		return err
+	}
+	changeAddress := change.Address
 
 	changeAmount := balance.Sub(computeTotalSend).Sub(actualFees)
+	changeAmount, actualFees = decoder.foldDustChange(changeAddress, changeAmount, actualFees)
+
+	//手续费安全上限，代币交易只检查绝对上限
+	if err = decoder.wm.CheckFees(actualFees, decimal.Zero); err != nil {
+		return err
+	}
+
 	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
 	rawTx.Fees = actualFees.StringFixed(decoder.wm.Decimal())
 
@@ -766,23 +855,34 @@ func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.W
 	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
 	decoder.wm.Log.Std.Notice("-----------------------------------------------")
 
-	outputAddrs = appendOutput(outputAddrs, toAddress, computeTotalSend)
+	for _, to := range receivers {
+		outputAddrs = appendOutput(outputAddrs, to, transferCost)
+	}
 	//outputAddrs[toAddress] = computeTotalSend.StringFixed(decoder.wm.Decimal())
 
 	//changeAmount := balance.Sub(totalSend).Sub(actualFees)
 	if changeAmount.GreaterThan(decimal.Zero) {
 		outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+		rawTx.Change = change
 		//outputAddrs[changeAddress] = changeAmount.StringFixed(decoder.wm.Decimal())
 	}
 
-	omniOutputAddrs[toAddress] = toAmount.StringFixed(tokenDecimals)
+	for _, to := range receivers {
+		amount, _ := decimal.NewFromString(rawTx.To[to])
+		omniOutputAddrs[to] = amount.StringFixed(tokenDecimals)
+	}
+
+	//Send All发送地址的全部余额
+	if sendAll {
+		omniOutputAddrs[receivers[0]] = useTokenBalance.StringFixed(tokenDecimals)
+	}
 
 	err = decoder.createOmniRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs, omniOutputAddrs)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return decoder.commitChangeAddress(wrapper, rawTx)
 }
 
 //SignOmniRawTransaction 签名交易单
@@ -984,6 +1084,9 @@ func (decoder *TransactionDecoder) VerifyOmniRawTransaction(wrapper openwallet.W
 //CreateBTCSummaryRawTransaction 创建BTC汇总交易
 func (decoder *TransactionDecoder) CreateBTCSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 
+	//同一账户串行构建
+	defer lockAccountBuild(sumRawTx.Account.AccountID)()
+
 	var (
 		feesRate       = decimal.New(0, 0)
 		accountID      = sumRawTx.Account.AccountID
@@ -1034,13 +1137,9 @@ func (decoder *TransactionDecoder) CreateBTCSummaryRawTransaction(wrapper openwa
 	}
 
 	//取得费率
-	if len(sumRawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(sumRawTx.FeeRate)
+	feesRate, err = decoder.wm.ResolveFeeRate(sumRawTx.FeeRate, "")
+	if err != nil {
+		return nil, err
 	}
 
 	sumUnspents = make([]*Unspent, 0)
@@ -1053,10 +1152,14 @@ func (decoder *TransactionDecoder) CreateBTCSummaryRawTransaction(wrapper openwa
 		if err != nil {
 			return nil, err
 		}
+		unspents = decoder.filterUnconfirmedUTXO(unspents, sumRawTx.Confirms)
 
 		//保留1个omni的最低转账成本的utxo 用于汇总omni
 		unspents = decoder.keepOmniCostUTXONotToUse(unspents)
 
+		//花费手续费高于金额的utxo不汇总
+		unspents = decoder.filterEconomicalUTXO(unspents, feesRate)
+
 		//尽可能筹够最大input数
 		unspentLimit := decoder.wm.Config.MaxTxInputs - len(sumUnspents)
 		if unspentLimit > 0 {
@@ -1102,7 +1205,7 @@ func (decoder *TransactionDecoder) CreateBTCSummaryRawTransaction(wrapper openwa
 			decoder.wm.Log.Debugf("fees: %v", fees)
 			decoder.wm.Log.Debugf("sumAmount: %v", sumAmount)
 
-			if sumAmount.GreaterThan(decimal.Zero) {
+			if sumAmount.GreaterThan(decimal.Zero) && !decoder.wm.IsDust(sumRawTx.SummaryAddress, sumAmount) {
 
 				//最后填充汇总地址及汇总数量
 				outputAddrs = appendOutput(outputAddrs, sumRawTx.SummaryAddress, sumAmount)
@@ -1123,7 +1226,11 @@ func (decoder *TransactionDecoder) CreateBTCSummaryRawTransaction(wrapper openwa
 					Required: 1,
 				}
 
-				createErr := decoder.createBTCRawTransaction(wrapper, rawTx, sumUnspents, outputAddrs)
+				//手续费安全上限
+				createErr := decoder.wm.CheckFees(fees, sumAmount)
+				if createErr == nil {
+					createErr = decoder.createBTCRawTransaction(wrapper, rawTx, sumUnspents, newTxOutputs(outputAddrs))
+				}
 				rawTxWithErr := &openwallet.RawTransactionWithError{
 					RawTx: rawTx,
 					Error: openwallet.ConvertError(createErr),
@@ -1150,7 +1257,7 @@ func (decoder *TransactionDecoder) createBTCRawTransaction(
 	wrapper openwallet.WalletDAI,
 	rawTx *openwallet.RawTransaction,
 	usedUTXO []*Unspent,
-	to map[string]decimal.Decimal,
+	outputs []*TxOutput,
 ) error {
 
 	var (
@@ -1171,20 +1278,31 @@ func (decoder *TransactionDecoder) createBTCRawTransaction(
 		return fmt.Errorf("utxo is empty")
 	}
 
-	if len(to) == 0 {
+	if len(outputs) == 0 {
 		return fmt.Errorf("Receiver addresses is empty! ")
 	}
 
 	//计算总发送金额
-	for addr, amount := range to {
-		//deamount, _ := decimal.NewFromString(amount)
-		totalSend = totalSend.Add(amount)
-		destinations = append(destinations, addr)
+	for _, out := range outputs {
+		totalSend = totalSend.Add(out.Amount)
+		destinations = append(destinations, out.Address)
 		//计算账户的实际转账amount
-		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", addr)
+		//派生的找零地址还未登记到账户
+		if out.IsChange {
+			continue
+		}
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", out.Address)
 		if findErr != nil || len(addresses) == 0 {
-			//amountDec, _ := decimal.NewFromString(amount)
-			accountTotalSent = accountTotalSent.Add(amount)
+			accountTotalSent = accountTotalSent.Add(out.Amount)
+		}
+	}
+
+	//BIP-69排序，交易单的txid不随构建的次数变化
+	if decoder.outputOrder(rawTx) == OutputOrderBIP69 {
+		usedUTXO = sortBIP69Inputs(usedUTXO)
+		outputs, err = decoder.sortBIP69Outputs(outputs)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -1205,12 +1323,16 @@ func (decoder *TransactionDecoder) createBTCRawTransaction(
 		txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
 	}
 
-	//装配输入
-	for to, amount := range to {
-		txTo = append(txTo, fmt.Sprintf("%s:%s", to, amount.String()))
-		amount = amount.Shift(decoder.wm.Decimal())
-		out := btcTransaction.Vout{to, uint64(amount.IntPart())}
+	//装配输出
+	changeIndex := -1
+	for i, o := range outputs {
+		txTo = append(txTo, fmt.Sprintf("%s:%s", o.Address, o.Amount.String()))
+		amount := o.Amount.Shift(decoder.wm.Decimal())
+		out := btcTransaction.Vout{o.Address, uint64(amount.IntPart())}
 		vouts = append(vouts, out)
+		if o.IsChange {
+			changeIndex = i
+		}
 	}
 
 	//锁定时间
@@ -1291,11 +1413,18 @@ func (decoder *TransactionDecoder) createBTCRawTransaction(
 
 	//TODO:多重签名要使用owner的公钥填充
 
+	//预留使用的utxo，避免并发构建的交易单重复使用
+	err = decoder.leaseRawTxUTXO(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
 	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
 	rawTx.TxFrom = txFrom
 	rawTx.TxTo = txTo
+	rawTx.SetExtParam("changeIndex", changeIndex)
 
 	return nil
 }
@@ -1320,7 +1449,6 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 		txTo             = make([]string, 0)
 		accountID        = rawTx.Account.AccountID
 		addressPrefix    omniTransaction.AddressPrefix
-		omniReceiver     string
 	)
 
 	if len(usedUTXO) == 0 {
@@ -1337,25 +1465,37 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 
 	//Omni代币编号
 	propertyID := common.NewString(rawTx.Coin.Contract.Address).UInt64()
-	tokenDecimals := int32(rawTx.Coin.Contract.Decimals)
+	tokenDecimals := decoder.wm.omniPropertyDecimals(&rawTx.Coin.Contract)
+
+	sendAll := rawTx.GetExtParam().Get("omniSendAll").Bool()
+	if sendAll && len(omniTo) > 1 {
+		return fmt.Errorf("omni send all not support multiple receiver address")
+	}
+
+	//接收地址排序，多个接收地址使用Send To Many
+	omniReceivers := sortedOmniReceivers(omniTo)
+	isOmniReceiver := make(map[string]bool)
 
 	//记录输入输出明细
-	for addr, amount := range omniTo {
-		//选择utxo的第一个地址作为发送放
-		txFrom = []string{fmt.Sprintf("%s:%s", usedUTXO[0].Address, amount)}
+	for _, addr := range omniReceivers {
+		amount := omniTo[addr]
 		//接收方的地址和数量
-		txTo = []string{fmt.Sprintf("%s:%s", addr, amount)}
+		txTo = append(txTo, fmt.Sprintf("%s:%s", addr, amount))
 
-		toAmount, _ = decimal.NewFromString(amount)
+		receiveAmount, _ := decimal.NewFromString(amount)
+		toAmount = toAmount.Add(receiveAmount)
 		//计算账户的实际转账amount
 		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", addr)
 		if findErr != nil || len(addresses) == 0 {
-			accountTotalSent = accountTotalSent.Add(toAmount)
+			accountTotalSent = accountTotalSent.Add(receiveAmount)
 		}
 
-		omniReceiver = addr
+		isOmniReceiver[addr] = true
 	}
 
+	//选择utxo的第一个地址作为发送放
+	txFrom = []string{fmt.Sprintf("%s:%s", usedUTXO[0].Address, toAmount.StringFixed(tokenDecimals))}
+
 	//UTXO如果大于设定限制，则分拆成多笔交易单发送
 	if len(usedUTXO) > decoder.wm.Config.MaxTxInputs {
 		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.MaxTxInputs)
@@ -1373,24 +1513,24 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 		//txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
 	}
 
-	//装配输入
-	vouts = make([]omniTransaction.Vout, len(coinTo))
-	voutIndex := 0
-	for to, amount := range coinTo {
-
-		amount = amount.Shift(decoder.wm.Decimal())
-		out := omniTransaction.Vout{to, uint64(amount.IntPart())}
-
-		if to == omniReceiver {
-			//最后一个输出为目标地址
-			vouts[len(coinTo)-1] = out
-		} else {
-			vouts[voutIndex] = out
-			voutIndex++
+	//装配输出，其它输出在前，接收地址按排序放在最后
+	vouts = make([]omniTransaction.Vout, 0, len(coinTo))
+	for _, out := range newTxOutputs(coinTo) {
+		if isOmniReceiver[out.Address] {
+			continue
 		}
+		amount := out.Amount.Shift(decoder.wm.Decimal())
+		vouts = append(vouts, omniTransaction.Vout{Address: out.Address, Amount: uint64(amount.IntPart())})
+	}
 
-		//vouts = append(vouts, out)
-		//txTo = append(txTo, fmt.Sprintf("%s:%s", to, amount))
+	firstReceiverOutput := len(vouts)
+	for _, to := range omniReceivers {
+		amount, ok := coinTo[to]
+		if !ok {
+			return fmt.Errorf("omni receiver: %s has no reference output", to)
+		}
+		amount = amount.Shift(decoder.wm.Decimal())
+		vouts = append(vouts, omniTransaction.Vout{Address: to, Amount: uint64(amount.IntPart())})
 	}
 
 	if decoder.wm.Config.IsTestNet {
@@ -1418,6 +1558,21 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 		Memo:       "",
 	}
 
+	//Send All发送地址在资产所属生态的全部资产，全部会发送的资产记录到扩展参数
+	if sendAll {
+		sendAllProperties, sendAllErr := decoder.wm.getOmniSendAllProperties(usedUTXO[0].Address, propertyID)
+		if sendAllErr != nil {
+			return sendAllErr
+		}
+		if len(sendAllProperties) > 1 {
+			decoder.wm.Log.Warningf("omni send all of address: %s will send %d properties", usedUTXO[0].Address, len(sendAllProperties))
+		}
+		rawTx.SetExtParam("omniSendAllProperties", sendAllProperties)
+
+		omniDetail.TxType = omniTransaction.SendAll
+		omniDetail.Ecosystem = omniEcosystem(propertyID)
+	}
+
 	//锁定时间
 	lockTime := uint32(0)
 
@@ -1432,6 +1587,22 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 		//decoder.wm.Log.Error("构建空交易单失败")
 	}
 
+	//Send To Many的载荷由依赖库之外创建，替换Simple Send的载荷
+	if len(omniReceivers) > 1 {
+		outputs, outputErr := omniSendToManyOutputs(omniReceivers, firstReceiverOutput, omniTo, tokenDecimals)
+		if outputErr != nil {
+			return outputErr
+		}
+		payload, payloadErr := createPayloadSendToMany(uint32(propertyID), outputs)
+		if payloadErr != nil {
+			return payloadErr
+		}
+		emptyTrans, err = replaceOmniPayload(emptyTrans, payload)
+		if err != nil {
+			return fmt.Errorf("create send to many transaction failed, unexpected error: %v", err)
+		}
+	}
+
 	////////构建用于签名的交易单哈希
 	transHash, err := omniTransaction.CreateRawTransactionHashForSig(emptyTrans, txUnlocks, addressPrefix)
 	if err != nil {
@@ -1493,6 +1664,12 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 	//accountTotalSent = accountTotalSent.Add(feesDec)
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
+	//预留使用的utxo，避免并发构建的交易单重复使用
+	err = decoder.leaseRawTxUTXO(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
 	rawTx.Signatures = signatures
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(tokenDecimals)
@@ -1505,6 +1682,9 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 //CreateOmniSummaryRawTransaction 创建Omni汇总交易
 func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 
+	//同一账户串行构建
+	defer lockAccountBuild(sumRawTx.Account.AccountID)()
+
 	var (
 		feesRate            = decimal.New(0, 0)
 		accountID           = sumRawTx.Account.AccountID
@@ -1533,13 +1713,15 @@ func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openw
 		feesSupportUnspents, _ = decoder.getAssetsAccountUnspents(wrapper, feesSupportAccount)
 	}
 
-	if len(sumRawTx.Coin.Contract.Address) == 0 {
-		return nil, fmt.Errorf("contract address is empty")
+	//按资产信息检查合约定义
+	property, err := decoder.wm.ValidateOmniContract(&sumRawTx.Coin.Contract)
+	if err != nil {
+		return nil, err
 	}
 
 	//Omni代币编号
-	propertyID := common.NewString(sumRawTx.Coin.Contract.Address).UInt64()
-	tokenDecimals := int32(sumRawTx.Coin.Contract.Decimals)
+	propertyID := property.PropertyID
+	tokenDecimals := property.Decimals()
 	//转账最低成本
 	transferCost, _ := decimal.NewFromString(decoder.wm.Config.OmniTransferCost)
 	//coinDecimals := decoder.wm.Decimal()
@@ -1558,13 +1740,9 @@ func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openw
 	}
 
 	//取得费率
-	if len(sumRawTx.FeeRate) == 0 {
-		feesRate, err = decoder.wm.EstimateFeeRate()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		feesRate, _ = decimal.NewFromString(sumRawTx.FeeRate)
+	feesRate, err = decoder.wm.ResolveFeeRate(sumRawTx.FeeRate, "")
+	if err != nil {
+		return nil, err
 	}
 
 	/*
@@ -1596,6 +1774,7 @@ func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openw
 		if createErr != nil {
 			continue
 		}
+		unspents = decoder.filterUnconfirmedUTXO(unspents, sumRawTx.Confirms)
 		if tokenBalance.LessThan(minTransfer) || len(unspents) == 0 || tokenBalance.LessThanOrEqual(decimal.Zero) {
 			continue
 		}
@@ -1667,6 +1846,7 @@ func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openw
 
 			//手续费地址 计算找零 = 手续费支持数量 + 地址余额 - 手续费 - 最低成本
 			changeAmount = supportAmount.Add(addrBalance).Sub(totalCost)
+			changeAmount, fees = decoder.foldDustChange(supportUnspent.Address, changeAmount, fees)
 			if changeAmount.GreaterThan(decimal.Zero) {
 				//主币输出第二个地址为找零地址，找零主币
 				outputAddrs = appendOutput(outputAddrs, supportUnspent.Address, changeAmount)
@@ -1714,7 +1894,16 @@ func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openw
 			Required: 1,
 		}
 
-		createTxErr := decoder.createOmniRawTransaction(wrapper, rawTx, unspents, outputAddrs, ominOutputAddrs)
+		//不保留余额时可以使用Send All，一笔交易汇总地址在同一生态的全部资产
+		if sumRawTx.GetExtParam().Get("omniSendAll").Bool() && retainedBalance.IsZero() {
+			rawTx.SetExtParam("omniSendAll", true)
+		}
+
+		//手续费安全上限，代币交易只检查绝对上限
+		createTxErr := decoder.wm.CheckFees(fees, decimal.Zero)
+		if createTxErr == nil {
+			createTxErr = decoder.createOmniRawTransaction(wrapper, rawTx, unspents, outputAddrs, ominOutputAddrs)
+		}
 		rawTxWithErr := &openwallet.RawTransactionWithError{
 			RawTx: rawTx,
 			Error: openwallet.ConvertError(createTxErr),
@@ -1760,6 +1949,9 @@ func (decoder *TransactionDecoder) getAssetsAccountUnspents(wrapper openwallet.W
 		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, err.Error())
 	}
 
+	//未确认输入策略
+	unspents = decoder.filterUnconfirmedUTXO(unspents, 0)
+
 	return unspents, nil
 }
 
@@ -1772,6 +1964,7 @@ func (decoder *TransactionDecoder) keepOmniCostUTXONotToUse(unspents []*Unspent)
 
 	var (
 		keeped     = make(map[string]bool)
+		holders    = make(map[string]bool)
 		resultUTXO = make([]*Unspent, 0)
 	)
 
@@ -1779,7 +1972,12 @@ func (decoder *TransactionDecoder) keepOmniCostUTXONotToUse(unspents []*Unspent)
 	transferCost, _ := decimal.NewFromString(decoder.wm.Config.OmniTransferCost)
 	for _, utxo := range unspents {
 
-		isHaveOmni := decoder.wm.IsHaveOmniAssets(utxo.Address)
+		//持币地址使用扫块缓存，同一地址只检查一次
+		isHaveOmni, checked := holders[utxo.Address]
+		if !checked {
+			isHaveOmni = decoder.wm.IsOmniHolder(utxo.Address)
+			holders[utxo.Address] = isHaveOmni
+		}
 		if isHaveOmni || utxo.Confirmations == 0 {
 			//有omni币或utxo确认数为0，需要检查utxo的数量是否等于或少于omni的转账成本，保留1个可用的omni成本
 			amount, _ := decimal.NewFromString(utxo.Amount)
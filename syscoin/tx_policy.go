@@ -0,0 +1,254 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+)
+
+//广播前策略检查的错误编号，与openwallet的交易类错误编号一致
+const (
+	ErrTxPolicyOversize        uint64 = 2101 //交易大小超过标准限制
+	ErrTxPolicyFeeTooHigh      uint64 = 2102 //手续费超过上限
+	ErrTxPolicyNonFinal        uint64 = 2103 //锁定时间未到
+	ErrTxPolicySequence        uint64 = 2104 //输入的序列号不符合标准
+	ErrTxPolicyNullData        uint64 = 2105 //OP_RETURN输出不符合标准
+	ErrTxPolicyNonStandard     uint64 = 2106 //非标准的交易或输出脚本
+	ErrTxPolicyInputsSpent     uint64 = 2107 //输入已被花费或不存在
+	ErrTxPolicyMempoolRejected uint64 = 2108 //节点内存池拒绝
+)
+
+const (
+	maxOpReturnRelay     = 83         //节点中继的OP_RETURN脚本最大字节数
+	lockTimeThreshold    = 500000000  //锁定时间小于该值为区块高度，否则为时间戳
+	sequenceLockDisabled = 1 << 31    //序列号禁用相对锁定时间的标志
+	maxTxInSequenceNum   = 0xffffffff //最终的序列号
+)
+
+var (
+	defaultMinRelayFeeRate = decimal.New(1000, -8) //每KB 1000聪
+	defaultMaxFeeRate      = decimal.New(1, -2)    //每KB 0.01
+	defaultMaxFee          = decimal.New(1, 0)
+)
+
+//txVirtualSize 交易的虚拟大小
+func txVirtualSize(msgTx *wire.MsgTx) int64 {
+	weight := msgTx.SerializeSizeStripped()*3 + msgTx.SerializeSize()
+	return int64((weight + 3) / 4)
+}
+
+//validateTxPolicy 广播前检查已签名交易是否符合节点的中继策略，totalInput为输入总额，sendAmount为发送总额，用于检查手续费比例
+func (decoder *TransactionDecoder) validateTxPolicy(signedHex string, totalInput, sendAmount decimal.Decimal) *openwallet.Error {
+
+	var (
+		config      = decoder.wm.Config
+		msgTx       wire.MsgTx
+		totalOutput = decimal.Zero
+		nullData    = 0
+	)
+
+	raw, err := hex.DecodeString(signedHex)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "invalid transaction hex: %v", err)
+	}
+
+	if err = msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "invalid transaction data: %v", err)
+	}
+
+	if msgTx.Version < 1 || msgTx.Version > 2 {
+		return openwallet.Errorf(ErrTxPolicyNonStandard, "transaction version: %d is not standard", msgTx.Version)
+	}
+
+	vsize := txVirtualSize(&msgTx)
+	if vsize > maxStandardTxSize {
+		return openwallet.Errorf(ErrTxPolicyOversize, "transaction size: %d is over standard limit: %d", vsize, maxStandardTxSize)
+	}
+
+	//序列号：不使用相对锁定时间
+	final := true
+	for i, in := range msgTx.TxIn {
+		if in.Sequence != maxTxInSequenceNum {
+			final = false
+		}
+		if msgTx.Version >= 2 && in.Sequence&sequenceLockDisabled == 0 && in.Sequence < maxTxInSequenceNum-1 {
+			return openwallet.Errorf(ErrTxPolicySequence, "input: %d sequence: %d enables relative lock time", i, in.Sequence)
+		}
+	}
+
+	//锁定时间：下一个区块可以打包
+	if msgTx.LockTime != 0 && !final {
+		if msgTx.LockTime < lockTimeThreshold {
+			height, heightErr := decoder.wm.GetBlockHeight()
+			if heightErr != nil {
+				return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, heightErr.Error())
+			}
+			if uint64(msgTx.LockTime) > height+1 {
+				return openwallet.Errorf(ErrTxPolicyNonFinal, "transaction lock height: %d is over next block: %d", msgTx.LockTime, height+1)
+			}
+		} else if int64(msgTx.LockTime) > time.Now().Unix() {
+			return openwallet.Errorf(ErrTxPolicyNonFinal, "transaction lock time: %d is not reached", msgTx.LockTime)
+		}
+	}
+
+	//输出：标准脚本，非粉尘，OP_RETURN最多一个
+	for i, out := range msgTx.TxOut {
+		amount := decimal.New(out.Value, -decoder.wm.Decimal())
+		totalOutput = totalOutput.Add(amount)
+
+		if len(out.PkScript) > 0 && out.PkScript[0] == 0x6a {
+			nullData++
+			if nullData > 1 {
+				return openwallet.Errorf(ErrTxPolicyNullData, "transaction has more than one OP_RETURN output")
+			}
+			if len(out.PkScript) > maxOpReturnRelay {
+				return openwallet.Errorf(ErrTxPolicyNullData, "OP_RETURN output size: %d is over: %d", len(out.PkScript), maxOpReturnRelay)
+			}
+			if out.Value != 0 {
+				return openwallet.Errorf(ErrTxPolicyNullData, "OP_RETURN output: %d burns amount: %s", i, amount.String())
+			}
+			continue
+		}
+
+		if scriptType(out.PkScript) == scriptTypeUnknown {
+			return openwallet.Errorf(ErrTxPolicyNonStandard, "output: %d script: %x is not standard", i, out.PkScript)
+		}
+
+		if amount.LessThan(decoder.wm.dustThresholdOfScript(out.PkScript)) {
+			return openwallet.Errorf(openwallet.ErrDustLimit, "output: %d amount: %s is dust", i, amount.String())
+		}
+	}
+
+	//手续费和费率的范围
+	fees := totalInput.Sub(totalOutput)
+	if fees.LessThan(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "outputs: %s is over inputs: %s", totalOutput.String(), totalInput.String())
+	}
+
+	feeRate := fees.Mul(decimal.New(1000, 0)).Div(decimal.New(vsize, 0))
+
+	minRelayFeeRate := decoder.wm.MinFeeRate()
+	if feeRate.LessThan(minRelayFeeRate) {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "fee rate: %s is lower than min relay fee rate: %s", feeRate.StringFixed(decoder.wm.Decimal()), minRelayFeeRate.String())
+	}
+
+	if config.MaxFeeRate.GreaterThan(decimal.Zero) && feeRate.GreaterThan(config.MaxFeeRate) {
+		return openwallet.Errorf(ErrTxPolicyFeeTooHigh, "fee rate: %s is higher than max fee rate: %s", feeRate.StringFixed(decoder.wm.Decimal()), config.MaxFeeRate.String())
+	}
+
+	//手续费绝对上限和相对发送总额的比例上限
+	if err = decoder.wm.CheckFees(fees, sendAmount); err != nil {
+		if owErr, ok := err.(*openwallet.Error); ok {
+			return owErr
+		}
+		return openwallet.Errorf(ErrTxPolicyFeeTooHigh, err.Error())
+	}
+
+	return decoder.testMempoolAccept(signedHex)
+}
+
+//testMempoolAccept 使用核心钱包时，通过testmempoolaccept检查节点是否接受交易
+func (decoder *TransactionDecoder) testMempoolAccept(signedHex string) *openwallet.Error {
+
+	if decoder.wm.Config.RPCServerType == RPCServerExplorer || decoder.wm.WalletClient == nil {
+		return nil
+	}
+
+	result, err := decoder.wm.WalletClient.Call("testmempoolaccept", []interface{}{[]string{signedHex}})
+	if err != nil {
+		//节点不支持时不影响广播
+		decoder.wm.Log.Warningf("testmempoolaccept failed, %v", err)
+		return nil
+	}
+
+	accept := result.Get("0")
+	if accept.Get("allowed").Bool() {
+		return nil
+	}
+
+	return txRejectError(accept.Get("reject-reason").String())
+}
+
+//txRejectError 节点拒绝交易的原因转换为错误编号
+func txRejectError(reason string) *openwallet.Error {
+
+	msg := strings.ToLower(reason)
+
+	var code uint64
+	switch {
+	case strings.Contains(msg, "dust"):
+		code = openwallet.ErrDustLimit
+	case strings.Contains(msg, "absurdly-high-fee") || strings.Contains(msg, "max-fee-exceeded") || strings.Contains(msg, "fee exceeds maximum"):
+		code = ErrTxPolicyFeeTooHigh
+	case strings.Contains(msg, "min relay fee not met") || strings.Contains(msg, "mempool min fee not met") ||
+		strings.Contains(msg, "insufficient fee") || strings.Contains(msg, "min-fee-not-met"):
+		code = openwallet.ErrInsufficientFees
+	case strings.Contains(msg, "missingorspent") || strings.Contains(msg, "missing-inputs") ||
+		strings.Contains(msg, "missing inputs") || strings.Contains(msg, "txn-mempool-conflict") ||
+		strings.Contains(msg, "already in block chain"):
+		code = ErrTxPolicyInputsSpent
+	case strings.Contains(msg, "non-final") || strings.Contains(msg, "non-bip68-final"):
+		code = ErrTxPolicyNonFinal
+	case strings.Contains(msg, "tx-size") || strings.Contains(msg, "oversize"):
+		code = ErrTxPolicyOversize
+	case strings.Contains(msg, "multi-op-return") || strings.Contains(msg, "datacarrier"):
+		code = ErrTxPolicyNullData
+	case strings.Contains(msg, "scriptpubkey") || strings.Contains(msg, "scriptsig") || rejectReasonToken(msg) == "version":
+		code = ErrTxPolicyNonStandard
+	default:
+		code = ErrTxPolicyMempoolRejected
+	}
+
+	return openwallet.Errorf(code, "transaction rejected: %s", reason)
+}
+
+//rejectReasonToken 去掉拒绝原因的错误码前缀和说明，例如"[-26]64: version (code 64)"返回"version"
+func rejectReasonToken(msg string) string {
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "]"); i >= 0 {
+			msg = msg[i+1:]
+		}
+	}
+	if i := strings.Index(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	if i := strings.IndexAny(msg, " ,("); i >= 0 {
+		msg = msg[:i]
+	}
+	return strings.TrimSpace(msg)
+}
+
+//broadcastError 广播失败的错误转换为错误编号，格式：[code]message
+func broadcastError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*openwallet.Error); ok {
+		return err
+	}
+	owErr := txRejectError(err.Error())
+	if owErr.Code() == ErrTxPolicyMempoolRejected {
+		return openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, err.Error())
+	}
+	return owErr
+}
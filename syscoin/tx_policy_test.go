@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+)
+
+func newPolicyTestTx(outputs ...*wire.TxOut) string {
+	msgTx := wire.NewMsgTx(2)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), make([]byte, 107), nil))
+	for _, out := range outputs {
+		msgTx.AddTxOut(out)
+	}
+	var buf bytes.Buffer
+	msgTx.Serialize(&buf)
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestTransactionDecoder_ValidateTxPolicy(t *testing.T) {
+	wm := &WalletManager{Config: &WalletConfig{
+		Decimals:        Decimals,
+		RPCServerType:   RPCServerExplorer,
+		MinRelayFeeRate: defaultMinRelayFeeRate,
+		MaxFeeRate:      defaultMaxFeeRate,
+		MaxFee:          defaultMaxFee,
+		MaxFeeRatio:     decimal.RequireFromString("0.1"),
+	}}
+	wm.Log = log.NewOWLogger(Symbol)
+	decoder := NewTransactionDecoder(wm)
+
+	p2pkh, _ := hex.DecodeString("76a914c9b1ab8d9fe3d2ee4e0e8b6c6f2e0b0b5c2a1d3e88ac")
+	opReturn := append([]byte{0x6a, 0x4c, 80}, make([]byte, 80)...)
+
+	tests := []struct {
+		name  string
+		tx    string
+		input string
+		code  uint64
+	}{
+		{"standard", newPolicyTestTx(wire.NewTxOut(100000, p2pkh)), "0.00101", 0},
+		{"dust", newPolicyTestTx(wire.NewTxOut(500, p2pkh)), "0.00011", openwallet.ErrDustLimit},
+		{"low fee", newPolicyTestTx(wire.NewTxOut(100000, p2pkh)), "0.001001", openwallet.ErrInsufficientFees},
+		{"high fee", newPolicyTestTx(wire.NewTxOut(100000, p2pkh)), "0.1", ErrTxPolicyFeeTooHigh},
+		{"high fee ratio", newPolicyTestTx(wire.NewTxOut(100000, p2pkh)), "0.0015", ErrTxPolicyFeeTooHigh},
+		{"over spend", newPolicyTestTx(wire.NewTxOut(100000, p2pkh)), "0.0009", openwallet.ErrInsufficientBalanceOfAccount},
+		{"non standard", newPolicyTestTx(wire.NewTxOut(100000, []byte{0x51})), "0.00101", ErrTxPolicyNonStandard},
+		{"op_return", newPolicyTestTx(wire.NewTxOut(100000, p2pkh), wire.NewTxOut(0, opReturn)), "0.00101", 0},
+		{"op_return amount", newPolicyTestTx(wire.NewTxOut(100000, p2pkh), wire.NewTxOut(1000, opReturn)), "0.00102", ErrTxPolicyNullData},
+		{"multi op_return", newPolicyTestTx(wire.NewTxOut(100000, p2pkh), wire.NewTxOut(0, opReturn), wire.NewTxOut(0, opReturn)), "0.00102", ErrTxPolicyNullData},
+		{"op_return oversize", newPolicyTestTx(wire.NewTxOut(100000, p2pkh), wire.NewTxOut(0, append(opReturn, 0))), "0.00102", ErrTxPolicyNullData},
+	}
+
+	for _, test := range tests {
+		err := decoder.validateTxPolicy(test.tx, decimal.RequireFromString(test.input), decimal.RequireFromString("0.001"))
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Code() != test.code {
+			t.Errorf("%s: error: %v, want code: %d", test.name, err, test.code)
+		}
+	}
+}
+
+func TestTxRejectError(t *testing.T) {
+	tests := map[string]uint64{
+		"dust":                           openwallet.ErrDustLimit,
+		"min relay fee not met, 0 < 226": openwallet.ErrInsufficientFees,
+		"bad-txns-inputs-missingorspent": ErrTxPolicyInputsSpent,
+		"txn-mempool-conflict":           ErrTxPolicyInputsSpent,
+		"absurdly-high-fee":              ErrTxPolicyFeeTooHigh,
+		"non-final":                      ErrTxPolicyNonFinal,
+		"multi-op-return":                ErrTxPolicyNullData,
+		"too-long-mempool-chain":         ErrTxPolicyMempoolRejected,
+		"version":                        ErrTxPolicyNonStandard,
+		"[-26]64: version (code 64)":     ErrTxPolicyNonStandard,
+		"bad-version-bits":               ErrTxPolicyMempoolRejected,
+		"unsupported protocol version":   ErrTxPolicyMempoolRejected,
+	}
+
+	for reason, code := range tests {
+		if err := txRejectError(reason); err.Code() != code {
+			t.Errorf("reason: %s code: %d, want: %d", reason, err.Code(), code)
+		}
+	}
+}
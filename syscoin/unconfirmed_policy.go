@@ -0,0 +1,137 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+const (
+	defaultMinForeignConfirms  = 1
+	defaultMaxUnconfirmedDepth = 24 //节点默认的未确认祖先交易上限为25，包括新交易
+)
+
+//unconfirmedDepth 交易在内存池中的未确认祖先深度，包括交易自身，已确认返回0，最多计算到limit
+func (wm *WalletManager) unconfirmedDepth(txid string, limit int) (int, error) {
+
+	if wm.Config.RPCServerType != RPCServerExplorer && wm.WalletClient != nil {
+		result, err := wm.WalletClient.Call("getmempoolentry", []interface{}{txid})
+		if err != nil {
+			//不在内存池，视为已确认，其它错误由调用方按超过上限处理
+			if isTxNotFoundError(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return int(result.Get("ancestorcount").Int()), nil
+	}
+
+	return wm.unconfirmedDepthByTx(txid, limit, make(map[string]int))
+}
+
+//unconfirmedDepthByTx 通过交易输入追溯未确认的祖先交易
+func (wm *WalletManager) unconfirmedDepthByTx(txid string, limit int, cache map[string]int) (int, error) {
+
+	if depth, ok := cache[txid]; ok {
+		return depth, nil
+	}
+
+	tx, err := wm.GetTransaction(txid)
+	if err != nil {
+		return 0, err
+	}
+
+	if tx.Confirmations > 0 || tx.BlockHeight > 0 {
+		cache[txid] = 0
+		return 0, nil
+	}
+
+	depth := 1
+	for _, vin := range tx.Vins {
+		if depth > limit {
+			break
+		}
+		if len(vin.Coinbase) > 0 || len(vin.TxID) == 0 {
+			continue
+		}
+		parent, err := wm.unconfirmedDepthByTx(vin.TxID, limit-1, cache)
+		if err != nil {
+			return 0, err
+		}
+		if parent+1 > depth {
+			depth = parent + 1
+		}
+	}
+
+	cache[txid] = depth
+	return depth, nil
+}
+
+//filterUnconfirmedUTXO 按未确认输入策略过滤utxo：
+//第三方的utxo需要达到最低确认数，自己广播交易的找零允许未确认，未确认祖先深度不能超过上限
+func (decoder *TransactionDecoder) filterUnconfirmedUTXO(unspents []*Unspent, minConfirms uint64) []*Unspent {
+
+	var (
+		config   = decoder.wm.Config
+		result   = make([]*Unspent, 0, len(unspents))
+		depths   = make(map[string]int)
+		skipped  = 0
+		maxDepth = config.MaxUnconfirmedDepth
+	)
+
+	if config.MinForeignConfirms > minConfirms {
+		minConfirms = config.MinForeignConfirms
+	}
+
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxUnconfirmedDepth
+	}
+
+	for _, u := range unspents {
+
+		if u.Confirmations >= minConfirms && u.Confirmations > 0 {
+			result = append(result, u)
+			continue
+		}
+
+		own := config.AllowUnconfirmedChange && decoder.wm.isOwnTransaction(u.TxID)
+		if !own && u.Confirmations < minConfirms {
+			skipped++
+			continue
+		}
+
+		if u.Confirmations == 0 {
+			depth, ok := depths[u.TxID]
+			if !ok {
+				var err error
+				depth, err = decoder.wm.unconfirmedDepth(u.TxID, maxDepth)
+				if err != nil {
+					decoder.wm.Log.Errorf("get unconfirmed depth of tx: %s failed unexpected error: %v", u.TxID, err)
+					depth = maxDepth + 1
+				}
+				depths[u.TxID] = depth
+			}
+			if depth > maxDepth {
+				skipped++
+				continue
+			}
+		}
+
+		result = append(result, u)
+	}
+
+	if skipped > 0 {
+		decoder.wm.Log.Infof("skip %d utxo by unconfirmed input policy", skipped)
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTransactionDecoder_FilterUnconfirmedUTXO(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	wm.Config.MinForeignConfirms = 3
+	wm.Config.AllowUnconfirmedChange = true
+	decoder := NewTransactionDecoder(wm)
+
+	if err := wm.saveOutboundTx(&OutboundTx{TxID: "own", AccountID: "account1"}); err != nil {
+		t.Fatalf("saveOutboundTx failed unexpected error: %v", err)
+	}
+
+	unspents := []*Unspent{
+		{TxID: "foreign", Vout: 0, Confirmations: 1},
+		{TxID: "foreign", Vout: 1, Confirmations: 3},
+		{TxID: "own", Vout: 0, Confirmations: 1},
+	}
+
+	result := decoder.filterUnconfirmedUTXO(unspents, 0)
+	if len(result) != 2 || result[0] != unspents[1] || result[1] != unspents[2] {
+		t.Errorf("unexpected utxo: %+v", result)
+	}
+
+	//汇总要求的确认数更高时使用汇总的确认数
+	result = decoder.filterUnconfirmedUTXO(unspents[:2], 5)
+	if len(result) != 0 {
+		t.Errorf("unexpected utxo: %+v", result)
+	}
+
+	//不允许使用未确认的找零
+	wm.Config.AllowUnconfirmedChange = false
+	result = decoder.filterUnconfirmedUTXO([]*Unspent{{TxID: "own", Vout: 1, Confirmations: 0}}, 0)
+	if len(result) != 0 {
+		t.Errorf("unconfirmed change should be skipped: %+v", result)
+	}
+}
+
+func TestWalletManager_UnconfirmedDepthByCore(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch gjson.GetBytes(body, "params.0").String() {
+		case "mempool":
+			fmt.Fprint(w, `{"result":{"ancestorcount":3},"error":null,"id":"1"}`)
+		case "confirmed":
+			fmt.Fprint(w, `{"result":null,"error":{"code":-5,"message":"Transaction not in mempool"},"id":"1"}`)
+		default:
+			fmt.Fprint(w, `{"result":null,"error":{"code":-28,"message":"Loading block index..."},"id":"1"}`)
+		}
+	}))
+	defer server.Close()
+
+	wm.Config.RPCServerType = RPCServerCore
+	wm.WalletClient = NewClient(server.URL, "", false)
+
+	if depth, err := wm.unconfirmedDepth("mempool", 24); err != nil || depth != 3 {
+		t.Errorf("unexpected depth: %d, err: %v", depth, err)
+	}
+	if depth, err := wm.unconfirmedDepth("confirmed", 24); err != nil || depth != 0 {
+		t.Errorf("tx not in mempool should be confirmed, depth: %d, err: %v", depth, err)
+	}
+	//节点错误不能视为已确认
+	if _, err := wm.unconfirmedDepth("unknown", 24); err == nil {
+		t.Errorf("rpc failure should return error")
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	defaultUnscanMaxAttempts     = 10
+	defaultUnscanRetryMinBackoff = 30 * time.Second
+	defaultUnscanRetryMaxBackoff = 6 * time.Hour
+)
+
+//UnscanRetry 未扫记录的重试状态，ID与UnscanRecord.ID一致
+type UnscanRetry struct {
+	ID        string `storm:"id"`
+	Attempts  int    //已重试次数
+	NextRetry int64  //下次重试的时间
+	LastError string //最后一次失败的原因
+}
+
+//ResolvedUnscanRecord BlockchainDAI不支持删除时，在本地记录已处理的未扫记录，获取未扫记录时过滤
+type ResolvedUnscanRecord struct {
+	ID           string `storm:"id"`
+	ResolvedTime int64
+}
+
+//DeadUnscanRecord 超过最大重试次数的未扫记录
+type DeadUnscanRecord struct {
+	ID          string `storm:"id"`
+	BlockHeight uint64 `json:"blockHeight"`
+	TxID        string `json:"txid"`
+	Reason      string `json:"reason"`
+	Attempts    int    `json:"attempts"`
+	LastError   string `json:"lastError"`
+	DeadTime    int64  `json:"deadTime"`
+}
+
+//unscanRetryBackoff 第N次失败后的等待时间
+func (bs *BTCBlockScanner) unscanRetryBackoff(attempts int) time.Duration {
+	backoff := bs.UnscanRetryMinBackoff
+	for i := 1; i < attempts; i++ {
+		backoff = backoff * 2
+		if backoff >= bs.UnscanRetryMaxBackoff {
+			return bs.UnscanRetryMaxBackoff
+		}
+	}
+	return backoff
+}
+
+//getUnscanRetry 获取未扫记录的重试状态，没有则创建
+func (bs *BTCBlockScanner) getUnscanRetry(id string) *UnscanRetry {
+	retry := &UnscanRetry{ID: id}
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		bs.wm.Log.Errorf("open local db failed unexpected error: %v", err)
+		return retry
+	}
+
+	db.One("ID", id, retry)
+	return retry
+}
+
+func (bs *BTCBlockScanner) saveUnscanRetry(retry *UnscanRetry) {
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		bs.wm.Log.Errorf("open local db failed unexpected error: %v", err)
+		return
+	}
+
+	err = db.Save(retry)
+	if err != nil {
+		bs.wm.Log.Errorf("save unscan retry: %s failed unexpected error: %v", retry.ID, err)
+	}
+}
+
+func (bs *BTCBlockScanner) deleteUnscanRetry(id string) {
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		bs.wm.Log.Errorf("open local db failed unexpected error: %v", err)
+		return
+	}
+
+	err = db.DeleteStruct(&UnscanRetry{ID: id})
+	if err != nil && err != storm.ErrNotFound {
+		bs.wm.Log.Errorf("delete unscan retry: %s failed unexpected error: %v", id, err)
+	}
+}
+
+//deleteUnscanRecordByID 删除指定的未扫记录，BlockchainDAI删除失败（例如未实现）时记录到本地已处理
+func (bs *BTCBlockScanner) deleteUnscanRecordByID(id string) error {
+	if bs.BlockchainDAI != nil {
+		err := bs.BlockchainDAI.DeleteUnscanRecordByID(id, bs.wm.Symbol())
+		if err == nil {
+			return nil
+		}
+		bs.wm.Log.Warningf("blockchain DAI delete unscan record: %s failed: %v, mark it resolved in local db", id, err)
+	}
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Save(&ResolvedUnscanRecord{ID: id, ResolvedTime: time.Now().Unix()})
+}
+
+//isResolvedUnscanRecord 未扫记录是否已在本地记录为已处理
+func (bs *BTCBlockScanner) isResolvedUnscanRecord(id string) bool {
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		return false
+	}
+
+	var resolved ResolvedUnscanRecord
+	return db.One("ID", id, &resolved) == nil
+}
+
+//unresolveUnscanRecord 同一记录再次失败时，删除本地的已处理记录
+func (bs *BTCBlockScanner) unresolveUnscanRecord(id string) {
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		bs.wm.Log.Errorf("open local db failed unexpected error: %v", err)
+		return
+	}
+
+	err = db.DeleteStruct(&ResolvedUnscanRecord{ID: id})
+	if err != nil && err != storm.ErrNotFound {
+		bs.wm.Log.Errorf("delete resolved unscan record: %s failed unexpected error: %v", id, err)
+	}
+}
+
+//moveToDeadUnscanRecord 未扫记录转入死信
+func (bs *BTCBlockScanner) moveToDeadUnscanRecord(r *openwallet.UnscanRecord, retry *UnscanRetry) {
+
+	bs.wm.Log.Errorf("unscan record: %s height: %d txid: %s exceeded max attempts: %d, move to dead letter",
+		r.ID, r.BlockHeight, r.TxID, retry.Attempts)
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		bs.wm.Log.Errorf("open local db failed unexpected error: %v", err)
+		return
+	}
+
+	dead := &DeadUnscanRecord{
+		ID:          r.ID,
+		BlockHeight: r.BlockHeight,
+		TxID:        r.TxID,
+		Reason:      r.Reason,
+		Attempts:    retry.Attempts,
+		LastError:   retry.LastError,
+		DeadTime:    time.Now().Unix(),
+	}
+
+	err = db.Save(dead)
+	if err != nil {
+		//死信保存失败，保留未扫记录，下次继续
+		bs.wm.Log.Errorf("save dead unscan record: %s failed unexpected error: %v", r.ID, err)
+		return
+	}
+
+	err = bs.deleteUnscanRecordByID(r.ID)
+	if err != nil {
+		bs.wm.Log.Errorf("delete unscan record: %s failed unexpected error: %v", r.ID, err)
+	}
+	bs.deleteUnscanRetry(r.ID)
+}
+
+//GetDeadUnscanRecords 获取超过最大重试次数的未扫记录
+func (bs *BTCBlockScanner) GetDeadUnscanRecords() ([]*DeadUnscanRecord, error) {
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*DeadUnscanRecord
+	err = db.All(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+//RetryDeadUnscanRecord 把死信重新加入未扫记录，重置重试次数
+func (bs *BTCBlockScanner) RetryDeadUnscanRecord(id string) error {
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	var dead DeadUnscanRecord
+	err = db.One("ID", id, &dead)
+	if err != nil {
+		return fmt.Errorf("dead unscan record: %s not found", id)
+	}
+
+	unscanRecord := openwallet.NewUnscanRecord(dead.BlockHeight, dead.TxID, dead.Reason, bs.wm.Symbol())
+	err = bs.SaveUnscanRecord(unscanRecord)
+	if err != nil {
+		return err
+	}
+
+	bs.deleteUnscanRetry(unscanRecord.ID)
+
+	return db.DeleteStruct(&dead)
+}
+
+//PurgeDeadUnscanRecords 删除死信，不传id则清空全部
+func (bs *BTCBlockScanner) PurgeDeadUnscanRecords(ids ...string) error {
+
+	db, err := bs.wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return db.Drop(&DeadUnscanRecord{})
+	}
+
+	for _, id := range ids {
+		err = db.DeleteStruct(&DeadUnscanRecord{ID: id})
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func newTestLocalDBWallet(t *testing.T) (*WalletManager, func()) {
+	dir, err := ioutil.TempDir("", "localdb")
+	if err != nil {
+		t.Fatalf("create temp dir failed unexpected error: %v", err)
+	}
+	wm := &WalletManager{}
+	wm.Config = &WalletConfig{Symbol: Symbol, DBPath: dir}
+	wm.Log = log.NewOWLogger(Symbol)
+	return wm, func() {
+		wm.CloseLocalDB()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUnscanRetryBackoff(t *testing.T) {
+	bs := &BTCBlockScanner{
+		UnscanRetryMinBackoff: time.Second,
+		UnscanRetryMaxBackoff: 5 * time.Second,
+	}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := bs.unscanRetryBackoff(i + 1); got != want {
+			t.Errorf("attempts: %d, backoff: %v, want: %v", i+1, got, want)
+		}
+	}
+}
+
+func TestDeadUnscanRecord(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	bs := &BTCBlockScanner{wm: wm, BlockScannerBase: openwallet.NewBlockScannerBase()}
+
+	r := openwallet.NewUnscanRecord(100, "txid", "[-5]not found", Symbol)
+	bs.moveToDeadUnscanRecord(r, &UnscanRetry{ID: r.ID, Attempts: 3, LastError: "timeout"})
+
+	list, err := bs.GetDeadUnscanRecords()
+	if err != nil {
+		t.Fatalf("GetDeadUnscanRecords failed unexpected error: %v", err)
+	}
+	if len(list) != 1 || list[0].TxID != "txid" || list[0].Attempts != 3 {
+		t.Fatalf("unexpected dead records: %+v", list)
+	}
+
+	err = bs.PurgeDeadUnscanRecords()
+	if err != nil {
+		t.Fatalf("PurgeDeadUnscanRecords failed unexpected error: %v", err)
+	}
+
+	list, _ = bs.GetDeadUnscanRecords()
+	if len(list) != 0 {
+		t.Errorf("unexpected dead records after purge: %d", len(list))
+	}
+}
+
+//testUnscanDAI 不支持按ID删除未扫记录的BlockchainDAI
+type testUnscanDAI struct {
+	openwallet.BlockchainDAIBase
+	records map[string]*openwallet.UnscanRecord
+}
+
+func (dai *testUnscanDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	dai.records[record.ID] = record
+	return nil
+}
+
+func (dai *testUnscanDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	list := make([]*openwallet.UnscanRecord, 0, len(dai.records))
+	for _, r := range dai.records {
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+func TestDeleteUnscanRecordFallback(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	bs := &BTCBlockScanner{wm: wm, BlockScannerBase: openwallet.NewBlockScannerBase()}
+	bs.BlockchainDAI = &testUnscanDAI{records: make(map[string]*openwallet.UnscanRecord)}
+
+	r := openwallet.NewUnscanRecord(100, "txid", "timeout", Symbol)
+	bs.SaveUnscanRecord(r)
+
+	err := bs.deleteUnscanRecordByID(r.ID)
+	if err != nil {
+		t.Fatalf("deleteUnscanRecordByID failed unexpected error: %v", err)
+	}
+
+	list, _ := bs.GetUnscanRecords()
+	if len(list) != 0 {
+		t.Fatalf("resolved unscan record should be filtered: %d", len(list))
+	}
+
+	//再次失败时重新处理
+	bs.SaveUnscanRecord(r)
+	list, _ = bs.GetUnscanRecords()
+	if len(list) != 1 {
+		t.Errorf("unscan record saved again should be retried: %d", len(list))
+	}
+}
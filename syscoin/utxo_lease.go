@@ -0,0 +1,276 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	defaultUTXOLeaseExpiry = 10 * time.Minute
+)
+
+var (
+	//utxoLeaseMu 保护租约的检查和写入
+	utxoLeaseMu sync.Mutex
+	//accountBuildMu 同一账户的交易单串行构建，避免选到相同的utxo
+	accountBuildMu  sync.Mutex
+	accountBuildMus = make(map[string]*sync.Mutex)
+)
+
+//UTXOLease 构建交易单时预留的utxo，租约期内ListUnspent不再返回
+type UTXOLease struct {
+	Key        string `storm:"id"` //txid:vout
+	LeaseID    string `storm:"index"`
+	AccountID  string `storm:"index"`
+	TxID       string //广播后的交易id
+	CreateTime int64
+	Expiry     int64
+}
+
+//utxoLeaseKey utxo的租约键
+func utxoLeaseKey(txid string, vout uint64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+//newLeaseID 生成租约id
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//lockAccountBuild 锁定账户的交易单构建，返回解锁函数
+func lockAccountBuild(accountID string) func() {
+	accountBuildMu.Lock()
+	mu, ok := accountBuildMus[accountID]
+	if !ok {
+		mu = &sync.Mutex{}
+		accountBuildMus[accountID] = mu
+	}
+	accountBuildMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+//utxoLeaseExpiry 租约有效期
+func (wm *WalletManager) utxoLeaseExpiry() time.Duration {
+	if wm.Config.UTXOLeaseExpiry > 0 {
+		return wm.Config.UTXOLeaseExpiry
+	}
+	return defaultUTXOLeaseExpiry
+}
+
+//LeaseUTXO 预留utxo，已被其它未过期租约预留的utxo返回错误
+func (wm *WalletManager) LeaseUTXO(leaseID, accountID string, utxos []*Unspent, expiry time.Duration) error {
+
+	utxoLeaseMu.Lock()
+	defer utxoLeaseMu.Unlock()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, u := range utxos {
+		var lease UTXOLease
+		key := utxoLeaseKey(u.TxID, u.Vout)
+		err = db.One("Key", key, &lease)
+		if err == nil && lease.LeaseID != leaseID && lease.Expiry > now.Unix() {
+			return fmt.Errorf("utxo: %s has been leased by: %s", key, lease.LeaseID)
+		}
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, u := range utxos {
+		lease := &UTXOLease{
+			Key:        utxoLeaseKey(u.TxID, u.Vout),
+			LeaseID:    leaseID,
+			AccountID:  accountID,
+			CreateTime: now.Unix(),
+			Expiry:     now.Add(expiry).Unix(),
+		}
+		err = tx.Save(lease)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ReleaseUTXOLease 释放租约预留的所有utxo
+func (wm *WalletManager) ReleaseUTXOLease(leaseID string) error {
+
+	utxoLeaseMu.Lock()
+	defer utxoLeaseMu.Unlock()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.Select(q.Eq("LeaseID", leaseID)).Delete(&UTXOLease{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//SetUTXOLeaseTxID 记录租约广播后的交易id，租约保留到过期，等待数据源更新utxo
+func (wm *WalletManager) SetUTXOLeaseTxID(leaseID, txid string) error {
+
+	utxoLeaseMu.Lock()
+	defer utxoLeaseMu.Unlock()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	var leases []*UTXOLease
+	err = db.Find("LeaseID", leaseID, &leases)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, lease := range leases {
+		lease.TxID = txid
+		if err = db.Save(lease); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//GetUTXOLeases 查询账户未过期的utxo租约，accountID为空则查询全部
+func (wm *WalletManager) GetUTXOLeases(accountID string) ([]*UTXOLease, error) {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	matchers := []q.Matcher{q.Gt("Expiry", time.Now().Unix())}
+	if len(accountID) > 0 {
+		matchers = append(matchers, q.Eq("AccountID", accountID))
+	}
+
+	var leases []*UTXOLease
+	err = db.Select(matchers...).Find(&leases)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return leases, nil
+}
+
+//CleanExpiredUTXOLeases 删除过期的租约
+func (wm *WalletManager) CleanExpiredUTXOLeases() error {
+
+	utxoLeaseMu.Lock()
+	defer utxoLeaseMu.Unlock()
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.Select(q.Lte("Expiry", time.Now().Unix())).Delete(&UTXOLease{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+//filterLeasedUTXO 过滤掉未过期租约预留的utxo
+func (wm *WalletManager) filterLeasedUTXO(utxos []*Unspent) []*Unspent {
+
+	leases, err := wm.GetUTXOLeases("")
+	if err != nil {
+		wm.Log.Errorf("get utxo leases failed unexpected error: %v", err)
+		return utxos
+	}
+
+	if len(leases) == 0 {
+		return utxos
+	}
+
+	leased := make(map[string]bool, len(leases))
+	for _, lease := range leases {
+		leased[lease.Key] = true
+	}
+
+	result := make([]*Unspent, 0, len(utxos))
+	for _, u := range utxos {
+		if leased[utxoLeaseKey(u.TxID, u.Vout)] {
+			continue
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+//leaseRawTxUTXO 预留交易单使用的utxo，租约id记录在扩展参数leaseID
+func (decoder *TransactionDecoder) leaseRawTxUTXO(rawTx *openwallet.RawTransaction, usedUTXO []*Unspent) error {
+	if err := decoder.wm.CleanExpiredUTXOLeases(); err != nil {
+		decoder.wm.Log.Errorf("clean expired utxo leases failed unexpected error: %v", err)
+	}
+
+	//重新构建的交易单，先释放之前预留的utxo
+	leaseID := rawTx.GetExtParam().Get("leaseID").String()
+	if len(leaseID) > 0 {
+		decoder.releaseRawTxUTXO(rawTx)
+	} else {
+		leaseID = newLeaseID()
+	}
+
+	err := decoder.wm.LeaseUTXO(leaseID, rawTx.Account.AccountID, usedUTXO, decoder.wm.utxoLeaseExpiry())
+	if err != nil {
+		return err
+	}
+
+	return rawTx.SetExtParam("leaseID", leaseID)
+}
+
+//releaseRawTxUTXO 释放交易单预留的utxo
+func (decoder *TransactionDecoder) releaseRawTxUTXO(rawTx *openwallet.RawTransaction) {
+	leaseID := rawTx.GetExtParam().Get("leaseID").String()
+	if len(leaseID) == 0 {
+		return
+	}
+	if err := decoder.wm.ReleaseUTXOLease(leaseID); err != nil {
+		decoder.wm.Log.Errorf("release utxo lease: %s failed unexpected error: %v", leaseID, err)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+
+package syscoin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWalletManager_UTXOLease(t *testing.T) {
+	wm, cleanup := newTestLocalDBWallet(t)
+	defer cleanup()
+
+	utxos := []*Unspent{
+		{TxID: "a", Vout: 0},
+		{TxID: "a", Vout: 1},
+		{TxID: "b", Vout: 0},
+	}
+
+	err := wm.LeaseUTXO("lease1", "account1", utxos[:2], time.Minute)
+	if err != nil {
+		t.Fatalf("LeaseUTXO failed unexpected error: %v", err)
+	}
+
+	//已预留的utxo不能再被其它租约使用
+	if err = wm.LeaseUTXO("lease2", "account1", utxos[1:], time.Minute); err == nil {
+		t.Errorf("leased utxo should not be leased again")
+	}
+
+	result := wm.filterLeasedUTXO(utxos)
+	if len(result) != 1 || result[0].TxID != "b" {
+		t.Errorf("unexpected unleased utxo: %+v", result)
+	}
+
+	if err = wm.SetUTXOLeaseTxID("lease1", "txid"); err != nil {
+		t.Fatalf("SetUTXOLeaseTxID failed unexpected error: %v", err)
+	}
+	leases, err := wm.GetUTXOLeases("account1")
+	if err != nil || len(leases) != 2 || leases[0].TxID != "txid" {
+		t.Errorf("unexpected leases: %+v, err: %v", leases, err)
+	}
+
+	if err = wm.ReleaseUTXOLease("lease1"); err != nil {
+		t.Fatalf("ReleaseUTXOLease failed unexpected error: %v", err)
+	}
+	if n := len(wm.filterLeasedUTXO(utxos)); n != 3 {
+		t.Errorf("unexpected unleased utxo: %d", n)
+	}
+
+	//过期的租约不再预留
+	if err = wm.LeaseUTXO("lease3", "account1", utxos, -time.Second); err != nil {
+		t.Fatalf("LeaseUTXO failed unexpected error: %v", err)
+	}
+	if n := len(wm.filterLeasedUTXO(utxos)); n != 3 {
+		t.Errorf("expired lease should be ignored, unleased utxo: %d", n)
+	}
+	if err = wm.CleanExpiredUTXOLeases(); err != nil {
+		t.Fatalf("CleanExpiredUTXOLeases failed unexpected error: %v", err)
+	}
+}
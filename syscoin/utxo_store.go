@@ -0,0 +1,180 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"fmt"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+//LocalUnspent 本地保存的UTXO，节点不开启钱包时由扫描器维护
+type LocalUnspent struct {
+	Key          string `storm:"id"`
+	TxID         string
+	Vout         uint64
+	Address      string `storm:"index"`
+	AccountID    string
+	ScriptPubKey string
+	Amount       string
+	BlockHeight  uint64 `storm:"index"`
+	SpentTxID    string //花费的交易，空为未花费
+	SpentHeight  uint64 `storm:"index"`
+}
+
+func localUnspentKey(txid string, vout uint64) string {
+	return fmt.Sprintf("%s_%d", txid, vout)
+}
+
+//saveLocalUnspent 根据提取结果更新本地UTXO：记录本地地址收到的输出，标记被花费的输出
+func (wm *WalletManager) saveLocalUnspent(result *ExtractResult) error {
+
+	trx := result.trx
+	if trx == nil || trx.BlockHeight == 0 {
+		return nil
+	}
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, vin := range trx.Vins {
+		if len(vin.Coinbase) > 0 || len(vin.TxID) == 0 {
+			continue
+		}
+		var utxo LocalUnspent
+		err = tx.One("Key", localUnspentKey(vin.TxID, vin.Vout), &utxo)
+		if err != nil {
+			continue
+		}
+		utxo.SpentTxID = trx.TxID
+		utxo.SpentHeight = trx.BlockHeight
+		err = tx.Save(&utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	for accountID, data := range result.extractData {
+		for _, output := range data.TxOutputs {
+			utxo := &LocalUnspent{
+				Key:         localUnspentKey(output.TxID, output.Index),
+				TxID:        output.TxID,
+				Vout:        output.Index,
+				Address:     output.Address,
+				AccountID:   accountID,
+				Amount:      output.Amount,
+				BlockHeight: trx.BlockHeight,
+			}
+			if output.Index < uint64(len(trx.Vouts)) {
+				utxo.ScriptPubKey = trx.Vouts[output.Index].ScriptPubKey
+			}
+			err = tx.Save(utxo)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+//rollbackLocalUnspent 区块分叉时回滚本地UTXO，删除height及以上高度收到的输出，恢复被花费的输出
+func (wm *WalletManager) rollbackLocalUnspent(height uint64) error {
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return err
+	}
+
+	err = db.Select(q.Gte("BlockHeight", height)).Delete(&LocalUnspent{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	var spent []*LocalUnspent
+	err = db.Select(q.Gte("SpentHeight", height)).Find(&spent)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	for _, utxo := range spent {
+		utxo.SpentTxID = ""
+		utxo.SpentHeight = 0
+		err = db.Save(utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//listUnspentByLocal 从本地UTXO查询地址的未花费输出
+func (wm *WalletManager) listUnspentByLocal(min uint64, addresses ...string) ([]*Unspent, error) {
+
+	var (
+		utxos  = make([]*Unspent, 0)
+		result []*LocalUnspent
+	)
+
+	maxHeight, err := wm.GetBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := wm.OpenLocalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Select(q.In("Address", addresses), q.Eq("SpentHeight", uint64(0))).Find(&result)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	for _, u := range result {
+		var confirmations uint64
+		if maxHeight >= u.BlockHeight {
+			confirmations = maxHeight - u.BlockHeight + 1
+		}
+		if confirmations < min {
+			continue
+		}
+		utxos = append(utxos, &Unspent{
+			Key:           u.Key,
+			TxID:          u.TxID,
+			Vout:          u.Vout,
+			Address:       u.Address,
+			AccountID:     u.AccountID,
+			ScriptPubKey:  u.ScriptPubKey,
+			Amount:        u.Amount,
+			Confirmations: confirmations,
+			Spendable:     true,
+			Solvable:      true,
+		})
+	}
+
+	return utxos, nil
+}
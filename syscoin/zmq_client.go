@@ -0,0 +1,297 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+//节点ZMQ推送的主题
+const (
+	ZMQTopicHashBlock = "hashblock"
+	ZMQTopicRawTx     = "rawtx"
+	ZMQTopicSequence  = "sequence"
+)
+
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+
+	zmqMaxFrameSize = 32 * 1024 * 1024
+	zmqDialTimeout  = 10 * time.Second
+)
+
+//ZMQMessage 节点推送的消息
+type ZMQMessage struct {
+	Topic    string
+	Body     []byte
+	Sequence uint32
+}
+
+//ZMQSubscriber 实现ZMTP 3.0协议的SUB客户端，只用于订阅核心钱包的zmqpub推送
+type ZMQSubscriber struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+//DialZMQ 连接核心钱包的ZMQ推送地址，并订阅主题
+func DialZMQ(endpoint string, topics ...string) (*ZMQSubscriber, error) {
+
+	address := endpoint
+	if u, err := url.Parse(endpoint); err == nil && len(u.Host) > 0 {
+		if u.Scheme != "tcp" {
+			return nil, fmt.Errorf("zmq endpoint scheme: %s is not supported", u.Scheme)
+		}
+		address = u.Host
+	}
+
+	conn, err := net.DialTimeout("tcp", address, zmqDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &ZMQSubscriber{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	//握手过程设置超时，避免对端无响应时卡住
+	conn.SetDeadline(time.Now().Add(zmqDialTimeout))
+
+	err = sub.handshake()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for _, topic := range topics {
+		err = sub.subscribe(topic)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return sub, nil
+}
+
+//handshake 交换greeting，并使用NULL机制完成READY命令
+func (sub *ZMQSubscriber) handshake() error {
+
+	greeting := make([]byte, 64)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 //major version
+	greeting[11] = 0 //minor version
+	copy(greeting[12:32], "NULL")
+
+	_, err := sub.conn.Write(greeting)
+	if err != nil {
+		return err
+	}
+
+	peer := make([]byte, 64)
+	_, err = io.ReadFull(sub.reader, peer)
+	if err != nil {
+		return err
+	}
+
+	if peer[0] != 0xff || peer[9] != 0x7f {
+		return errors.New("zmq peer greeting signature is invalid")
+	}
+
+	if peer[10] < 3 {
+		return fmt.Errorf("zmq peer version: %d is not supported", peer[10])
+	}
+
+	if mechanism := string(trimZero(peer[12:32])); mechanism != "NULL" {
+		return fmt.Errorf("zmq peer mechanism: %s is not supported", mechanism)
+	}
+
+	//READY命令，声明本端为SUB
+	ready := zmqCommandBody("READY", map[string]string{"Socket-Type": "SUB"})
+	err = sub.writeFrame(zmqFlagCommand, ready)
+	if err != nil {
+		return err
+	}
+
+	flags, body, err := sub.readFrame()
+	if err != nil {
+		return err
+	}
+
+	if flags&zmqFlagCommand == 0 {
+		return errors.New("zmq peer did not send READY command")
+	}
+
+	name, _ := parseZMQCommand(body)
+	if name == "ERROR" {
+		return errors.New("zmq peer rejected handshake")
+	}
+	if name != "READY" {
+		return fmt.Errorf("zmq peer send unexpected command: %s", name)
+	}
+
+	return nil
+}
+
+//subscribe 订阅主题，兼容ZMTP 3.0的订阅消息格式
+func (sub *ZMQSubscriber) subscribe(topic string) error {
+	body := append([]byte{0x01}, []byte(topic)...)
+	return sub.writeFrame(0, body)
+}
+
+//ReadMessage 读取一条完整的推送消息
+func (sub *ZMQSubscriber) ReadMessage() (*ZMQMessage, error) {
+
+	var (
+		parts = make([][]byte, 0, 3)
+	)
+
+	for {
+		flags, body, err := sub.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		//忽略PING等命令帧
+		if flags&zmqFlagCommand != 0 {
+			continue
+		}
+
+		parts = append(parts, body)
+
+		if flags&zmqFlagMore == 0 {
+			break
+		}
+	}
+
+	//核心钱包推送格式：主题 | 内容 | 4字节序号
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("zmq message parts: %d is invalid", len(parts))
+	}
+
+	msg := &ZMQMessage{
+		Topic: string(parts[0]),
+		Body:  parts[1],
+	}
+
+	if len(parts) > 2 && len(parts[2]) == 4 {
+		msg.Sequence = binary.LittleEndian.Uint32(parts[2])
+	}
+
+	return msg, nil
+}
+
+//Close 关闭连接
+func (sub *ZMQSubscriber) Close() error {
+	return sub.conn.Close()
+}
+
+func (sub *ZMQSubscriber) writeFrame(flags byte, body []byte) error {
+
+	var header []byte
+
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | zmqFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+
+	_, err := sub.conn.Write(append(header, body...))
+	return err
+}
+
+func (sub *ZMQSubscriber) readFrame() (byte, []byte, error) {
+
+	flags, err := sub.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+	if flags&zmqFlagLong != 0 {
+		buf := make([]byte, 8)
+		_, err = io.ReadFull(sub.reader, buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(buf)
+	} else {
+		b, err := sub.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+
+	if size > zmqMaxFrameSize {
+		return 0, nil, fmt.Errorf("zmq frame size: %d is too large", size)
+	}
+
+	body := make([]byte, size)
+	_, err = io.ReadFull(sub.reader, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return flags, body, nil
+}
+
+//zmqCommandBody 编码命令帧内容
+func zmqCommandBody(name string, properties map[string]string) []byte {
+	body := []byte{byte(len(name))}
+	body = append(body, []byte(name)...)
+	for k, v := range properties {
+		body = append(body, byte(len(k)))
+		body = append(body, []byte(k)...)
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(v)))
+		body = append(body, size...)
+		body = append(body, []byte(v)...)
+	}
+	return body
+}
+
+//parseZMQCommand 解析命令帧的名称和数据
+func parseZMQCommand(body []byte) (string, []byte) {
+	if len(body) == 0 || int(body[0]) > len(body)-1 {
+		return "", nil
+	}
+	n := int(body[0])
+	return string(body[1 : 1+n]), body[1+n:]
+}
+
+func trimZero(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
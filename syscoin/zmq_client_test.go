@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package syscoin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+//testZMQPublisher 模拟核心钱包的zmqpub，完成握手后推送一条消息
+func testZMQPublisher(t *testing.T, ln net.Listener, topic string, body []byte) {
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept failed unexpected error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	pub := &ZMQSubscriber{conn: conn, reader: bufio.NewReader(conn)}
+
+	greeting := make([]byte, 64)
+	io.ReadFull(pub.reader, greeting)
+
+	reply := make([]byte, 64)
+	reply[0] = 0xff
+	reply[9] = 0x7f
+	reply[10] = 3
+	reply[11] = 1
+	copy(reply[12:32], "NULL")
+	conn.Write(reply)
+
+	//READY
+	pub.readFrame()
+	pub.writeFrame(zmqFlagCommand, zmqCommandBody("READY", map[string]string{"Socket-Type": "PUB"}))
+
+	//subscribe
+	_, sub, _ := pub.readFrame()
+	if sub[0] != 0x01 || string(sub[1:]) != topic {
+		t.Errorf("unexpected subscription: %x", sub)
+	}
+
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, 7)
+	pub.writeFrame(zmqFlagMore, []byte(topic))
+	pub.writeFrame(zmqFlagMore, body)
+	pub.writeFrame(0, seq)
+}
+
+func TestDialZMQ(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	body := make([]byte, 300)
+	body[0] = 0xab
+	go testZMQPublisher(t, ln, ZMQTopicRawTx, body)
+
+	sub, err := DialZMQ("tcp://"+ln.Addr().String(), ZMQTopicRawTx)
+	if err != nil {
+		t.Fatalf("DialZMQ failed unexpected error: %v", err)
+	}
+	defer sub.Close()
+
+	msg, err := sub.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed unexpected error: %v", err)
+	}
+
+	if msg.Topic != ZMQTopicRawTx || len(msg.Body) != 300 || msg.Body[0] != 0xab || msg.Sequence != 7 {
+		t.Errorf("unexpected message: %s %d %d", msg.Topic, len(msg.Body), msg.Sequence)
+	}
+}